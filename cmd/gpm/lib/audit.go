@@ -0,0 +1,417 @@
+package gpm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"gopkg.in/yaml.v3"
+)
+
+// Advisory is a security advisory in OSV format (https://ossf.github.io/osv-schema/),
+// loaded from YAML or JSON files in the advisory database
+type Advisory struct {
+	ID       string             `yaml:"id" json:"id"`                     // ID is the advisory identifier
+	Aliases  []string           `yaml:"aliases" json:"aliases,omitempty"` // Aliases are other identifiers for the advisory (ie. CVEs)
+	Summary  string             `yaml:"summary" json:"summary,omitempty"` // Summary is a one line description of the advisory
+	Affected []advisoryAffected `yaml:"affected" json:"-"`                // Affected lists the affected repositories and versions
+	File     string             `yaml:"-" json:"file"`                    // File is the database file the advisory was loaded from
+}
+
+// advisoryAffected is a repository and the versions affected by an advisory
+type advisoryAffected struct {
+	Package struct {
+		Ecosystem string `yaml:"ecosystem"`
+		Name      string `yaml:"name"`
+	} `yaml:"package"`
+	Ranges   []advisoryRange `yaml:"ranges"`
+	Versions []string        `yaml:"versions"`
+}
+
+// advisoryRange is a range of affected versions, described by introduced and fixed events
+type advisoryRange struct {
+	Type   string `yaml:"type"`
+	Repo   string `yaml:"repo"`
+	Events []struct {
+		Introduced   string `yaml:"introduced"`
+		Fixed        string `yaml:"fixed"`
+		LastAffected string `yaml:"last_affected"`
+	} `yaml:"events"`
+
+	events []rangeEvent
+}
+
+// rangeEvent is a parsed range event, where a nil version precedes all versions
+type rangeEvent struct {
+	kind    string
+	version *semver.Version
+}
+
+// Range event kinds
+const (
+	eventIntroduced   = "introduced"
+	eventFixed        = "fixed"
+	eventLastAffected = "last_affected"
+)
+
+// AuditFinding is a locked module version affected by known advisories
+type AuditFinding struct {
+	Path       string     `json:"path"`                // Path is the module path
+	URL        string     `json:"url"`                 // URL is the module remote
+	Version    string     `json:"version"`             // Version is the locked tag
+	Hash       string     `json:"hash"`                // Hash is the locked commit hash
+	Advisories []Advisory `json:"advisories"`          // Advisories affecting the locked version
+	Fixed      string     `json:"fixed,omitempty"`     // Fixed is the minimal version not affected by any advisory, if available
+	Unaudited  string     `json:"unaudited,omitempty"` // Unaudited is the reason the locked version could not be checked against matching advisories
+}
+
+// String formats an audit finding as a human readable summary line
+func (f AuditFinding) String() string {
+	ids := make([]string, 0, len(f.Advisories))
+	for _, a := range f.Advisories {
+		ids = append(ids, a.ID)
+	}
+	if f.Unaudited != "" {
+		return fmt.Sprintf("%s: unable to audit %s against %s (%s)", f.Path, moduleVersion(Module{Tag: f.Version, Hash: f.Hash}), strings.Join(ids, ", "), f.Unaudited)
+	}
+	fixed := "no fixed version"
+	if f.Fixed != "" {
+		fixed = fmt.Sprintf("fixed in %s", f.Fixed)
+	}
+	return fmt.Sprintf("%s: %s affected by %s (%s)", f.Path, f.Version, strings.Join(ids, ", "), fixed)
+}
+
+// Audit checks the locked version of each module against the advisory database, returning
+// the affected modules with the minimal fixed version. Tags for modules that are not synced
+// are resolved from the module remote. Modules matching advisories whose locked version
+// cannot be determined are returned as unaudited. Returns ErrVulnerable where any module
+// is affected, otherwise ErrUnaudited where any module could not be audited.
+func (gpm *GPM) Audit(ctx context.Context, ao *AuditOptions) ([]AuditFinding, error) {
+	if ao.Database == "" {
+		return nil, errorf(ErrInvalidOptions, "No advisory database provided, use --db or GPM_ADVISORY_DB")
+	}
+	advisories, err := loadAdvisories(ao.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+	locks, err := gpm.loadLockfile()
+	if err != nil {
+		return nil, err
+	}
+	modules, err := gpm.lockedModules(ctx, &pc, locks)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := make([]AuditFinding, 0)
+	vulnerable, unaudited := 0, 0
+
+	for _, m := range modules {
+		affected := moduleAdvisories(advisories, m.URL)
+		if len(affected) == 0 {
+			continue
+		}
+
+		// Resolve tags from the remote for modules that are not synced
+		if m.Tag == "" {
+			tag, err := gpm.remoteTag(ctx, m)
+			if err != nil {
+				if cerr := canceled(ctx); cerr != nil {
+					return nil, cerr
+				}
+				gpm.logf("Warning: unable to fetch tags for module '%s' (%s)\n", m.Path, err)
+			}
+			m.Tag = tag
+		}
+
+		f := AuditFinding{Path: m.Path, URL: m.URL, Version: m.Tag, Hash: m.Hash, Advisories: make([]Advisory, 0)}
+
+		v, err := semver.NewVersion(m.Tag)
+		if m.Tag == "" {
+			f.Unaudited = "locked commit is not a tagged version"
+		} else if err != nil {
+			f.Unaudited = fmt.Sprintf("invalid version '%s' (%s)", m.Tag, err)
+		}
+		if f.Unaudited != "" {
+			f.Advisories = affected
+			findings = append(findings, f)
+			unaudited++
+			continue
+		}
+
+		for _, a := range affected {
+			if a.affects(m.URL, v) {
+				f.Advisories = append(f.Advisories, a)
+			}
+		}
+
+		if gpm.options.Verbose {
+			gpm.logf("Audit (%s) version '%s' matched %d of %d advisories", m.Path, m.Tag, len(f.Advisories), len(affected))
+		}
+		if len(f.Advisories) == 0 {
+			continue
+		}
+
+		if fixed := fixedVersion(affected, m.URL, v); fixed != nil {
+			f.Fixed = fixed.Original()
+		}
+		findings = append(findings, f)
+		vulnerable++
+	}
+
+	switch {
+	case vulnerable > 0:
+		return findings, errorf(ErrVulnerable, "%d module(s) affected by known advisories", vulnerable)
+	case unaudited > 0:
+		return findings, errorf(ErrUnaudited, "%d module(s) matching advisories could not be audited", unaudited)
+	}
+
+	return findings, nil
+}
+
+// remoteTag resolves the tag for the locked commit of a module from the module remote,
+// using a temporary clone, returning an empty tag where the commit is not tagged
+func (gpm *GPM) remoteTag(ctx context.Context, m Module) (string, error) {
+	dir, err := ioutil.TempDir("", "gpm-audit")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	repo := gpm.newRepo(&m.Dependency, filepath.Join(dir, "module"))
+	if err := repo.Clone(ctx); err != nil {
+		return "", contextError(ctx, err)
+	}
+
+	return gpm.newModule(m.Dependency, repo, m.Hash).Tag, nil
+}
+
+// loadAdvisories loads the advisories in an advisory database directory
+func loadAdvisories(dir string) ([]Advisory, error) {
+	files, err := readDataDir(dir, isAdvisoryFile)
+	if err != nil {
+		return nil, wrapError(ErrFilesystem, err, "Error reading advisory database '%s'", dir)
+	}
+
+	names := make([]string, 0, len(files))
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	advisories := make([]Advisory, 0, len(files))
+	for _, n := range names {
+		a := Advisory{}
+		if err := yaml.Unmarshal(files[n], &a); err != nil {
+			return nil, wrapError(ErrFilesystem, err, "Error decoding advisory '%s' in database '%s'", n, dir)
+		}
+		if err := a.parse(); err != nil {
+			return nil, wrapError(ErrFilesystem, err, "Invalid advisory '%s' in database '%s'", n, dir)
+		}
+		a.File = n
+		advisories = append(advisories, a)
+	}
+
+	return advisories, nil
+}
+
+// isAdvisoryFile checks whether a file within an advisory database holds an advisory
+func isAdvisoryFile(name string) bool {
+	return isIndexFile(name) || filepath.Ext(name) == ".json"
+}
+
+// parse validates an advisory and parses the events of semver ranges
+func (a *Advisory) parse() error {
+	if a.ID == "" {
+		return fmt.Errorf("missing id")
+	}
+
+	for i := range a.Affected {
+		for _, v := range a.Affected[i].Versions {
+			if _, err := semver.NewVersion(v); err != nil {
+				return fmt.Errorf("invalid version '%s' (%s)", v, err)
+			}
+		}
+
+		for j := range a.Affected[i].Ranges {
+			r := &a.Affected[i].Ranges[j]
+			if !r.semver() {
+				continue
+			}
+
+			r.events = make([]rangeEvent, 0, len(r.Events))
+			for _, e := range r.Events {
+				kind, version := eventIntroduced, e.Introduced
+				if e.Fixed != "" {
+					kind, version = eventFixed, e.Fixed
+				} else if e.LastAffected != "" {
+					kind, version = eventLastAffected, e.LastAffected
+				}
+
+				// An introduced version of 0 affects all prior versions
+				if kind == eventIntroduced && version == "0" {
+					r.events = append(r.events, rangeEvent{kind: kind})
+					continue
+				}
+				v, err := semver.NewVersion(version)
+				if err != nil {
+					return fmt.Errorf("invalid %s version '%s' (%s)", kind, version, err)
+				}
+				r.events = append(r.events, rangeEvent{kind: kind, version: v})
+			}
+
+			sort.SliceStable(r.events, func(i, j int) bool {
+				vi, vj := r.events[i].version, r.events[j].version
+				return vi == nil && vj != nil || vi != nil && vj != nil && vi.LessThan(vj)
+			})
+		}
+	}
+
+	return nil
+}
+
+// affects checks whether an advisory affects a version of the repository with the provided URL
+func (a *Advisory) affects(url string, v *semver.Version) bool {
+	for _, affected := range a.Affected {
+		if affected.matches(url) && affected.affects(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches checks whether an affected entry refers to the repository with the provided URL,
+// via the package name or the repository of any range
+func (a *advisoryAffected) matches(url string) bool {
+	url = normalizeURL(url)
+	if remoteIndex(a.Package.Name) && normalizeURL(a.Package.Name) == url {
+		return true
+	}
+	for _, r := range a.Ranges {
+		if r.Repo != "" && normalizeURL(r.Repo) == url {
+			return true
+		}
+	}
+	return false
+}
+
+// affects checks whether a version is listed or within any semver range of an affected entry
+func (a *advisoryAffected) affects(v *semver.Version) bool {
+	for _, s := range a.Versions {
+		if lv, err := semver.NewVersion(s); err == nil && lv.Equal(v) {
+			return true
+		}
+	}
+	for _, r := range a.Ranges {
+		if r.affects(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// semver checks whether a range is ordered by semver (rather than git commits)
+func (r *advisoryRange) semver() bool {
+	return r.Type == "SEMVER" || r.Type == "ECOSYSTEM"
+}
+
+// affects evaluates the sorted range events up to a version, where a version is affected
+// following an introduced event until a fixed event or after a last affected event
+func (r *advisoryRange) affects(v *semver.Version) bool {
+	affected := false
+	for _, e := range r.events {
+		if e.version != nil && e.version.GreaterThan(v) {
+			break
+		}
+		switch e.kind {
+		case eventIntroduced:
+			affected = true
+		case eventFixed:
+			affected = false
+		case eventLastAffected:
+			if e.version.LessThan(v) {
+				affected = false
+			}
+		}
+	}
+	return affected
+}
+
+// moduleAdvisories selects the advisories referring to the repository with the provided URL
+func moduleAdvisories(advisories []Advisory, url string) []Advisory {
+	matching := make([]Advisory, 0)
+	for _, a := range advisories {
+		for _, affected := range a.Affected {
+			if affected.matches(url) {
+				matching = append(matching, a)
+				break
+			}
+		}
+	}
+	return matching
+}
+
+// fixedVersion finds the minimal fixed version above the provided version that is not
+// affected by any of the advisories, or nil where no such version is known
+func fixedVersion(advisories []Advisory, url string, v *semver.Version) *semver.Version {
+	candidates := make([]*semver.Version, 0)
+	for _, a := range advisories {
+		for _, affected := range a.Affected {
+			if !affected.matches(url) {
+				continue
+			}
+			for _, r := range affected.Ranges {
+				for _, e := range r.events {
+					if e.kind == eventFixed && e.version.GreaterThan(v) {
+						candidates = append(candidates, e.version)
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].LessThan(candidates[j]) })
+
+	for _, c := range candidates {
+		fixed := true
+		for _, a := range advisories {
+			if a.affects(url, c) {
+				fixed = false
+				break
+			}
+		}
+		if fixed {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// normalizeURL normalises a git URL for comparison, ignoring the scheme, user, case of
+// the host and any trailing slash or .git suffix (ie. git@github.com:org/lib.git and
+// https://github.com/org/lib both normalise to github.com/org/lib)
+func normalizeURL(url string) string {
+	url = strings.TrimSpace(url)
+	if i := strings.Index(url, "://"); i >= 0 {
+		url = url[i+3:]
+		if at, slash := strings.Index(url, "@"), strings.Index(url+"/", "/"); at >= 0 && at < slash {
+			url = url[at+1:]
+		}
+	} else if prefix := scpURL.FindString(url); prefix != "" {
+		url = prefix[strings.Index(prefix, "@")+1:len(prefix)-1] + "/" + url[len(prefix):]
+	}
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+
+	host := strings.Index(url+"/", "/")
+	return strings.ToLower(url[:host]) + url[host:]
+}
@@ -0,0 +1,215 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		url, normalized string
+	}{
+		{"https://github.com/org/lib", "github.com/org/lib"},
+		{"https://GitHub.com/org/Lib.git/", "github.com/org/Lib"},
+		{"ssh://git@github.com/org/lib.git", "github.com/org/lib"},
+		{"git@github.com:org/lib.git", "github.com/org/lib"},
+		{"file:///tmp/module/bare.git", "/tmp/module/bare"},
+	}
+
+	for _, test := range tests {
+		assert.EqualValues(t, test.normalized, normalizeURL(test.url), test.url)
+	}
+}
+
+func TestAdvisories(t *testing.T) {
+	url := "https://github.com/org/lib"
+
+	parse := func(t *testing.T, d string) Advisory {
+		a := Advisory{}
+		assert.Nil(t, yaml.Unmarshal([]byte(d), &a))
+		assert.Nil(t, a.parse())
+		return a
+	}
+
+	overflow := parse(t, "id: GPM-0001\n"+
+		"affected:\n"+
+		"- package: {name: 'git@github.com:org/lib.git'}\n"+
+		"  ranges:\n"+
+		"  - type: SEMVER\n"+
+		"    events: [{fixed: 1.2.0}, {introduced: '0'}, {introduced: 2.0.0}, {last_affected: 2.1.0}]\n"+
+		"  versions: [3.0.0]\n")
+	regression := parse(t, "id: GPM-0002\n"+
+		"affected:\n"+
+		"- ranges:\n"+
+		"  - {type: GIT, repo: 'https://github.com/org/lib.git', events: [{introduced: abcd}]}\n"+
+		"  - {type: SEMVER, repo: 'https://github.com/org/lib.git', events: [{introduced: 1.2.0}, {fixed: 1.3.0}]}\n")
+
+	t.Run("Evaluates range events and versions", func(t *testing.T) {
+		tests := []struct {
+			version  string
+			affected bool
+		}{
+			{"0.1.0", true},
+			{"1.1.9", true},
+			{"1.2.0", false},
+			{"2.0.0", true},
+			{"2.1.0", true},
+			{"2.1.1", false},
+			{"3.0.0", true},
+		}
+
+		for _, test := range tests {
+			assert.EqualValues(t, test.affected, overflow.affects(url, semver.MustParse(test.version)), test.version)
+		}
+		assert.False(t, overflow.affects("https://github.com/org/other", semver.MustParse("0.1.0")))
+	})
+
+	t.Run("Selects the minimal version fixing all advisories", func(t *testing.T) {
+		advisories := []Advisory{overflow, regression}
+
+		assert.EqualValues(t, "1.3.0", fixedVersion(advisories, url, semver.MustParse("1.0.0")).Original())
+		assert.EqualValues(t, "1.3.0", fixedVersion(advisories, url, semver.MustParse("1.2.0")).Original())
+		assert.Nil(t, fixedVersion(advisories, url, semver.MustParse("2.0.0")))
+	})
+
+	t.Run("Rejects invalid advisories", func(t *testing.T) {
+		for _, d := range []string{
+			"affected: []\n",
+			"id: GPM-0003\naffected: [{versions: [latest]}]\n",
+			"id: GPM-0003\naffected: [{ranges: [{type: SEMVER, events: [{fixed: latest}]}]}]\n",
+		} {
+			a := Advisory{}
+			assert.Nil(t, yaml.Unmarshal([]byte(d), &a))
+			assert.NotNil(t, a.parse(), d)
+		}
+	})
+}
+
+func TestAudit(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0", "v0.3.0")
+	other := gpmtest.Versions(t, filepath.Join(testDir, "other"), "v1.0.0")
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+	o := CommonOptions{BasePath: projectDir, Verbose: true}
+	gpm := NewGPM(&o)
+	ctx := context.Background()
+
+	assert.Nil(t, gpm.writeProjectConfig(&ProjectConfig{Name: "project"}))
+	assert.Nil(t, gpm.writeLockfile(&Locks{}))
+	for _, ao := range []AddOptions{
+		{Path: "lib/a", URL: module.URL(), Version: "v0.1.0"},
+		{Path: "lib/b", URL: module.URL() + "/", Version: "v0.3.0"},
+		{Path: "lib/c", URL: other.URL(), Version: "v1.0.0"},
+	} {
+		_, err := gpm.Add(ctx, &ao)
+		assert.Nil(t, err)
+	}
+
+	dbDir := filepath.Join(testDir, "advisories")
+	assert.Nil(t, os.MkdirAll(filepath.Join(dbDir, ".git"), 0755))
+	write := func(name, data string) {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dbDir, name), []byte(data), 0644))
+	}
+
+	write("GPM-0001.yml", "id: GPM-0001\n"+
+		"aliases: [CVE-2024-0001]\n"+
+		"summary: Parser overflow\n"+
+		"affected:\n"+
+		"- package: {name: '"+module.URL()+"'}\n"+
+		"  ranges: [{type: SEMVER, events: [{introduced: '0'}, {fixed: 0.2.0}]}]\n")
+	write("GPM-0002.json", `{"id": "GPM-0002", "affected": [{"ranges": [`+
+		`{"type": "SEMVER", "repo": "`+module.URL()+`", "events": [{"introduced": "0.1.0"}, {"fixed": "0.3.0"}]}]}]}`)
+	write("README.md", "Advisory database\n")
+	write(".git/config.yml", "not: an advisory\n")
+
+	t.Run("Reports affected modules with the minimal fixed version", func(t *testing.T) {
+		findings, err := gpm.Audit(ctx, &AuditOptions{Database: dbDir})
+		assert.EqualValues(t, ErrVulnerable, Code(err))
+
+		if assert.Len(t, findings, 1) {
+			f := findings[0]
+			assert.EqualValues(t, "lib/a", f.Path)
+			assert.EqualValues(t, "v0.1.0", f.Version)
+			assert.EqualValues(t, module.Hashes["v0.1.0"], f.Hash)
+			assert.EqualValues(t, "0.3.0", f.Fixed)
+			assert.EqualValues(t, []string{"GPM-0001.yml", "GPM-0002.json"}, []string{f.Advisories[0].File, f.Advisories[1].File})
+			assert.EqualValues(t, "lib/a: v0.1.0 affected by GPM-0001, GPM-0002 (fixed in 0.3.0)", f.String())
+		}
+	})
+
+	t.Run("Resolves tags for modules that are not synced", func(t *testing.T) {
+		assert.Nil(t, os.RemoveAll(filepath.Join(projectDir, "lib/a")))
+
+		findings, err := gpm.Audit(ctx, &AuditOptions{Database: dbDir})
+		assert.EqualValues(t, ErrVulnerable, Code(err))
+		if assert.Len(t, findings, 1) {
+			assert.EqualValues(t, "v0.1.0", findings[0].Version)
+			assert.EqualValues(t, "0.3.0", findings[0].Fixed)
+		}
+	})
+
+	t.Run("Passes where no modules are affected", func(t *testing.T) {
+		_, err := gpm.Remove(ctx, &RemoveOptions{Path: "lib/a"})
+		assert.Nil(t, err)
+
+		findings, err := gpm.Audit(ctx, &AuditOptions{Database: dbDir})
+		assert.Nil(t, err)
+		assert.Empty(t, findings)
+	})
+
+	t.Run("Reports modules locked to untagged commits as unaudited", func(t *testing.T) {
+		hash := module.Commit("untagged", nil)
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		original := locks["lib/b"]
+		lock := original
+		lock.Hash = hash
+		locks["lib/b"] = lock
+		assert.Nil(t, gpm.writeLockfile(&locks))
+		assert.Nil(t, os.RemoveAll(filepath.Join(projectDir, "lib/b")))
+
+		findings, err := gpm.Audit(ctx, &AuditOptions{Database: dbDir})
+		assert.EqualValues(t, ErrUnaudited, Code(err))
+		if assert.Len(t, findings, 1) {
+			f := findings[0]
+			assert.EqualValues(t, "lib/b", f.Path)
+			assert.EqualValues(t, "", f.Version)
+			assert.NotEmpty(t, f.Unaudited)
+			assert.Len(t, f.Advisories, 2)
+			assert.EqualValues(t, "lib/b: unable to audit "+hash+" against GPM-0001, GPM-0002 (locked commit is not a tagged version)", f.String())
+		}
+
+		locks["lib/b"] = original
+		assert.Nil(t, gpm.writeLockfile(&locks))
+	})
+
+	t.Run("Rejects missing and invalid databases", func(t *testing.T) {
+		_, err := gpm.Audit(ctx, &AuditOptions{})
+		assert.EqualValues(t, ErrInvalidOptions, Code(err))
+
+		_, err = gpm.Audit(ctx, &AuditOptions{Database: filepath.Join(testDir, "missing")})
+		assert.EqualValues(t, ErrFilesystem, Code(err))
+
+		write("GPM-0003.yml", "id: GPM-0003\naffected: [{versions: [latest]}]\n")
+		_, err = gpm.Audit(ctx, &AuditOptions{Database: dbDir})
+		assert.EqualValues(t, ErrFilesystem, Code(err))
+	})
+}
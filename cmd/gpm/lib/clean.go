@@ -0,0 +1,604 @@
+package gpm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ModuleMarkerName is the metadata file marking gpm managed modules, stored in the
+	// module git directory so the worktree is unaffected
+	ModuleMarkerName = "gpm-module.yml"
+)
+
+// ModuleMarker is the metadata recorded for each gpm managed module
+type ModuleMarker struct {
+	Path string `yaml:"path"` // Path is the project relative module path
+	URL  string `yaml:"url"`  // URL is the module repository URL
+}
+
+// Orphan is a gpm managed module directory no longer bound to a project dependency
+type Orphan struct {
+	Path     string `json:"path"`     // Path is the project relative module directory
+	URL      string `json:"url"`      // URL is the module repository URL recorded when the module was synced
+	Modified bool   `json:"modified"` // Modified is set where the module has uncommitted or untracked changes
+	Removed  bool   `json:"removed"`  // Removed is set once the module directory has been deleted
+}
+
+// String formats an orphaned module as a human readable summary line
+func (o Orphan) String() string {
+	state := "orphaned"
+	if o.Removed {
+		state = "removed"
+	} else if o.Modified {
+		state = "modified"
+	}
+	return fmt.Sprintf("%s: %s (%s)", o.Path, state, o.URL)
+}
+
+// Collected is the result of garbage collecting a module repository
+type Collected struct {
+	Path       string `json:"path"`       // Path is the project relative module path
+	Objects    int    `json:"objects"`    // Objects is the number of unreachable git objects pruned
+	LFSObjects int    `json:"lfsObjects"` // LFSObjects is the number of unreferenced cached LFS objects pruned
+	Skipped    bool   `json:"skipped"`    // Skipped is set where the module has uncommitted or untracked changes
+}
+
+// String formats a garbage collection result as a human readable summary line
+func (c Collected) String() string {
+	if c.Skipped {
+		return fmt.Sprintf("%s: skipped (modified)", c.Path)
+	}
+	return fmt.Sprintf("%s: pruned %d objects, %d LFS objects", c.Path, c.Objects, c.LFSObjects)
+}
+
+// Clean removes gpm managed module directories that are no longer project dependencies,
+// refusing to remove modules with local changes unless forced
+func (gpm *GPM) Clean(ctx context.Context, co *CleanOptions) (orphans []Orphan, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	orphans, err = gpm.findOrphans(&pc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check every orphan for local changes before removing any
+	modified := 0
+	for i, o := range orphans {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+
+		modulePath, err := gpm.modulePath(o.Path)
+		if err != nil {
+			return nil, err
+		}
+		repo := NewRepo(modulePath, o.URL)
+		if err := repo.Open(); err != nil {
+			return nil, wrapError(ErrRepository, err, "Error opening module '%s'", o.Path)
+		}
+		orphans[i].Modified, err = repo.Modified()
+		if err != nil {
+			return nil, wrapError(ErrRepository, err, "Error reading status of module '%s'", o.Path)
+		}
+		if orphans[i].Modified {
+			modified++
+		}
+	}
+
+	if co.DryRun {
+		return orphans, nil
+	}
+	if modified > 0 && !co.Force {
+		return orphans, errorf(ErrDirtyModule, "%d orphaned module(s) have local changes (use --force to remove)", modified)
+	}
+
+	for _, o := range orphans {
+		if err := gpm.removeGitIgnore(o.Path); err != nil {
+			return orphans, err
+		}
+	}
+
+	// Remove modules last, as module directories cannot be restored on failure
+	for i, o := range orphans {
+		if gpm.options.Verbose {
+			gpm.logf("Clean (%s) removing orphaned module", o.Path)
+		}
+		modulePath, err := gpm.modulePath(o.Path)
+		if err != nil {
+			return orphans, err
+		}
+		if err := os.RemoveAll(modulePath); err != nil {
+			return orphans, wrapError(ErrFilesystem, err, "Error removing module '%s'", o.Path)
+		}
+		orphans[i].Removed = true
+	}
+
+	return orphans, nil
+}
+
+// GC prunes unreachable git objects and unreferenced LFS objects from each module repository,
+// skipping modules with uncommitted or untracked changes
+func (gpm *GPM) GC(ctx context.Context, gco *GCOptions) (collected []Collected, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	collected = make([]Collected, 0, len(pc.Dependencies))
+	for _, d := range pc.Dependencies {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+
+		modulePath, err := gpm.modulePath(d.Path)
+		if err != nil {
+			return nil, err
+		}
+		repo := gpm.newRepo(&d, modulePath)
+		if !repo.Exists() {
+			if gpm.options.Verbose {
+				gpm.logf("GC (%s) not found, skipping", d.Path)
+			}
+			continue
+		}
+		if err := repo.Open(); err != nil {
+			return nil, wrapError(ErrRepository, err, "Error opening module '%s'", d.Path)
+		}
+
+		// Objects for local changes may only be referenced by the index or worktree
+		modified, err := repo.Modified()
+		if err != nil {
+			return nil, wrapError(ErrRepository, err, "Error reading status for module '%s'", d.Path)
+		}
+		if modified {
+			gpm.logf("Warning: skipping garbage collection for module '%s' with uncommitted or untracked changes\n", d.Path)
+			collected = append(collected, Collected{Path: d.Path, Skipped: true})
+			continue
+		}
+
+		cutoff := time.Time{}
+		if gco.Prune > 0 {
+			cutoff = time.Now().Add(-gco.Prune)
+		}
+
+		c := Collected{Path: d.Path}
+		c.Objects, c.LFSObjects, err = repo.GC(cutoff)
+		if err != nil {
+			return nil, wrapError(ErrRepository, err, "Error collecting garbage for module '%s'", d.Path)
+		}
+		if gpm.options.Verbose {
+			gpm.logf("GC (%s) pruned %d objects, %d LFS objects", d.Path, c.Objects, c.LFSObjects)
+		}
+		collected = append(collected, c)
+	}
+
+	return collected, nil
+}
+
+// markModule records the module metadata in the module git directory
+func (gpm *GPM) markModule(repo *Repo) error {
+	b, err := yaml.Marshal(&ModuleMarker{Path: filepath.ToSlash(gpm.repoPath(repo)), URL: repo.url})
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(gitDir(repo.path), ModuleMarkerName), b)
+}
+
+// gitDir resolves the git directory for a worktree, following `gitdir:` files used by
+// submodules and linked worktrees
+func gitDir(worktree string) string {
+	p := filepath.Join(worktree, ".git")
+
+	d, err := ioutil.ReadFile(p)
+	if err != nil || !strings.HasPrefix(string(d), "gitdir: ") {
+		return p
+	}
+
+	dir := strings.TrimSpace(strings.TrimPrefix(string(d), "gitdir: "))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(worktree, dir)
+	}
+	return dir
+}
+
+// findOrphans walks the project directory for gpm managed modules not bound to a dependency
+func (gpm *GPM) findOrphans(pc *ProjectConfig) ([]Orphan, error) {
+	orphans := make([]Orphan, 0)
+
+	err := filepath.Walk(gpm.options.BasePath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		d, err := ioutil.ReadFile(filepath.Join(gitDir(p), ModuleMarkerName))
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// Modules are not searched for nested modules
+		rel, err := filepath.Rel(gpm.options.BasePath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if _, ok := pc.Dependencies.Find(rel); ok {
+			return filepath.SkipDir
+		}
+
+		marker := ModuleMarker{}
+		if err := yaml.Unmarshal(d, &marker); err != nil {
+			return fmt.Errorf("Error decoding module marker in '%s' (%s)", rel, err)
+		}
+		orphans = append(orphans, Orphan{Path: rel, URL: marker.URL})
+
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, wrapError(ErrFilesystem, err, "Error searching for orphaned modules")
+	}
+
+	return orphans, nil
+}
+
+// Modified checks whether the worktree has uncommitted or untracked changes, ignoring
+// LFS pointer files replaced by their object contents
+func (repo *Repo) Modified() (bool, error) {
+	worktree, err := repo.repository.Worktree()
+	if err != nil {
+		return false, err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return false, err
+	}
+	if status.IsClean() {
+		return false, nil
+	}
+
+	lfs := make(map[string]string)
+	if pointers, err := repo.lfsPointers(); err == nil {
+		for _, p := range pointers {
+			lfs[p.Path] = p.OID
+		}
+	}
+
+	for path, s := range status {
+		if s.Staging == git.Unmodified && s.Worktree == git.Unmodified {
+			continue
+		}
+		// Missing LFS objects are restored from the LFS cache on checkout
+		if _, ok := lfs[path]; ok && s.Staging == git.Unmodified && s.Worktree == git.Deleted {
+			continue
+		}
+		if oid, ok := lfs[path]; ok && s.Staging == git.Unmodified && s.Worktree == git.Modified {
+			if match, err := fileMatchesDigest(filepath.Join(repo.path, filepath.FromSlash(path)), oid); err == nil && match {
+				continue
+			}
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// GC rewrites the repository object packs to contain only objects reachable from its
+// references and index, removing loose objects and any cached LFS objects not referenced
+// by HEAD. As with `git gc --prune`, unreachable objects in packs or loose objects modified
+// after the cutoff are retained. Returns the number of git objects and LFS objects pruned.
+func (repo *Repo) GC(cutoff time.Time) (int, int, error) {
+	reachable, err := repo.reachableObjects()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	before, unreachable, err := repo.countObjects(reachable)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pruned := 0
+	if unreachable > 0 {
+		if err := repo.repack(reachable, cutoff); err != nil {
+			return 0, 0, err
+		}
+		// Reopen the repository to drop cached indexes for removed packs
+		if err := repo.Open(); err != nil {
+			return 0, 0, err
+		}
+		after, _, err := repo.countObjects(reachable)
+		if err != nil {
+			return 0, 0, err
+		}
+		pruned = before - after
+	}
+
+	lfsPruned, err := repo.pruneLFS()
+	if err != nil {
+		return pruned, 0, err
+	}
+
+	return pruned, lfsPruned, nil
+}
+
+// countObjects counts the objects in the repository, and those not in the reachable set
+func (repo *Repo) countObjects(reachable map[plumbing.Hash]bool) (int, int, error) {
+	total, unreachable := 0, 0
+	iter, err := repo.repository.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		total++
+		if !reachable[obj.Hash()] {
+			unreachable++
+		}
+		return nil
+	})
+	return total, unreachable, err
+}
+
+// pseudoRefs are the git directory files referencing commits outside of refs
+var pseudoRefs = []string{"ORIG_HEAD", "FETCH_HEAD", "MERGE_HEAD", "CHERRY_PICK_HEAD", "REVERT_HEAD"}
+
+// reachableObjects walks the objects reachable from each repository reference, pseudo
+// reference (ie. ORIG_HEAD) and the index, including staged blobs and cached trees. Unlike
+// the go-git object walker this stops at the boundary of shallow clones and skips submodules.
+func (repo *Repo) reachableObjects() (map[plumbing.Hash]bool, error) {
+	type entry struct {
+		hash     plumbing.Hash
+		optional bool // Parents of shallow commits and index objects may not be available
+	}
+
+	s := repo.repository.Storer
+	reachable := make(map[plumbing.Hash]bool)
+	stack := make([]entry, 0)
+
+	refs, err := s.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			stack = append(stack, entry{hash: ref.Hash()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range pseudoRefs {
+		d, err := ioutil.ReadFile(filepath.Join(gitDir(repo.path), name))
+		if err != nil {
+			continue
+		}
+		for _, l := range strings.Split(string(d), "\n") {
+			f := strings.Fields(l)
+			if len(f) == 0 {
+				continue
+			}
+			if h := plumbing.NewHash(f[0]); h.String() == strings.ToLower(f[0]) {
+				stack = append(stack, entry{hash: h, optional: true})
+			}
+		}
+	}
+
+	idx, err := s.Index()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range idx.Entries {
+		if e.Mode != filemode.Submodule && !e.Hash.IsZero() {
+			stack = append(stack, entry{hash: e.Hash, optional: true})
+		}
+	}
+	if idx.Cache != nil {
+		for _, e := range idx.Cache.Entries {
+			// Invalidated cache entries have negative entry counts
+			if e.Entries >= 0 && !e.Hash.IsZero() {
+				stack = append(stack, entry{hash: e.Hash, optional: true})
+			}
+		}
+	}
+
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if reachable[e.hash] {
+			continue
+		}
+
+		obj, err := s.EncodedObject(plumbing.AnyObject, e.hash)
+		if err == plumbing.ErrObjectNotFound && e.optional {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Error reading object '%s' (%s)", e.hash, err)
+		}
+		reachable[e.hash] = true
+
+		if obj.Type() == plumbing.BlobObject {
+			continue
+		}
+		decoded, err := object.DecodeObject(s, obj)
+		if err != nil {
+			return nil, err
+		}
+
+		switch o := decoded.(type) {
+		case *object.Commit:
+			stack = append(stack, entry{hash: o.TreeHash})
+			for _, p := range o.ParentHashes {
+				stack = append(stack, entry{hash: p, optional: true})
+			}
+		case *object.Tree:
+			for _, te := range o.Entries {
+				if te.Mode != filemode.Submodule {
+					stack = append(stack, entry{hash: te.Hash})
+				}
+			}
+		case *object.Tag:
+			stack = append(stack, entry{hash: o.Target})
+		}
+	}
+
+	return reachable, nil
+}
+
+// repack writes the provided objects to a new pack, then removes the previous packs and
+// loose objects, retaining packs and unreachable loose objects modified after the cutoff
+func (repo *Repo) repack(objects map[plumbing.Hash]bool, cutoff time.Time) (err error) {
+	s := repo.repository.Storer
+	pos, ok := s.(storer.PackedObjectStorer)
+	if !ok {
+		return git.ErrPackedObjectsNotSupported
+	}
+	pfw, ok := s.(storer.PackfileWriter)
+	if !ok {
+		return fmt.Errorf("Repository storage does not support writing packs")
+	}
+
+	packs, err := pos.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	hashes := make([]plumbing.Hash, 0, len(objects))
+	for h := range objects {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i].String() < hashes[j].String() })
+
+	cfg, err := s.Config()
+	if err != nil {
+		return err
+	}
+	w, err := pfw.PackfileWriter()
+	if err != nil {
+		return err
+	}
+	packHash, err := packfile.NewEncoder(w, s, false).Encode(hashes, cfg.Pack.Window)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, h := range packs {
+		if h == packHash {
+			continue
+		}
+		if err := pos.DeleteOldObjectPackAndIndex(h, cutoff); err != nil {
+			return err
+		}
+	}
+
+	// Reachable loose objects are now packed
+	if los, ok := s.(storer.LooseObjectStorer); ok {
+		loose := make([]plumbing.Hash, 0)
+		if err := los.ForEachObjectHash(func(h plumbing.Hash) error {
+			if !objects[h] && !cutoff.IsZero() {
+				if t, err := los.LooseObjectTime(h); err != nil || t.After(cutoff) {
+					return nil
+				}
+			}
+			loose = append(loose, h)
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, h := range loose {
+			if err := los.DeleteLooseObject(h); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// pruneLFS removes cached LFS objects not referenced by the HEAD commit
+func (repo *Repo) pruneLFS() (int, error) {
+	root := filepath.Join(gitDir(repo.path), "lfs", "objects")
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	pointers, err := repo.lfsPointers()
+	if err != nil {
+		return 0, err
+	}
+	referenced := make(map[string]bool)
+	for _, p := range pointers {
+		referenced[p.OID] = true
+	}
+
+	pruned := 0
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || referenced[info.Name()] {
+			return err
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		pruned++
+		return nil
+	})
+
+	return pruned, err
+}
+
+// fileMatchesDigest checks whether the sha256 digest of a file matches the provided hex digest
+func fileMatchesDigest(path, digest string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) == digest, nil
+}
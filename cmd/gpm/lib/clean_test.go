@@ -0,0 +1,208 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestClean(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0")
+	bare, hashes := module.URL(), module.Hashes
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+	o := CommonOptions{BasePath: projectDir, Verbose: true}
+	gpm := GPM{options: &o}
+	ctx := context.Background()
+
+	pc := ProjectConfig{Name: "project"}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+	assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+	for _, p := range []string{"lib/kept", "lib/old", "lib/edited"} {
+		_, err := gpm.Add(ctx, &AddOptions{Path: p, URL: bare, Version: "v0.1.0"})
+		assert.Nil(t, err)
+	}
+
+	// Remove dependencies from the project config by hand, and create an unmanaged repository
+	pc, err = gpm.loadProjectConfig()
+	assert.Nil(t, err)
+	pc.Dependencies.Delete("lib/old")
+	pc.Dependencies.Delete("lib/edited")
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+
+	gpmtest.Git(t, projectDir, "init", "-q", "unmanaged")
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(projectDir, "lib/edited/local"), []byte("changes"), 0644))
+
+	t.Run("Marks managed modules", func(t *testing.T) {
+		marker := ModuleMarker{}
+		assert.Nil(t, o.loadYaml(filepath.Join("lib/kept/.git", ModuleMarkerName), &marker))
+		assert.EqualValues(t, ModuleMarker{Path: "lib/kept", URL: bare}, marker)
+	})
+
+	t.Run("Lists orphaned modules", func(t *testing.T) {
+		orphans, err := gpm.Clean(ctx, &CleanOptions{DryRun: true})
+		assert.Nil(t, err)
+		assert.EqualValues(t, []Orphan{
+			{Path: "lib/edited", URL: bare, Modified: true},
+			{Path: "lib/old", URL: bare},
+		}, orphans)
+
+		_, err = os.Stat(filepath.Join(projectDir, "lib/old"))
+		assert.Nil(t, err)
+	})
+
+	t.Run("Refuses to remove modified modules", func(t *testing.T) {
+		orphans, err := gpm.Clean(ctx, &CleanOptions{})
+		assert.EqualValues(t, ErrDirtyModule, Code(err))
+		assert.EqualValues(t, 2, len(orphans))
+
+		for _, p := range []string{"lib/old", "lib/edited"} {
+			_, err = os.Stat(filepath.Join(projectDir, p))
+			assert.Nil(t, err, p)
+		}
+	})
+
+	t.Run("Removes orphaned modules", func(t *testing.T) {
+		assert.Nil(t, os.Remove(filepath.Join(projectDir, "lib/edited/local")))
+
+		orphans, err := gpm.Clean(ctx, &CleanOptions{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(orphans))
+		for _, o := range orphans {
+			assert.True(t, o.Removed, o.Path)
+			_, err = os.Stat(filepath.Join(projectDir, o.Path))
+			assert.True(t, os.IsNotExist(err), o.Path)
+		}
+
+		for _, p := range []string{"lib/kept", "unmanaged"} {
+			_, err = os.Stat(filepath.Join(projectDir, p))
+			assert.Nil(t, err, p)
+		}
+
+		d, err := ioutil.ReadFile(filepath.Join(projectDir, GitIgnoreName))
+		assert.Nil(t, err)
+		assert.Contains(t, string(d), "lib/kept")
+		assert.NotContains(t, string(d), "lib/old")
+		assert.NotContains(t, string(d), "lib/edited")
+	})
+
+	t.Run("Prunes unreachable objects", func(t *testing.T) {
+		modulePath := filepath.Join(projectDir, "lib/kept")
+
+		// Drop the references to the latest commit
+		for _, ref := range []string{"refs/tags/v0.2.0", "refs/heads/master", "refs/remotes/origin/master"} {
+			gpmtest.Git(t, modulePath, "update-ref", "-d", ref)
+		}
+
+		collected, err := gpm.GC(ctx, &GCOptions{})
+		assert.Nil(t, err)
+		if assert.EqualValues(t, 1, len(collected)) {
+			// Commit, tree and version file blob
+			assert.EqualValues(t, Collected{Path: "lib/kept", Objects: 3}, collected[0])
+		}
+
+		repo := NewRepo(modulePath, bare)
+		assert.Nil(t, repo.Open())
+		assert.True(t, repo.HasCommit(hashes["v0.1.0"]))
+		assert.False(t, repo.HasCommit(hashes["v0.2.0"]))
+		gpmtest.Git(t, modulePath, "fsck", "--full", "--no-dangling")
+
+		// Collecting again has nothing to prune
+		collected, err = gpm.GC(ctx, &GCOptions{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, []Collected{{Path: "lib/kept"}}, collected)
+	})
+
+	// writeObject writes an unreachable blob to the module object store
+	writeObject := func(t *testing.T, modulePath, contents string) plumbing.Hash {
+		p := filepath.Join(testDir, "object")
+		assert.Nil(t, ioutil.WriteFile(p, []byte(contents), 0644))
+		return plumbing.NewHash(gpmtest.Git(t, modulePath, "hash-object", "-w", p))
+	}
+
+	t.Run("Retains recent unreachable objects", func(t *testing.T) {
+		modulePath := filepath.Join(projectDir, "lib/kept")
+		h := writeObject(t, modulePath, "recent")
+
+		collected, err := gpm.GC(ctx, &GCOptions{Prune: time.Hour})
+		assert.Nil(t, err)
+		assert.EqualValues(t, []Collected{{Path: "lib/kept"}}, collected)
+		gpmtest.Git(t, modulePath, "cat-file", "-e", h.String())
+
+		collected, err = gpm.GC(ctx, &GCOptions{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, []Collected{{Path: "lib/kept", Objects: 1}}, collected)
+	})
+
+	t.Run("Skips modified modules and retains staged objects", func(t *testing.T) {
+		modulePath := filepath.Join(projectDir, "lib/kept")
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(modulePath, "staged"), []byte("staged"), 0644))
+		gpmtest.Git(t, modulePath, "add", "staged")
+		staged := plumbing.NewHash(gpmtest.Git(t, modulePath, "rev-parse", ":staged"))
+		unreachable := writeObject(t, modulePath, "unreachable")
+
+		collected, err := gpm.GC(ctx, &GCOptions{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, []Collected{{Path: "lib/kept", Skipped: true}}, collected)
+
+		// Objects referenced by the index are reachable
+		repo := NewRepo(modulePath, bare)
+		assert.Nil(t, repo.Open())
+		pruned, _, err := repo.GC(time.Time{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1, pruned)
+
+		assert.Nil(t, repo.repository.Storer.HasEncodedObject(staged))
+		assert.NotNil(t, repo.repository.Storer.HasEncodedObject(unreachable))
+		gpmtest.Git(t, modulePath, "fsck", "--full", "--no-dangling")
+	})
+}
+
+func TestPruneLFS(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	// Modules with a gitdir file keep LFS objects in the separate git directory
+	worktree, dir := filepath.Join(testDir, "module"), filepath.Join(testDir, "module.git")
+	gpmtest.Git(t, testDir, "init", "-q", "--separate-git-dir", dir, worktree)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(worktree, "file"), []byte("file"), 0644))
+	gpmtest.Git(t, worktree, "add", "file")
+	gpmtest.Git(t, worktree, "commit", "-q", "-m", "Initial commit")
+
+	oid := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	repo := NewRepo(worktree, "")
+	assert.Nil(t, repo.Open())
+	assert.EqualValues(t, filepath.Join(dir, "lfs", "objects", "01", "23", oid), repo.lfsObjectPath(oid))
+
+	assert.Nil(t, os.MkdirAll(filepath.Dir(repo.lfsObjectPath(oid)), 0755))
+	assert.Nil(t, ioutil.WriteFile(repo.lfsObjectPath(oid), []byte("unreferenced"), 0644))
+
+	pruned, err := repo.pruneLFS()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, pruned)
+	assert.NoFileExists(t, repo.lfsObjectPath(oid))
+}
@@ -4,9 +4,50 @@ import ()
 
 // Dependency is a git based project dependency
 type Dependency struct {
-	Path    string
-	URL     string // URL is the git repository URL
-	Version string `yaml:",omitempty"` // Version is a semver version or range for matching to git tags
+	Path    string `toml:"path" json:"path"`
+	URL     string `toml:"url" json:"url"`                                               // URL is the git repository URL
+	Version string `yaml:",omitempty" toml:"version,omitempty" json:"version,omitempty"` // Version is a semver version or range for matching to git tags
+
+	Prefix     string `yaml:",omitempty" toml:"prefix,omitempty" json:"prefix,omitempty"`         // Prefix is stripped from git tags before version matching (ie. `mylib-` for `mylib-v1.2.3`)
+	Prerelease bool   `yaml:",omitempty" toml:"prerelease,omitempty" json:"prerelease,omitempty"` // Prerelease enables matching prerelease tags
+
+	Depth       int  `yaml:",omitempty" toml:"depth,omitzero" json:"depth,omitempty"`                            // Depth is the number of commits fetched from each ref when cloning
+	FullHistory bool `yaml:"full-history,omitempty" toml:"full-history,omitempty" json:"full-history,omitempty"` // FullHistory fetches the complete module history when cloning
+
+	Submodules bool   `yaml:",omitempty" toml:"submodules,omitempty" json:"submodules,omitempty"`  // Submodules checks out module submodules at their recorded commits
+	LFS        bool   `yaml:"lfs,omitempty" toml:"lfs,omitempty" json:"lfs,omitempty"`             // LFS fetches git LFS objects in place of pointer files
+	LFSURL     string `yaml:"lfs-url,omitempty" toml:"lfs-url,omitempty" json:"lfs-url,omitempty"` // LFSURL overrides the LFS endpoint derived from the module URL
+
+	Groups []string `yaml:",omitempty" toml:"groups,omitempty" json:"groups,omitempty"` // Groups are the named groups (ie. dev, test) including the dependency
+}
+
+// Module is a dependency resolved to a specific commit
+type Module struct {
+	Dependency
+	Tag  string `json:"tag,omitempty"` // Tag is the semver tag matching the commit, if available
+	Hash string `json:"hash"`          // Hash is the git commit hash
+}
+
+// CloneDepth fetches the clone depth for a dependency, where 0 fetches the full history
+func (d *Dependency) CloneDepth() int {
+	if d.FullHistory {
+		return 0
+	}
+	if d.Depth > 0 {
+		return d.Depth
+	}
+	return DefaultCloneDepth
+}
+
+// LFSEndpoint fetches the LFS endpoint for a dependency, returning an empty string if LFS is disabled
+func (d *Dependency) LFSEndpoint() string {
+	if !d.LFS {
+		return ""
+	}
+	if d.LFSURL != "" {
+		return d.LFSURL
+	}
+	return lfsEndpoint(d.URL)
 }
 
 // Dependencies are a map of project dependencies
@@ -27,6 +68,16 @@ func (d *Dependencies) Find(path string) (*Dependency, bool) {
 	return nil, false
 }
 
+// Nested finds a dependency with a path nested inside, or containing, the provided path
+func (d *Dependencies) Nested(path string) (*Dependency, bool) {
+	for _, v := range *d {
+		if nestedPath(v.Path, path) || nestedPath(path, v.Path) {
+			return &v, true
+		}
+	}
+	return nil, false
+}
+
 // Delete removes a dependency by path
 func (d *Dependencies) Delete(path string) {
 	for k, v := range *d {
@@ -0,0 +1,136 @@
+package gpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorCode identifies a class of GPM error, and is used as the process exit code
+type ErrorCode int
+
+// Error codes returned by GPM commands (see README.md)
+const (
+	ErrNone           ErrorCode = 0  // No error
+	ErrUnknown        ErrorCode = 1  // Unclassified error
+	ErrInvalidOptions ErrorCode = 2  // Invalid command options
+	ErrProjectExists  ErrorCode = 3  // Project configuration already exists
+	ErrProjectConfig  ErrorCode = 4  // Project configuration missing or invalid
+	ErrLockfile       ErrorCode = 5  // Lockfile missing or invalid
+	ErrMissingLock    ErrorCode = 6  // No locked hash for a module
+	ErrNoDependency   ErrorCode = 7  // No dependency bound to the provided path
+	ErrInvalidPath    ErrorCode = 8  // Path outside the project directory or otherwise unsafe
+	ErrInvalidVersion ErrorCode = 9  // Invalid semver version or range
+	ErrNoMatchingTag  ErrorCode = 10 // No tags matching the version range
+	ErrRemote         ErrorCode = 11 // Clone or fetch from the module remote failed
+	ErrRepository     ErrorCode = 12 // Opening, reading or checking out a module repository failed
+	ErrProjectLocked  ErrorCode = 13 // Project locked by another gpm process
+	ErrFilesystem     ErrorCode = 14 // Reading or writing project files failed
+	ErrLicensePolicy  ErrorCode = 15 // Module license not permitted by the project license policy
+	ErrDigestMismatch ErrorCode = 16 // Module submodule or LFS content does not match the locked digest
+	ErrCanceled       ErrorCode = 17 // Command canceled or timed out by the caller context
+	ErrDirtyModule    ErrorCode = 18 // Module has uncommitted or untracked changes
+	ErrUnknownPackage ErrorCode = 19 // Package name not found in the package indexes
+	ErrVulnerable     ErrorCode = 20 // Locked module version affected by a known advisory
+	ErrUnaudited      ErrorCode = 21 // Locked module version could not be checked against matching advisories
+)
+
+var errorNames = map[ErrorCode]string{
+	ErrNone:           "none",
+	ErrUnknown:        "unknown",
+	ErrInvalidOptions: "invalid-options",
+	ErrProjectExists:  "project-exists",
+	ErrProjectConfig:  "project-config",
+	ErrLockfile:       "lockfile",
+	ErrMissingLock:    "missing-lock",
+	ErrNoDependency:   "no-dependency",
+	ErrInvalidPath:    "invalid-path",
+	ErrInvalidVersion: "invalid-version",
+	ErrNoMatchingTag:  "no-matching-tag",
+	ErrRemote:         "remote",
+	ErrRepository:     "repository",
+	ErrProjectLocked:  "project-locked",
+	ErrFilesystem:     "filesystem",
+	ErrLicensePolicy:  "license-policy",
+	ErrDigestMismatch: "digest-mismatch",
+	ErrCanceled:       "canceled",
+	ErrDirtyModule:    "dirty-module",
+	ErrUnknownPackage: "unknown-package",
+	ErrVulnerable:     "vulnerable",
+	ErrUnaudited:      "unaudited",
+}
+
+// String fetches the machine readable name for an error code
+func (c ErrorCode) String() string {
+	if n, ok := errorNames[c]; ok {
+		return n
+	}
+	return errorNames[ErrUnknown]
+}
+
+// Error is a typed GPM error
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Err     error // Underlying error, if available
+}
+
+// Error formats the error message, including any underlying error
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s (%s)", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+// Unwrap fetches the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Code fetches the ErrorCode for an error, returning ErrUnknown for untyped errors
+func Code(err error) ErrorCode {
+	if err == nil {
+		return ErrNone
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ErrUnknown
+}
+
+// errorf creates a new typed error with a formatted message
+func errorf(code ErrorCode, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// wrapError wraps an underlying error in a typed error, leaving existing typed errors untouched.
+// Context cancellation errors are always wrapped as ErrCanceled.
+func wrapError(code ErrorCode, err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		code = ErrCanceled
+	}
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// canceled checks whether a context has been canceled, returning an ErrCanceled error if so
+func canceled(ctx context.Context) error {
+	return wrapError(ErrCanceled, ctx.Err(), "Operation canceled")
+}
+
+// contextError replaces an operation error with an ErrCanceled error if the context has been
+// canceled, as git transports do not always preserve the underlying context error
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return canceled(ctx)
+	}
+	return err
+}
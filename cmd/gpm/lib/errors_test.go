@@ -0,0 +1,54 @@
+package gpm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors(t *testing.T) {
+
+	t.Run("Maps errors to codes", func(t *testing.T) {
+		assert.Equal(t, ErrNone, Code(nil))
+		assert.Equal(t, ErrUnknown, Code(fmt.Errorf("untyped")))
+		assert.Equal(t, ErrMissingLock, Code(errorf(ErrMissingLock, "Missing lock hash for module '%s'", "test")))
+
+		// Typed errors are found through wrapped errors
+		wrapped := fmt.Errorf("Error syncing: %w", errorf(ErrMissingLock, "Missing lock hash for module '%s'", "test"))
+		assert.Equal(t, ErrMissingLock, Code(wrapped))
+		assert.Equal(t, wrapped, wrapError(ErrRemote, wrapped, "Error fetching module '%s'", "test"))
+	})
+
+	t.Run("Wraps underlying errors", func(t *testing.T) {
+		cause := fmt.Errorf("connection refused")
+		err := wrapError(ErrRemote, cause, "Error fetching module '%s'", "test")
+
+		assert.Equal(t, ErrRemote, Code(err))
+		assert.Equal(t, "Error fetching module 'test' (connection refused)", err.Error())
+		assert.Equal(t, cause, err.(*Error).Unwrap())
+	})
+
+	t.Run("Preserves typed errors when wrapping", func(t *testing.T) {
+		err := wrapError(ErrRemote, errorf(ErrProjectLocked, "locked"), "Error fetching module '%s'", "test")
+		assert.Equal(t, ErrProjectLocked, Code(err))
+
+		assert.Nil(t, wrapError(ErrRemote, nil, "no error"))
+	})
+
+	t.Run("Maps context errors to canceled", func(t *testing.T) {
+		err := wrapError(ErrRemote, fmt.Errorf("clone failed: %w", context.Canceled), "Error cloning '%s'", "test")
+		assert.Equal(t, ErrCanceled, Code(err))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		assert.Nil(t, canceled(ctx))
+		cancel()
+		assert.Equal(t, ErrCanceled, Code(canceled(ctx)))
+	})
+
+	t.Run("Names error codes", func(t *testing.T) {
+		assert.Equal(t, "missing-lock", ErrMissingLock.String())
+		assert.Equal(t, "unknown", ErrorCode(255).String())
+	})
+}
@@ -0,0 +1,377 @@
+package gpm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Supported export formats
+const (
+	ExportSPDX       = "spdx"       // SPDX 2.3 JSON software bill of materials
+	ExportCycloneDX  = "cyclonedx"  // CycloneDX 1.4 JSON software bill of materials
+	ExportLicenses   = "licenses"   // Plain text license summary
+	ExportGitModules = "gitmodules" // Git submodule configuration
+)
+
+// Export exports the locked project dependencies in the specified format, writing to the
+// output file if provided (relative to the project directory) and returning the exported document
+func (gpm *GPM) Export(ctx context.Context, eo *ExportOptions) (doc string, err error) {
+	// Output files are written in a transaction so they are restored on failure
+	file := eo.File
+	if file != "" {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(gpm.options.BasePath, file)
+		}
+		var tx *transaction
+		if tx, err = gpm.begin(ctx); err != nil {
+			return "", err
+		}
+		defer gpm.end(&err)
+		if err = tx.track(file); err != nil {
+			return "", err
+		}
+	}
+
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return "", err
+	}
+	locks, err := gpm.loadLockfile()
+	if err != nil {
+		return "", err
+	}
+
+	modules, err := gpm.lockedModules(ctx, &pc, locks)
+	if err != nil {
+		return "", err
+	}
+	licenses, err := gpm.moduleLicenses(modules)
+	if err != nil {
+		return "", err
+	}
+
+	var d []byte
+	switch eo.Format {
+	case ExportSPDX, "":
+		d, err = exportSPDX(&pc, modules, licenses, time.Now().UTC())
+	case ExportCycloneDX:
+		d, err = exportCycloneDX(&pc, modules, licenses, time.Now().UTC())
+	case ExportLicenses:
+		d, err = exportLicenses(&pc, modules, licenses)
+	case ExportGitModules:
+		d, err = exportGitModules(modules)
+	default:
+		return "", errorf(ErrInvalidOptions, "Unsupported export format '%s'", eo.Format)
+	}
+	if err != nil {
+		return "", wrapError(ErrUnknown, err, "Error exporting format '%s'", eo.Format)
+	}
+
+	if file != "" {
+		if err := writeFile(file, d); err != nil {
+			return "", wrapError(ErrFilesystem, err, "Error writing to file '%s'", eo.File)
+		}
+	}
+
+	return string(d), nil
+}
+
+// lockedModules resolves project dependencies to their locked commits, including
+// matching tags for modules available on disk
+func (gpm *GPM) lockedModules(ctx context.Context, pc *ProjectConfig, locks Locks) ([]Module, error) {
+	modules := make([]Module, 0, len(pc.Dependencies))
+
+	for _, d := range pc.Dependencies {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+
+		lock, ok := locks[d.Path]
+		if !ok {
+			return nil, errorf(ErrMissingLock, "Missing lock hash for module '%s'", d.Path)
+		}
+
+		modulePath, err := gpm.modulePath(d.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		m := Module{Dependency: d, Hash: lock.Hash}
+		repo := gpm.newRepo(&d, modulePath)
+		if repo.Exists() && repo.Open() == nil {
+			m = gpm.newModule(d, repo, lock.Hash)
+		}
+
+		modules = append(modules, m)
+	}
+
+	return modules, nil
+}
+
+// moduleVersion fetches the display version for a module, preferring the tag over the hash
+func moduleVersion(m Module) string {
+	if m.Tag != "" {
+		return m.Tag
+	}
+	return m.Hash
+}
+
+// moduleLocation builds an SPDX style VCS location for a module, prefixing http(s), ssh and
+// git URLs with `git+` and mapping scp style URLs (ie. git@host:org/lib) to ssh URLs
+func moduleLocation(m Module) string {
+	location := m.URL
+	if prefix := scpURL.FindString(location); prefix != "" && !strings.Contains(location, "://") {
+		location = "ssh://" + strings.TrimSuffix(prefix, ":") + "/" + strings.TrimPrefix(location[len(prefix):], "/")
+	}
+	if u, err := url.Parse(location); err == nil {
+		switch u.Scheme {
+		case "http", "https", "ssh", "git":
+			location = "git+" + location
+		}
+	}
+	return fmt.Sprintf("%s@%s", location, m.Hash)
+}
+
+// exportNamespace builds a deterministic document namespace for the locked module set
+func exportNamespace(pc *ProjectConfig, modules []Module) string {
+	h := sha1.New()
+	for _, m := range modules {
+		fmt.Fprintf(h, "%s:%s:%s\n", m.Path, m.URL, m.Hash)
+	}
+
+	base := strings.TrimSuffix(pc.Repository, ".git")
+	if !strings.Contains(base, "://") {
+		base = "https://spdx.org/spdxdocs"
+	}
+
+	return fmt.Sprintf("%s/spdx/%s-%x", base, spdxIDChars.ReplaceAllString(pc.Name, "-"), h.Sum(nil))
+}
+
+var spdxIDChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string `json:"name"`
+	SPDXID           string `json:"SPDXID"`
+	VersionInfo      string `json:"versionInfo,omitempty"`
+	DownloadLocation string `json:"downloadLocation"`
+	Homepage         string `json:"homepage,omitempty"`
+	LicenseConcluded string `json:"licenseConcluded"`
+	LicenseDeclared  string `json:"licenseDeclared"`
+	CopyrightText    string `json:"copyrightText"`
+	FilesAnalyzed    bool   `json:"filesAnalyzed"`
+}
+
+type spdxRelationship struct {
+	Element        string `json:"spdxElementId"`
+	Type           string `json:"relationshipType"`
+	RelatedElement string `json:"relatedSpdxElement"`
+}
+
+// exportSPDX builds an SPDX 2.3 JSON document for the project and locked modules
+func exportSPDX(pc *ProjectConfig, modules []Module, licenses map[string]string, created time.Time) ([]byte, error) {
+	projectLicense := pc.License
+	if projectLicense == "" {
+		projectLicense = LicenseUnknown
+	}
+	projectLocation := LicenseUnknown
+	if pc.Repository != "" {
+		projectLocation = pc.Repository
+	}
+
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              pc.Name,
+		DocumentNamespace: exportNamespace(pc, modules),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.Format(time.RFC3339),
+			Creators: []string{"Tool: gpm"},
+		},
+		Packages: []spdxPackage{{
+			Name:             pc.Name,
+			SPDXID:           "SPDXRef-Project",
+			DownloadLocation: projectLocation,
+			Homepage:         pc.Homepage,
+			LicenseConcluded: projectLicense,
+			LicenseDeclared:  projectLicense,
+			CopyrightText:    LicenseUnknown,
+		}},
+		Relationships: []spdxRelationship{{
+			Element:        "SPDXRef-DOCUMENT",
+			Type:           "DESCRIBES",
+			RelatedElement: "SPDXRef-Project",
+		}},
+	}
+
+	for i, m := range modules {
+		id := fmt.Sprintf("SPDXRef-Module-%d-%s", i, spdxIDChars.ReplaceAllString(m.Path, "-"))
+
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             m.Path,
+			SPDXID:           id,
+			VersionInfo:      moduleVersion(m),
+			DownloadLocation: moduleLocation(m),
+			LicenseConcluded: licenses[m.Path],
+			LicenseDeclared:  licenses[m.Path],
+			CopyrightText:    LicenseUnknown,
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			Element:        "SPDXRef-Project",
+			Type:           "DEPENDS_ON",
+			RelatedElement: id,
+		})
+	}
+
+	return json.MarshalIndent(&doc, "", "  ")
+}
+
+type cycloneDXDocument struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber,omitempty"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     []cycloneDXTool    `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTool struct {
+	Name string `json:"name"`
+}
+
+type cycloneDXComponent struct {
+	Type               string              `json:"type"`
+	BOMRef             string              `json:"bom-ref,omitempty"`
+	Name               string              `json:"name"`
+	Version            string              `json:"version,omitempty"`
+	Licenses           []cycloneDXLicense  `json:"licenses,omitempty"`
+	ExternalReferences []cycloneDXExternal `json:"externalReferences,omitempty"`
+}
+
+type cycloneDXLicense struct {
+	License    *cycloneDXLicenseID `json:"license,omitempty"`
+	Expression string              `json:"expression,omitempty"`
+}
+
+type cycloneDXLicenseID struct {
+	ID string `json:"id"`
+}
+
+type cycloneDXExternal struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// cycloneDXLicenses builds the CycloneDX license list for an SPDX license expression
+func cycloneDXLicenses(license string) []cycloneDXLicense {
+	switch {
+	case license == "" || license == LicenseUnknown:
+		return nil
+	case strings.ContainsAny(license, " ()"):
+		return []cycloneDXLicense{{Expression: license}}
+	default:
+		return []cycloneDXLicense{{License: &cycloneDXLicenseID{ID: license}}}
+	}
+}
+
+// exportCycloneDX builds a CycloneDX 1.4 JSON document for the project and locked modules
+func exportCycloneDX(pc *ProjectConfig, modules []Module, licenses map[string]string, created time.Time) ([]byte, error) {
+	project := cycloneDXComponent{
+		Type:     "application",
+		BOMRef:   "project",
+		Name:     pc.Name,
+		Licenses: cycloneDXLicenses(pc.License),
+	}
+	if pc.Repository != "" {
+		project.ExternalReferences = []cycloneDXExternal{{Type: "vcs", URL: pc.Repository}}
+	}
+
+	doc := cycloneDXDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: created.Format(time.RFC3339),
+			Tools:     []cycloneDXTool{{Name: "gpm"}},
+			Component: project,
+		},
+		Components: make([]cycloneDXComponent, 0, len(modules)),
+	}
+
+	for _, m := range modules {
+		doc.Components = append(doc.Components, cycloneDXComponent{
+			Type:               "library",
+			BOMRef:             m.Path,
+			Name:               m.Path,
+			Version:            moduleVersion(m),
+			Licenses:           cycloneDXLicenses(licenses[m.Path]),
+			ExternalReferences: []cycloneDXExternal{{Type: "vcs", URL: m.URL}},
+		})
+	}
+
+	return json.MarshalIndent(&doc, "", "  ")
+}
+
+// exportLicenses builds a plain text license summary for the project and locked modules
+func exportLicenses(pc *ProjectConfig, modules []Module, licenses map[string]string) ([]byte, error) {
+	buff := bytes.NewBuffer(nil)
+
+	projectLicense := pc.License
+	if projectLicense == "" {
+		projectLicense = LicenseUnknown
+	}
+	fmt.Fprintf(buff, "Project: %s (%s)\n\n", pc.Name, projectLicense)
+
+	w := tabwriter.NewWriter(buff, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "PATH\tVERSION\tLICENSE\tURL\n")
+	for _, m := range modules {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Path, moduleVersion(m), licenses[m.Path], m.URL)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// exportGitModules builds a git submodule configuration for the locked modules
+func exportGitModules(modules []Module) ([]byte, error) {
+	buff := bytes.NewBuffer(nil)
+
+	for _, m := range modules {
+		fmt.Fprintf(buff, "[submodule \"%s\"]\n\tpath = %s\n\turl = %s\n", m.Path, m.Path, m.URL)
+	}
+
+	return buff.Bytes(), nil
+}
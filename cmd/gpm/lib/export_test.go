@@ -0,0 +1,137 @@
+package gpm
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	o := CommonOptions{BasePath: testDir}
+	gpm := GPM{options: &o}
+
+	// Write a project with one module present on disk
+	pc := ProjectConfig{
+		Name:       "Test Project",
+		License:    "MIT",
+		Repository: "https://github.com/ryankurte/test-repo",
+		Dependencies: Dependencies{
+			{Path: "lib/a", URL: "https://github.com/ryankurte/test-a", Version: "^0.1.0"},
+			{Path: "lib/b", URL: "https://github.com/ryankurte/test-b"},
+		},
+	}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+	locks := Locks{"lib/a": {Hash: "5a83540f87665410bb6440b97f6c2f71a27471bd"}, "lib/b": {Hash: "2075d8e0a26e6104b212428186bec59edad15e42"}}
+	assert.Nil(t, gpm.writeLockfile(&locks))
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(testDir, "lib/a"), 0755))
+	err = ioutil.WriteFile(filepath.Join(testDir, "lib/a/LICENSE"), []byte("Apache License\nVersion 2.0"), 0644)
+	assert.Nil(t, err)
+
+	t.Run("Exports SPDX documents", func(t *testing.T) {
+		d, err := gpm.Export(context.Background(), &ExportOptions{Format: ExportSPDX})
+		assert.Nil(t, err)
+
+		doc := spdxDocument{}
+		assert.Nil(t, json.Unmarshal([]byte(d), &doc))
+		assert.Equal(t, "SPDX-2.3", doc.SPDXVersion)
+		if assert.Equal(t, 3, len(doc.Packages)) {
+			assert.Equal(t, "MIT", doc.Packages[0].LicenseDeclared)
+			assert.Equal(t, "Apache-2.0", doc.Packages[1].LicenseDeclared)
+			assert.Equal(t, "git+https://github.com/ryankurte/test-a@5a83540f87665410bb6440b97f6c2f71a27471bd", doc.Packages[1].DownloadLocation)
+			assert.Equal(t, LicenseUnknown, doc.Packages[2].LicenseDeclared)
+		}
+		assert.Equal(t, 3, len(doc.Relationships))
+	})
+
+	t.Run("Exports CycloneDX documents", func(t *testing.T) {
+		d, err := gpm.Export(context.Background(), &ExportOptions{Format: ExportCycloneDX})
+		assert.Nil(t, err)
+
+		doc := cycloneDXDocument{}
+		assert.Nil(t, json.Unmarshal([]byte(d), &doc))
+		assert.Equal(t, "CycloneDX", doc.BOMFormat)
+		if assert.Equal(t, 2, len(doc.Components)) {
+			assert.Equal(t, "Apache-2.0", doc.Components[0].Licenses[0].License.ID)
+			assert.Empty(t, doc.Components[1].Licenses)
+		}
+	})
+
+	t.Run("Exports license summaries", func(t *testing.T) {
+		d, err := gpm.Export(context.Background(), &ExportOptions{Format: ExportLicenses})
+		assert.Nil(t, err)
+		assert.Contains(t, d, "Project: Test Project (MIT)")
+		assert.Contains(t, d, "lib/a  5a83540f87665410bb6440b97f6c2f71a27471bd  Apache-2.0")
+	})
+
+	t.Run("Exports git submodule files", func(t *testing.T) {
+		file := filepath.Join(testDir, GitModulesName)
+		d, err := gpm.Export(context.Background(), &ExportOptions{Format: ExportGitModules, File: file})
+		assert.Nil(t, err)
+
+		written, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		assert.Equal(t, d, string(written))
+		assert.Equal(t, "[submodule \"lib/a\"]\n\tpath = lib/a\n\turl = https://github.com/ryankurte/test-a\n"+
+			"[submodule \"lib/b\"]\n\tpath = lib/b\n\turl = https://github.com/ryankurte/test-b\n", d)
+	})
+
+	t.Run("Writes output files relative to the project", func(t *testing.T) {
+		d, err := gpm.Export(context.Background(), &ExportOptions{Format: ExportLicenses, File: "licenses.txt"})
+		assert.Nil(t, err)
+
+		written, err := ioutil.ReadFile(filepath.Join(testDir, "licenses.txt"))
+		assert.Nil(t, err)
+		assert.Equal(t, d, string(written))
+	})
+
+	t.Run("Restores output files on failure", func(t *testing.T) {
+		file := filepath.Join(testDir, "sbom.json")
+		assert.Nil(t, ioutil.WriteFile(file, []byte("original"), 0644))
+
+		_, err := gpm.Export(context.Background(), &ExportOptions{Format: "invalid", File: file})
+		assert.Equal(t, ErrInvalidOptions, Code(err))
+
+		written, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		assert.Equal(t, "original", string(written))
+	})
+
+	t.Run("Fails with missing locks", func(t *testing.T) {
+		delete(locks, "lib/b")
+		assert.Nil(t, gpm.writeLockfile(&locks))
+
+		_, err := gpm.Export(context.Background(), &ExportOptions{})
+		assert.Equal(t, ErrMissingLock, Code(err))
+	})
+}
+
+func TestModuleLocation(t *testing.T) {
+	tests := []struct {
+		url, location string
+	}{
+		{"https://github.com/org/lib", "git+https://github.com/org/lib@abcd"},
+		{"github.com/org/lib", "github.com/org/lib@abcd"},
+		{"git@github.com:org/lib.git", "git+ssh://git@github.com/org/lib.git@abcd"},
+		{"ssh://git@github.com/org/lib", "git+ssh://git@github.com/org/lib@abcd"},
+		{"git://github.com/org/lib", "git+git://github.com/org/lib@abcd"},
+		{"git+https://github.com/org/lib", "git+https://github.com/org/lib@abcd"},
+		{"file:///tmp/module/bare.git", "file:///tmp/module/bare.git@abcd"},
+	}
+
+	for _, test := range tests {
+		assert.EqualValues(t, test.location, moduleLocation(Module{Dependency: Dependency{URL: test.url}, Hash: "abcd"}), test.url)
+	}
+}
@@ -0,0 +1,77 @@
+package gpm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+)
+
+// Formatted is the result of formatting a project config file
+type Formatted struct {
+	File    string `json:"file"`    // File is the project config file name
+	Changed bool   `json:"changed"` // Changed is set where the file was not in the canonical format
+}
+
+// String formats a formatting result as a human readable summary line
+func (f Formatted) String() string {
+	if f.Changed {
+		return fmt.Sprintf("%s: formatted", f.File)
+	}
+	return fmt.Sprintf("%s: unchanged", f.File)
+}
+
+// Fmt validates the project config file and rewrites it in the canonical format for
+// its file type, preserving comments in yaml files. With FmtOptions.Check the file is
+// left unchanged and ErrProjectConfig is returned if it is not in the canonical format.
+func (gpm *GPM) Fmt(ctx context.Context, fo *FmtOptions) (f *Formatted, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
+	name, err := gpm.options.projectFile()
+	if err != nil {
+		return nil, err
+	}
+	fullpath, err := gpm.options.fullPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := ioutil.ReadFile(fullpath)
+	if err != nil {
+		return nil, wrapError(ErrProjectConfig, err, "Error reading project config '%s'", name)
+	}
+	pc, err := decodeProject(name, d)
+	if err != nil {
+		return nil, wrapError(ErrProjectConfig, err, "Error loading project config '%s'", name)
+	}
+
+	var formatted []byte
+	if projectFormats[name] == FormatYAML {
+		formatted, err = formatYaml(d, &pc)
+	} else {
+		formatted, err = encodeProject(name, &pc)
+	}
+	if err != nil {
+		return nil, wrapError(ErrFilesystem, err, "Error formatting project config '%s'", name)
+	}
+
+	f = &Formatted{File: name, Changed: !bytes.Equal(d, formatted)}
+	if !f.Changed {
+		return f, nil
+	}
+	if fo.Check {
+		return f, errorf(ErrProjectConfig, "Project config '%s' is not formatted, run `gpm fmt`", name)
+	}
+
+	if err := writeFile(fullpath, formatted); err != nil {
+		return nil, wrapError(ErrFilesystem, err, "Error writing to file '%s'", name)
+	}
+	if gpm.options.Verbose {
+		gpm.logf("Fmt formatted project config '%s'", name)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,337 @@
+package gpm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectFormat is the encoding of a project config file
+type ProjectFormat string
+
+// Supported project config formats
+const (
+	FormatYAML ProjectFormat = "yaml"
+	FormatTOML ProjectFormat = "toml"
+	FormatJSON ProjectFormat = "json"
+)
+
+// projectFormats maps project config file names to formats
+var projectFormats = map[string]ProjectFormat{
+	ProjectConfigName:     FormatYAML,
+	ProjectConfigTOMLName: FormatTOML,
+	ProjectConfigJSONName: FormatJSON,
+}
+
+// projectConfigFile fetches the project config file name for a format, defaulting to yaml
+func projectConfigFile(format ProjectFormat) string {
+	for name, f := range projectFormats {
+		if f == format {
+			return name
+		}
+	}
+	return ProjectConfigName
+}
+
+// decodeProject parses and validates a project config file, returning diagnostics
+// locating any problems in the file
+func decodeProject(name string, data []byte) (ProjectConfig, error) {
+	pc := ProjectConfig{}
+
+	node, diags := parseProject(name, data)
+	if len(diags) == 0 {
+		diags = validateProject(name, node)
+	}
+	if len(diags) > 0 {
+		return pc, diags
+	}
+
+	if err := node.Decode(&pc); err != nil {
+		return pc, Diagnostics{{File: name, Message: err.Error()}}
+	}
+	return pc, nil
+}
+
+// parseProject parses a project config file into a yaml node tree, with source positions
+// where available, so files in all formats can be validated and decoded alike
+func parseProject(name string, data []byte) (*yaml.Node, Diagnostics) {
+	switch projectFormats[name] {
+	case FormatTOML:
+		return parseTOML(name, data)
+	case FormatJSON:
+		return parseJSON(name, data)
+	default:
+		return parseYAML(name, data)
+	}
+}
+
+var (
+	// yamlErrorLine matches the line number in yaml syntax errors
+	yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	// tomlErrorPrefix matches the location prefix of TOML syntax errors
+	tomlErrorPrefix = regexp.MustCompile(`^toml: line \d+(?: \(last key .*?\))?: `)
+)
+
+func parseYAML(name string, data []byte) (*yaml.Node, Diagnostics) {
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		d := Diagnostic{File: name, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+			d.Message = m[2]
+		}
+		return nil, Diagnostics{d}
+	}
+
+	// Empty files are empty projects
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	return doc.Content[0], nil
+}
+
+func parseJSON(name string, data []byte) (*yaml.Node, Diagnostics) {
+	var obj interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		d := Diagnostic{File: name, Message: err.Error()}
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			// Offsets follow the invalid character
+			d.Line, d.Column = position(data, int(syntax.Offset)-1)
+		}
+		return nil, Diagnostics{d}
+	}
+
+	// JSON documents are valid yaml, parsing as yaml provides source positions
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err == nil && doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0], nil
+	}
+
+	node := yaml.Node{}
+	if err := node.Encode(obj); err != nil {
+		return nil, Diagnostics{{File: name, Message: err.Error()}}
+	}
+	return &node, nil
+}
+
+func parseTOML(name string, data []byte) (*yaml.Node, Diagnostics) {
+	obj := make(map[string]interface{})
+	if _, err := toml.Decode(string(data), &obj); err != nil {
+		d := Diagnostic{File: name, Message: err.Error()}
+		var parse toml.ParseError
+		if errors.As(err, &parse) {
+			d.Line, d.Column = position(data, parse.Position.Start)
+			d.Message = tomlErrorPrefix.ReplaceAllString(err.Error(), "")
+		}
+		return nil, Diagnostics{d}
+	}
+
+	node := yaml.Node{}
+	if err := node.Encode(obj); err != nil {
+		return nil, Diagnostics{{File: name, Message: err.Error()}}
+	}
+	locateTOML(&node, tomlLines(data), "", 0)
+
+	return &node, nil
+}
+
+// position converts a byte offset to a line and column
+func position(data []byte, offset int) (int, int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	return line, offset - bytes.LastIndexByte(before, '\n')
+}
+
+var (
+	tomlArrayTable = regexp.MustCompile(`^\[\[\s*([^\[\]]+?)\s*\]\]`)
+	tomlTable      = regexp.MustCompile(`^\[\s*([^\[\]]+?)\s*\]`)
+	tomlKeyPart    = regexp.MustCompile(`"[^"]*"|'[^']*'|[^.\s]+`)
+	tomlKey        = regexp.MustCompile(`^((?:"[^"]*"|'[^']*'|[A-Za-z0-9_\-]+)(?:\s*\.\s*(?:"[^"]*"|'[^']*'|[A-Za-z0-9_\-]+))*)\s*=`)
+)
+
+// tomlLines locates the lines of tables and keys in a TOML document, returning a map
+// of diagnostic paths (ie. dependencies[0].url) to line numbers
+func tomlLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	counts := make(map[string]int)
+	prefix, multiline := "", ""
+
+	for i, l := range strings.Split(string(data), "\n") {
+		l = strings.TrimSpace(l)
+
+		// Skip the contents of multi-line strings
+		if multiline != "" {
+			if strings.Count(l, multiline)%2 == 1 {
+				multiline = ""
+			}
+			continue
+		}
+
+		var p string
+		if m := tomlArrayTable.FindStringSubmatch(l); m != nil {
+			name := tomlPath(m[1])
+			prefix = fmt.Sprintf("%s[%d]", name, counts[name])
+			counts[name]++
+			p = prefix
+		} else if m := tomlTable.FindStringSubmatch(l); m != nil {
+			prefix = tomlPath(m[1])
+			p = prefix
+		} else if m := tomlKey.FindStringSubmatch(l); m != nil {
+			p = joinPath(prefix, tomlPath(m[1]))
+			for _, delim := range []string{`"""`, `'''`} {
+				if strings.Count(l, delim)%2 == 1 {
+					multiline = delim
+				}
+			}
+		} else {
+			continue
+		}
+
+		if _, ok := lines[p]; !ok {
+			lines[p] = i + 1
+		}
+	}
+
+	return lines
+}
+
+// tomlPath converts a dotted TOML key to a diagnostic path
+func tomlPath(key string) string {
+	parts := make([]string, 0)
+	for _, p := range tomlKeyPart.FindAllString(key, -1) {
+		parts = append(parts, strings.Trim(p, `"'`))
+	}
+	return strings.Join(parts, ".")
+}
+
+// locateTOML assigns TOML source lines to a node tree, using the line of the closest
+// located parent where a value cannot be located (ie. inline arrays and tables), and
+// orders mapping entries by line so diagnostics follow the source order
+func locateTOML(n *yaml.Node, lines map[string]int, path string, parent int) {
+	line, ok := lines[path]
+	if !ok {
+		line = parent
+	}
+	n.Line, n.Column = line, 1
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		type pair struct{ k, v *yaml.Node }
+		pairs := make([]pair, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			p := joinPath(path, k.Value)
+			locateTOML(v, lines, p, line)
+			k.Line, k.Column = v.Line, 1
+			pairs = append(pairs, pair{k, v})
+		}
+		sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].k.Line < pairs[j].k.Line })
+		n.Content = n.Content[:0]
+		for _, p := range pairs {
+			n.Content = append(n.Content, p.k, p.v)
+		}
+	case yaml.SequenceNode:
+		for i, v := range n.Content {
+			locateTOML(v, lines, fmt.Sprintf("%s[%d]", path, i), line)
+		}
+	}
+}
+
+// encodeProject encodes a project config in the format of the named project config file
+func encodeProject(name string, pc *ProjectConfig) ([]byte, error) {
+	switch projectFormats[name] {
+	case FormatTOML:
+		buff := bytes.NewBuffer(nil)
+		enc := toml.NewEncoder(buff)
+		enc.Indent = ""
+		if err := enc.Encode(pc); err != nil {
+			return nil, err
+		}
+		return buff.Bytes(), nil
+	case FormatJSON:
+		d, err := json.MarshalIndent(pc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(d, '\n'), nil
+	default:
+		return encodeYaml(pc)
+	}
+}
+
+// projectFile finds the project config file in the base directory, defaulting to
+// ProjectConfigName where no project config file exists
+func (options *CommonOptions) projectFile() (string, error) {
+	found := make([]string, 0)
+	for _, name := range ProjectConfigNames {
+		fullpath, err := options.fullPath(name)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(fullpath); err == nil {
+			found = append(found, name)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return ProjectConfigName, nil
+	case 1:
+		return found[0], nil
+	}
+	return "", errorf(ErrProjectConfig, "Multiple project config files found (%s), remove all but one", strings.Join(found, ", "))
+}
+
+// loadProject loads and validates a project config file
+func (options *CommonOptions) loadProject(name string, pc *ProjectConfig) error {
+	fullpath, err := options.fullPath(name)
+	if err != nil {
+		return err
+	}
+
+	d, err := ioutil.ReadFile(fullpath)
+	if err != nil {
+		return fmt.Errorf("Error reading file '%s' (%s)", name, err)
+	}
+
+	*pc, err = decodeProject(name, d)
+	return err
+}
+
+// writeProject saves a project config file, preserving comments and ordering in yaml files
+func (options *CommonOptions) writeProject(name string, pc *ProjectConfig) error {
+	if projectFormats[name] == FormatYAML {
+		return options.updateYaml(name, pc)
+	}
+
+	fullpath, err := options.fullPath(name)
+	if err != nil {
+		return err
+	}
+
+	b, err := encodeProject(name, pc)
+	if err != nil {
+		return wrapError(ErrFilesystem, err, "Error marshaling object to file '%s'", name)
+	}
+
+	err = writeFile(fullpath, b)
+	if err != nil {
+		return wrapError(ErrFilesystem, err, "Error writing to file '%s'", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectFormats(t *testing.T) {
+	ctx := context.Background()
+
+	newProject := func(t *testing.T) (*GPM, string, func()) {
+		dir, err := ioutil.TempDir("", "gpm-test")
+		if err != nil {
+			t.FailNow()
+		}
+		return NewGPM(&CommonOptions{BasePath: dir}), dir, func() { os.RemoveAll(dir) }
+	}
+
+	t.Run("Initialises and updates project files in each format", func(t *testing.T) {
+		for _, format := range []ProjectFormat{FormatYAML, FormatTOML, FormatJSON} {
+			gpm, dir, cleanup := newProject(t)
+			defer cleanup()
+
+			_, err := gpm.Init(ctx, &InitOptions{Name: "project", Repository: "https://example.com/project", License: "MIT", Format: string(format)})
+			assert.Nil(t, err, format)
+
+			name := projectConfigFile(format)
+			assert.FileExists(t, filepath.Join(dir, name))
+
+			pc, err := gpm.loadProjectConfig()
+			assert.Nil(t, err, format)
+			pc.Dependencies = append(pc.Dependencies, Dependency{Path: "lib/a", URL: "https://example.com/a", Version: "^1.0.0"})
+			assert.Nil(t, gpm.writeProjectConfig(&pc), format)
+
+			for _, other := range ProjectConfigNames {
+				if other != name {
+					assert.NoFileExists(t, filepath.Join(dir, other), format)
+				}
+			}
+
+			loaded, err := gpm.loadProjectConfig()
+			assert.Nil(t, err, format)
+			assert.EqualValues(t, pc, loaded, format)
+			assert.EqualValues(t, "MIT", DetectLicense(dir), format)
+
+			_, err = gpm.Init(ctx, &InitOptions{Name: "project", Repository: "https://example.com/project"})
+			assert.EqualValues(t, ErrProjectExists, Code(err), format)
+		}
+	})
+
+	t.Run("Rejects multiple project files", func(t *testing.T) {
+		gpm, dir, cleanup := newProject(t)
+		defer cleanup()
+
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, ProjectConfigName), []byte("name: a\n"), 0644))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, ProjectConfigTOMLName), []byte("name = \"b\"\n"), 0644))
+
+		_, err := gpm.loadProjectConfig()
+		assert.EqualValues(t, ErrProjectConfig, Code(err))
+	})
+
+	t.Run("Reports diagnostics when loading invalid project files", func(t *testing.T) {
+		gpm, dir, cleanup := newProject(t)
+		defer cleanup()
+
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, ProjectConfigTOMLName), []byte("name = \"project\"\nlicence = \"MIT\"\n"), 0644))
+
+		_, err := gpm.loadProjectConfig()
+		assert.EqualValues(t, ErrProjectConfig, Code(err))
+
+		diags := Diagnostics{}
+		if assert.ErrorAs(t, err, &diags) && assert.Len(t, diags, 1) {
+			assert.EqualValues(t, 2, diags[0].Line)
+			assert.EqualValues(t, "licence", diags[0].Path)
+		}
+	})
+}
+
+func TestFmt(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	gpm := NewGPM(&CommonOptions{BasePath: dir})
+
+	write := func(name, data string) {
+		for _, n := range ProjectConfigNames {
+			os.Remove(filepath.Join(dir, n))
+		}
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+	read := func(name string) string {
+		d, err := ioutil.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		return string(d)
+	}
+
+	t.Run("Normalises yaml project files preserving comments", func(t *testing.T) {
+		write(ProjectConfigName, "# Project file\n"+
+			"dependencies:\n"+
+			"    # Core library\n"+
+			"    - version: \"^1.0.0\"\n"+
+			"      url:   https://example.com/core # pinned\n"+
+			"      path: lib/core\n"+
+			"license: MIT\n"+
+			"name:    project\n")
+
+		_, err := gpm.Fmt(ctx, &FmtOptions{Check: true})
+		assert.EqualValues(t, ErrProjectConfig, Code(err))
+
+		f, err := gpm.Fmt(ctx, &FmtOptions{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, &Formatted{File: ProjectConfigName, Changed: true}, f)
+		assert.EqualValues(t, "# Project file\n"+
+			"name: project\n"+
+			"license: MIT\n"+
+			"dependencies:\n"+
+			"  # Core library\n"+
+			"  - path: lib/core\n"+
+			"    url: https://example.com/core # pinned\n"+
+			"    version: ^1.0.0\n", read(ProjectConfigName))
+
+		f, err = gpm.Fmt(ctx, &FmtOptions{Check: true})
+		assert.Nil(t, err)
+		assert.EqualValues(t, &Formatted{File: ProjectConfigName, Changed: false}, f)
+	})
+
+	t.Run("Leaves formatted project files unchanged", func(t *testing.T) {
+		annotated, err := ioutil.ReadFile("testdata/annotated.yml")
+		assert.Nil(t, err)
+		write(ProjectConfigName, string(annotated))
+
+		f, err := gpm.Fmt(ctx, &FmtOptions{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, &Formatted{File: ProjectConfigName, Changed: false}, f)
+		assert.EqualValues(t, string(annotated), read(ProjectConfigName))
+	})
+
+	t.Run("Normalises TOML and JSON project files", func(t *testing.T) {
+		tests := []struct {
+			name, in, out string
+		}{
+			{
+				ProjectConfigTOMLName,
+				"dependencies = [{url = \"https://example.com/core\", path = \"lib/core\"}]\nname = \"project\"\n",
+				"name = \"project\"\n\n[[dependencies]]\npath = \"lib/core\"\nurl = \"https://example.com/core\"\n",
+			},
+			{
+				ProjectConfigJSONName,
+				`{"dependencies": [{"url": "https://example.com/core", "path": "lib/core"}], "name": "project"}`,
+				"{\n  \"name\": \"project\",\n  \"dependencies\": [\n    {\n      \"path\": \"lib/core\",\n      \"url\": \"https://example.com/core\"\n    }\n  ]\n}\n",
+			},
+		}
+
+		for _, test := range tests {
+			write(test.name, test.in)
+
+			f, err := gpm.Fmt(ctx, &FmtOptions{})
+			assert.Nil(t, err, test.name)
+			assert.EqualValues(t, &Formatted{File: test.name, Changed: true}, f)
+			assert.EqualValues(t, test.out, read(test.name))
+
+			_, err = gpm.Fmt(ctx, &FmtOptions{Check: true})
+			assert.Nil(t, err, test.name)
+		}
+	})
+
+	t.Run("Refuses to format invalid project files", func(t *testing.T) {
+		in := "name: project\ndependencies:\n- path: lib/core\n"
+		write(ProjectConfigName, in)
+
+		_, err := gpm.Fmt(ctx, &FmtOptions{})
+		assert.EqualValues(t, ErrProjectConfig, Code(err))
+		assert.EqualValues(t, in, read(ProjectConfigName))
+	})
+}
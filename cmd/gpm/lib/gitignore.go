@@ -0,0 +1,175 @@
+package gpm
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// GitIgnoreName is the project git ignore file
+	GitIgnoreName = ".gitignore"
+
+	// gitIgnoreBegin and gitIgnoreEnd delimit the block of module paths managed by gpm
+	gitIgnoreBegin = "# BEGIN gpm modules"
+	gitIgnoreEnd   = "# END gpm modules"
+)
+
+// renameGitIgnore rewrites entries for a module path in the managed block of the project
+// .gitignore file, leaving the file unchanged if it does not exist or has no managed block
+func (gpm *GPM) renameGitIgnore(from, to string) error {
+	p, err := gpm.options.fullPath(GitIgnoreName)
+	if err != nil {
+		return err
+	}
+
+	d, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return wrapError(ErrFilesystem, err, "Error reading file '%s'", GitIgnoreName)
+	}
+
+	lines := strings.Split(string(d), "\n")
+	managed, changed := false, false
+
+	for i, l := range lines {
+		switch strings.TrimSpace(l) {
+		case gitIgnoreBegin:
+			managed = true
+			continue
+		case gitIgnoreEnd:
+			managed = false
+			continue
+		}
+		if !managed {
+			continue
+		}
+
+		// Preserve leading and trailing separators, ie. `/lib/module/`
+		entry := strings.TrimSpace(l)
+		if strings.Trim(entry, "/") != strings.Trim(from, "/") {
+			continue
+		}
+		prefix, suffix := "", ""
+		if strings.HasPrefix(entry, "/") {
+			prefix = "/"
+		}
+		if strings.HasSuffix(entry, "/") {
+			suffix = "/"
+		}
+		lines[i] = prefix + strings.Trim(to, "/") + suffix
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	if err := writeFile(p, []byte(strings.Join(lines, "\n"))); err != nil {
+		return wrapError(ErrFilesystem, err, "Error writing to file '%s'", GitIgnoreName)
+	}
+
+	return nil
+}
+
+// addGitIgnore adds entries for module paths missing from the managed block of the project
+// .gitignore file, creating the file or managed block where required
+func (gpm *GPM) addGitIgnore(paths ...string) error {
+	return gpm.editGitIgnore(func(entries []string) []string {
+		for _, p := range paths {
+			if indexGitIgnore(entries, p) < 0 {
+				entries = append(entries, "/"+strings.Trim(p, "/")+"/")
+			}
+		}
+		return entries
+	})
+}
+
+// removeGitIgnore removes the entries for a module path from the managed block of the
+// project .gitignore file
+func (gpm *GPM) removeGitIgnore(path string) error {
+	return gpm.editGitIgnore(func(entries []string) []string {
+		for i := indexGitIgnore(entries, path); i >= 0; i = indexGitIgnore(entries, path) {
+			entries = append(entries[:i], entries[i+1:]...)
+		}
+		return entries
+	})
+}
+
+// indexGitIgnore finds the entry for a module path, ignoring leading and trailing separators
+func indexGitIgnore(entries []string, path string) int {
+	for i, e := range entries {
+		if strings.Trim(e, "/") == strings.Trim(path, "/") {
+			return i
+		}
+	}
+	return -1
+}
+
+// editGitIgnore applies an edit to the entries in the managed block of the project .gitignore
+// file, appending a managed block where none exists and only writing the file if changed
+func (gpm *GPM) editGitIgnore(edit func(entries []string) []string) error {
+	p, err := gpm.options.fullPath(GitIgnoreName)
+	if err != nil {
+		return err
+	}
+
+	d, err := ioutil.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return wrapError(ErrFilesystem, err, "Error reading file '%s'", GitIgnoreName)
+	}
+
+	// Split the file around the managed block
+	lines := strings.Split(strings.TrimSuffix(string(d), "\n"), "\n")
+	if len(d) == 0 {
+		lines = []string{}
+	}
+	begin, end := -1, len(lines)
+	for i, l := range lines {
+		switch strings.TrimSpace(l) {
+		case gitIgnoreBegin:
+			if begin < 0 {
+				begin = i
+			}
+		case gitIgnoreEnd:
+			if begin >= 0 && end == len(lines) {
+				end = i
+			}
+		}
+	}
+
+	var before, after, entries []string
+	if begin < 0 {
+		before, after = lines, []string{}
+	} else {
+		before, after = lines[:begin], []string{}
+		if end < len(lines) {
+			after = lines[end+1:]
+		}
+		for _, l := range lines[begin+1 : end] {
+			if e := strings.TrimSpace(l); e != "" {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	updated := edit(append([]string{}, entries...))
+	if begin >= 0 && strings.Join(updated, "\n") == strings.Join(entries, "\n") {
+		return nil
+	}
+	if begin < 0 && len(updated) == 0 {
+		return nil
+	}
+
+	out := append([]string{}, before...)
+	out = append(out, gitIgnoreBegin)
+	out = append(out, updated...)
+	out = append(out, gitIgnoreEnd)
+	out = append(out, after...)
+
+	if err := writeFile(p, []byte(strings.Join(out, "\n")+"\n")); err != nil {
+		return wrapError(ErrFilesystem, err, "Error writing to file '%s'", GitIgnoreName)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package gpm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitIgnore(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	gpm := NewGPM(&CommonOptions{BasePath: testDir})
+	p := filepath.Join(testDir, GitIgnoreName)
+
+	read := func(t *testing.T) string {
+		d, err := ioutil.ReadFile(p)
+		assert.Nil(t, err)
+		return string(d)
+	}
+
+	t.Run("Creates the managed block", func(t *testing.T) {
+		assert.Nil(t, gpm.removeGitIgnore("lib/a"))
+		_, err := os.Stat(p)
+		assert.True(t, os.IsNotExist(err))
+
+		assert.Nil(t, gpm.addGitIgnore("lib/a"))
+		assert.Equal(t, "# BEGIN gpm modules\n/lib/a/\n# END gpm modules\n", read(t))
+	})
+
+	t.Run("Preserves entries outside the managed block", func(t *testing.T) {
+		assert.Nil(t, ioutil.WriteFile(p, []byte("*.o\n# BEGIN gpm modules\nlib/a\n# END gpm modules\nbuild/"), 0644))
+
+		assert.Nil(t, gpm.addGitIgnore("lib/a", "lib/b"))
+		assert.Equal(t, "*.o\n# BEGIN gpm modules\nlib/a\n/lib/b/\n# END gpm modules\nbuild/\n", read(t))
+
+		// Unchanged entries are not rewritten
+		assert.Nil(t, gpm.addGitIgnore("lib/b"))
+		assert.Equal(t, "*.o\n# BEGIN gpm modules\nlib/a\n/lib/b/\n# END gpm modules\nbuild/\n", read(t))
+
+		assert.Nil(t, gpm.removeGitIgnore("lib/a"))
+		assert.Equal(t, "*.o\n# BEGIN gpm modules\n/lib/b/\n# END gpm modules\nbuild/\n", read(t))
+	})
+
+	t.Run("Appends the managed block to existing files", func(t *testing.T) {
+		assert.Nil(t, ioutil.WriteFile(p, []byte("*.o\n"), 0644))
+
+		assert.Nil(t, gpm.addGitIgnore("lib/a"))
+		assert.Equal(t, "*.o\n# BEGIN gpm modules\n/lib/a/\n# END gpm modules\n", read(t))
+	})
+}
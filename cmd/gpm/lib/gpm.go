@@ -1,379 +1,751 @@
 package gpm
 
 import (
-	"fmt"
-	"log"
+	"context"
 	"os"
+	"time"
 )
 
 // GPM is the core GoodPackageManager engine
 type GPM struct {
-	options *CommonOptions
+	options  *CommonOptions
+	logger   Logger
+	progress Progress
+	tx       *transaction
 }
 
-// NewGPM creates a new GPM instance with the provided options
+// NewGPM creates a new GPM instance with the provided options, logging to the standard logger
 func NewGPM(o *CommonOptions) *GPM {
-	return &GPM{options: o}
+	return &GPM{options: o, logger: stdLogger{}}
+}
+
+// WithLogger sets the logger used for GPM log outputs
+func (gpm *GPM) WithLogger(l Logger) *GPM {
+	gpm.logger = l
+	return gpm
+}
+
+// WithProgress sets the receiver for module clone, fetch and checkout progress events
+func (gpm *GPM) WithProgress(p Progress) *GPM {
+	gpm.progress = p
+	return gpm
 }
 
 // Init initialises a GPM project with the provided ProjectOptions
-func (gpm *GPM) Init(po *InitOptions) error {
+func (gpm *GPM) Init(ctx context.Context, po *InitOptions) (pc *ProjectConfig, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
+	// Check for an existing project config in any format
+	for _, name := range ProjectConfigNames {
+		fullpath, err := gpm.options.fullPath(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(fullpath); err == nil {
+			return nil, errorf(ErrProjectExists, "Project configuration '%s' already exists, try `gpm sync`", name)
+		}
+	}
+
+	// Validate ProjectOptions
+	if po == nil {
+		return nil, errorf(ErrInvalidOptions, "Project options must include a project name and repository")
+	}
+
+	// Detect missing project information
+	gpm.detectProject(po)
+	if po.Name == "" || po.Repository == "" {
+		return nil, errorf(ErrInvalidOptions, "Project options must include a project name and repository")
+	}
+
 	if gpm.options.Verbose {
-		log.Printf("Initialising project '%s' at '%s' \n", po.Name, gpm.options.BasePath)
+		gpm.logf("Initialising project '%s' at '%s' \n", po.Name, gpm.options.BasePath)
 	}
 
 	// Build new project information
-	pc := ProjectConfig{
+	pc = &ProjectConfig{
 		Name:       po.Name,
 		License:    po.License,
 		Homepage:   po.Homepage,
 		Repository: po.Repository,
+		Meta:       po.Meta,
 	}
+	locks := make(Locks)
 
-	// Attempt to load project config
-	if _, err := gpm.loadProjectConfig(); err == nil {
-		return fmt.Errorf("Project configuration already exists, try `gpm sync`")
-	}
-
-	// Validate ProjectOptions
-	if po == nil || (po.Name == "" || po.Repository == "") {
-		return fmt.Errorf("Project options must include a project name and repository")
+	// Import existing submodules
+	var modules []Module
+	if po.FromSubmodules {
+		modules, err = gpm.importSubmodules(ctx, pc, locks)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Save project file
-	if err := gpm.writeProjectConfig(&pc); err != nil {
-		return err
+	name := projectConfigFile(ProjectFormat(po.Format))
+	if err := gpm.options.writeProject(name, pc); err != nil {
+		return nil, err
 	}
 
 	// Save lock file
-	locks := make(Locks)
 	if err := gpm.writeLockfile(&locks); err != nil {
-		return err
+		return nil, err
 	}
 
 	if gpm.options.Verbose {
-		log.Printf("Init created project config '%s' in dir: '%s'\n", ProjectConfigName, gpm.options.BasePath)
+		gpm.logf("Init created project config '%s' in dir: '%s'\n", name, gpm.options.BasePath)
 	}
 
-	return nil
+	// Deinitialise last, as repository changes cannot be rolled back
+	if po.FromSubmodules && po.Deinit {
+		if err := gpm.deinitSubmodules(modules); err != nil {
+			return nil, err
+		}
+	}
+
+	return pc, nil
 }
 
 // Add adds a repository to the current project
-func (gpm *GPM) Add(ao *AddOptions) error {
+func (gpm *GPM) Add(ctx context.Context, ao *AddOptions) (m *Module, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
 	pc, err := gpm.loadProjectConfig()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Resolve package names through the package indexes
+	if ao.Args.Package != "" {
+		resolved := *ao
+		if err := gpm.resolvePackage(ctx, &pc, &resolved); err != nil {
+			return nil, err
+		}
+		ao = &resolved
 	}
 
 	if ao.Path == "" || ao.URL == "" {
-		return fmt.Errorf("Module path and url fields cannot be empty")
+		return nil, errorf(ErrInvalidOptions, "Module path and url fields cannot be empty")
+	}
+	if err := validateGroups(ao.Groups); err != nil {
+		return nil, err
+	}
+
+	// Check the module path is not already in use
+	_, replace := pc.Dependencies.Find(ao.Path)
+	if replace && !ao.Replace {
+		return nil, errorf(ErrInvalidOptions, "Dependency already bound to location '%s', use --replace to update it", ao.Path)
+	}
+	if n, ok := pc.Dependencies.Nested(ao.Path); ok {
+		return nil, errorf(ErrInvalidPath, "Module path '%s' overlaps dependency '%s', modules cannot be nested", ao.Path, n.Path)
 	}
 
 	if gpm.options.Verbose {
-		log.Printf("Adding dependency '%s' with url: '%s' at version: '%s'\n", ao.Path, ao.URL, ao.Version)
+		gpm.logf("Adding dependency '%s' with url: '%s' at version: '%s'\n", ao.Path, ao.URL, ao.Version)
 	}
 
 	// Determine full module path
-	modulePath, err := gpm.options.fullPath(ao.Path)
+	modulePath, err := gpm.modulePath(ao.Path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Create and clone a new repository
-	repo := NewRepo(modulePath, ao.URL)
-	if err := repo.Clone(); err != nil {
-		return err
+	// Create new dependency
+	d, _ := NewDependency(ao.Path, ao.URL, ao.Version)
+	d.Prefix, d.Prerelease = ao.Prefix, ao.Prerelease
+	d.Depth, d.FullHistory = ao.Depth, ao.FullHistory
+	d.Submodules, d.LFS, d.LFSURL = ao.Submodules, ao.LFS, ao.LFSURL
+	d.Groups = ao.Groups
+
+	// Create and clone a new repository, or update the existing module when replacing
+	var repo *Repo
+	if replace {
+		repo, err = gpm.fetchRepo(ctx, d, modulePath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		repo = gpm.newRepo(d, modulePath)
+		if err := gpm.cloneRepo(ctx, repo); err != nil {
+			return nil, err
+		}
 	}
 
 	// Create a tag map
-	tags, err := repo.GetTags()
+	tags, err := gpm.moduleTags(d, repo, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if gpm.options.Verbose {
-		log.Printf("Tags: \n")
+		gpm.logf("Tags: \n")
 		for k, v := range tags {
-			log.Printf("\t- %s: %+v", k, v)
+			gpm.logf("\t- %s: %+v", k, v)
 		}
 	}
 
 	// Fetch latest matching tag if available
 	latestTag, latestHash, err := tags.GetLatest(ao.Version)
 	if err != nil {
-		return err
+		return nil, wrapError(ErrInvalidVersion, err, "Invalid version range '%s' for module '%s'", ao.Version, ao.Path)
+	}
+	if latestHash == "" {
+		return nil, errorf(ErrNoMatchingTag, "No tags matching '%s' for module '%s'", ao.Version, ao.Path)
 	}
-	if latestTag != "" && latestHash != "" && gpm.options.Verbose {
-		log.Printf("Add (%s) Latest matching tag: %s hash: %s", ao.Path, latestTag, latestHash)
+	if gpm.options.Verbose {
+		gpm.logf("Add (%s) Latest matching tag: %s hash: %s", ao.Path, latestTag, latestHash)
 	}
-	if ao.Version == "" {
-		ao.Version = latestTag
+	if d.Version == "" {
+		d.Version = latestTag
 	}
 
 	// Sync latest matching hash into worktree
-	if err := repo.SyncHash(latestHash); err != nil {
-		return err
+	if err := gpm.syncHash(ctx, repo, latestHash); err != nil {
+		return nil, err
 	}
 
-	// Create new dependency
-	d, _ := NewDependency(ao.Path, ao.URL, ao.Version)
+	// Check module license against project policy
+	if err := gpm.checkLicense(&pc, ao.Path); err != nil {
+		return nil, err
+	}
 
 	// Update project config file
-	pc.Dependencies = append(pc.Dependencies, *d)
+	if replace {
+		pc.Dependencies.Set(ao.Path, *d)
+	} else {
+		pc.Dependencies = append(pc.Dependencies, *d)
+	}
 	if err := gpm.writeProjectConfig(&pc); err != nil {
-		return err
+		return nil, err
 	}
 
 	if gpm.options.Verbose {
-		log.Printf("Add (%s) Updated project config '%s' in dir: '%s'\n", ao.Path, ProjectConfigName, gpm.options.BasePath)
+		gpm.logf("Add (%s) Updated project config in dir: '%s'\n", ao.Path, gpm.options.BasePath)
 	}
 
 	// Update lock file
 	locks, err := gpm.loadLockfile()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	locks[ao.Path] = latestHash
+	locks[ao.Path], err = gpm.lockModule(d, repo, latestHash)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := gpm.writeLockfile(&locks); err != nil {
-		return err
+		return nil, err
 	}
 
 	if gpm.options.Verbose {
-		log.Printf("Add (%s) Updated lockfile '%s' in dir: '%s'\n", ao.Path, LockfileName, gpm.options.BasePath)
+		gpm.logf("Add (%s) Updated lockfile '%s' in dir: '%s'\n", ao.Path, LockfileName, gpm.options.BasePath)
 	}
 
-	// TODO: Add module path to .gitignore
+	// Ignore the module worktree in the project repository
+	if err := gpm.addGitIgnore(ao.Path); err != nil {
+		return nil, err
+	}
 
-	return err
+	return &Module{Dependency: *d, Tag: latestTag, Hash: latestHash}, nil
 }
 
 // Sync pulls and updates dependencies to match lockfile version hashes
-func (gpm *GPM) Sync(so *SyncOptions) error {
+func (gpm *GPM) Sync(ctx context.Context, so *SyncOptions) (modules []Module, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
 	pc, err := gpm.loadProjectConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	locks, err := gpm.loadLockfile()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := so.validate(pc.Dependencies); err != nil {
+		return nil, err
+	}
+
+	modules = make([]Module, 0)
+	updated := false
+
+	// Resume from modules completed by an interrupted sync
+	state := make(Locks)
+	if !so.Restart {
+		state = gpm.loadSyncState()
 	}
 
 	for _, v := range pc.Dependencies {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+		if !so.selects(&v) {
+			if gpm.options.Verbose {
+				gpm.logf("Sync (%s) not in selected groups, skipping\n", v.Path)
+			}
+			continue
+		}
+
 		// Resolve full module path
-		modulePath, err := gpm.options.fullPath(v.Path)
+		modulePath, err := gpm.modulePath(v.Path)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		// Create repo object to work with
-		repo := NewRepo(modulePath, v.URL)
-
-		// Clone or open and update repo depending on current state
-		if !repo.Exists() {
-			if gpm.options.Verbose {
-				log.Printf("Sync (%s) not found, cloning\n", v.Path)
-			}
-			if err := repo.Clone(); err != nil {
-				return err
-			}
-		} else {
-			if gpm.options.Verbose {
-				log.Printf("Sync (%s) opening\n", v.Path)
-			}
-			if err := repo.Open(); err != nil {
-				return err
-			}
+		if repo, ok := gpm.resumeModule(&v, modulePath, locks[v.Path], state[v.Path]); ok {
 			if gpm.options.Verbose {
-				log.Printf("Sync (%s) updating\n", v.Path)
+				gpm.logf("Sync (%s) synced by interrupted sync, skipping\n", v.Path)
 			}
-			if err := repo.Fetch(); err != nil {
-				return err
+			if locks[v.Path] != state[v.Path] {
+				locks[v.Path] = state[v.Path]
+				updated = true
 			}
+			modules = append(modules, gpm.newModule(v, repo, state[v.Path].Hash))
+			continue
+		}
+
+		// Clone or open and update repo depending on current state
+		repo, err := gpm.fetchRepo(ctx, &v, modulePath)
+		if err != nil {
+			return nil, err
 		}
 
 		// Locate the matching lock hash
-		hash, ok := locks[v.Path]
+		lock, ok := locks[v.Path]
 		if !ok {
 			if gpm.options.Verbose {
-				log.Printf("Sync (%s) could not find locked hash, try 'gpm update'\n", v.Path)
+				gpm.logf("Sync (%s) could not find locked hash, try 'gpm update'\n", v.Path)
 			}
-			return fmt.Errorf("Missing lock hash for module '%s'", v.Path)
+			return nil, errorf(ErrMissingLock, "Missing lock hash for module '%s'", v.Path)
+		}
+		hash := lock.Hash
+
+		// Record remote changes in the lockfile
+		if lock.URL != v.URL {
+			gpm.reconcileLock(ctx, &v, repo, &lock)
+			updated = true
 		}
 
 		// Sync hash to repo
 		if gpm.options.Verbose {
-			log.Printf("Sync (%s) checking out hash '%s'\n", v.Path, hash)
+			gpm.logf("Sync (%s) checking out hash '%s'\n", v.Path, hash)
+		}
+		if err := gpm.syncHash(ctx, repo, hash); err != nil {
+			return nil, err
+		}
+
+		// Verify submodule and LFS contents against the lock
+		digest, err := gpm.moduleDigest(&v, repo)
+		if err != nil {
+			return nil, err
 		}
-		if err := repo.SyncHash(hash); err != nil {
-			return err
+		if lock.Digest != "" && digest != "" && lock.Digest != digest {
+			return nil, errorf(ErrDigestMismatch, "Module '%s' digest '%s' does not match locked digest '%s'", v.Path, digest, lock.Digest)
+		}
+		if lock.Digest != digest {
+			lock.Digest = digest
+			updated = true
+		}
+		locks[v.Path] = lock
+
+		// Record completed modules so an interrupted sync can be resumed
+		gpm.keepModule(repo)
+		state[v.Path] = lock
+		if err := gpm.writeSyncState(state); err != nil {
+			return nil, err
+		}
+
+		modules = append(modules, gpm.newModule(v, repo, hash))
+	}
+
+	if updated {
+		if err := gpm.writeLockfile(&locks); err != nil {
+			return nil, err
 		}
 	}
+	if err := gpm.clearSyncState(); err != nil {
+		return nil, err
+	}
 
-	// TODO: Check module path exists in .gitignore
+	// Ignore module worktrees in the project repository
+	paths := make([]string, 0, len(pc.Dependencies))
+	for _, d := range pc.Dependencies {
+		paths = append(paths, d.Path)
+	}
+	if err := gpm.addGitIgnore(paths...); err != nil {
+		return nil, err
+	}
 
-	return nil
+	return modules, nil
 }
 
 // Update updates lockfile hashes based on the current semver range
-func (gpm *GPM) Update(uo *UpdateOptions) error {
+func (gpm *GPM) Update(ctx context.Context, uo *UpdateOptions) (modules []Module, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
 	pc, err := gpm.loadProjectConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	locks, err := gpm.loadLockfile()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := uo.validate(pc.Dependencies); err != nil {
+		return nil, err
 	}
 
+	modules = make([]Module, 0)
+
 	for _, v := range pc.Dependencies {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+
+		// Locks for excluded dependencies are preserved, so the lockfile covers all groups
+		if !uo.selects(&v) {
+			if gpm.options.Verbose {
+				gpm.logf("Update (%s) not in selected groups, skipping\n", v.Path)
+			}
+			continue
+		}
+
 		// Resolve full module path
-		modulePath, err := gpm.options.fullPath(v.Path)
+		modulePath, err := gpm.modulePath(v.Path)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		// Create repo object to work with
-		repo := NewRepo(modulePath, v.URL)
-
 		// Clone or open and update repo depending on current state
-		if !repo.Exists() {
-			if gpm.options.Verbose {
-				log.Printf("Sync (%s) not found, cloning\n", v.Path)
-			}
-			if err := repo.Clone(); err != nil {
-				return err
-			}
-		} else {
-			if gpm.options.Verbose {
-				log.Printf("Sync (%s) opening\n", v.Path)
-			}
-			if err := repo.Open(); err != nil {
-				return err
-			}
-			if gpm.options.Verbose {
-				log.Printf("Sync (%s) updating\n", v.Path)
-			}
-			if err := repo.Fetch(); err != nil {
-				return err
-			}
+		repo, err := gpm.fetchRepo(ctx, &v, modulePath)
+		if err != nil {
+			return nil, err
 		}
 
 		// Create a tag map
-		tags, err := repo.GetTags()
+		tags, err := gpm.moduleTags(&v, repo, false)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// Fetch latest matching tag if available
 		latestTag, latestHash, err := tags.GetLatest(v.Version)
 		if err != nil {
-			return err
+			return nil, wrapError(ErrInvalidVersion, err, "Invalid version range '%s' for module '%s'", v.Version, v.Path)
+		}
+		if latestHash == "" {
+			return nil, errorf(ErrNoMatchingTag, "No tags matching '%s' for module '%s'", v.Version, v.Path)
 		}
-		if latestTag != "" && latestHash != "" && gpm.options.Verbose {
-			log.Printf("Add (%s) Latest matching tag: %s hash: %s", v.Path, latestTag, latestHash)
+		if gpm.options.Verbose {
+			gpm.logf("Update (%s) Latest matching tag: %s hash: %s", v.Path, latestTag, latestHash)
 		}
 		if v.Version == "" {
 			v.Version = latestTag
 		}
 
 		// Sync latest matching hash into worktree
-		if err := repo.SyncHash(latestHash); err != nil {
-			return err
+		if err := gpm.syncHash(ctx, repo, latestHash); err != nil {
+			return nil, err
 		}
 
-		locks[v.Path] = latestHash
+		// Check module license against project policy
+		if err := gpm.checkLicense(&pc, v.Path); err != nil {
+			return nil, err
+		}
+
+		locks[v.Path], err = gpm.lockModule(&v, repo, latestHash)
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, Module{Dependency: v, Tag: latestTag, Hash: latestHash})
 	}
 
 	if err = gpm.writeLockfile(&locks); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return modules, nil
 }
 
 // Remove removes the specified dependency
-func (gpm *GPM) Remove(rm *RemoveOptions) error {
+func (gpm *GPM) Remove(ctx context.Context, rm *RemoveOptions) (m *Module, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
 	pc, err := gpm.loadProjectConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if gpm.options.Verbose {
-		log.Printf("Remove (%s) removing module\n", rm.Path)
+		gpm.logf("Remove (%s) removing module\n", rm.Path)
 	}
 
 	// Check dependency exists in the list
 	dep, ok := pc.Dependencies.Find(rm.Path)
 	if !ok {
-		return fmt.Errorf("No dependency bound to location '%s'", rm.Path)
+		return nil, errorf(ErrNoDependency, "No dependency bound to location '%s'", rm.Path)
 	}
 
 	// Resolve full module path
-	modulePath, err := gpm.options.fullPath(rm.Path)
+	modulePath, err := gpm.modulePath(rm.Path)
 	if err != nil {
-		return err
-	}
-
-	// TODO: check path is ok
-	repo := NewRepo(modulePath, dep.URL)
-
-	// Remove repo from location if it exists
-	if repo.Exists() {
-		// TODO: check if repo is dirty first
-
-		// Remove repo folder
-		err = os.Remove(modulePath)
-		if err != nil {
-			return err
-		}
+		return nil, err
 	}
 
 	// Remove from project config
 	pc.Dependencies.Delete(rm.Path)
 	err = gpm.writeProjectConfig(&pc)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Remove from lockfile
 	locks, err := gpm.loadLockfile()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	hash := locks[rm.Path].Hash
 	delete(locks, rm.Path)
 	err = gpm.writeLockfile(&locks)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Stop ignoring the module worktree in the project repository
+	if err := gpm.removeGitIgnore(rm.Path); err != nil {
+		return nil, err
+	}
+
+	// Remove module last, as the worktree cannot be restored on failure
+	if err := gpm.removeModule(rm, NewRepo(modulePath, dep.URL)); err != nil {
+		return nil, err
+	}
+
+	return &Module{Dependency: *dep, Hash: hash}, nil
+}
+
+// removeModule deletes a module worktree, refusing to follow symlinks, to remove directories
+// that are not git repositories, or to discard local changes unless forced
+func (gpm *GPM) removeModule(rm *RemoveOptions, repo *Repo) error {
+	info, err := os.Lstat(repo.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return wrapError(ErrFilesystem, err, "Error reading module '%s'", rm.Path)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		return errorf(ErrInvalidPath, "Module path '%s' is a symlink, refusing to remove", rm.Path)
+	}
+	if !info.IsDir() || !repo.Exists() {
+		return errorf(ErrInvalidPath, "Module path '%s' is not a git repository, refusing to remove", rm.Path)
+	}
+
+	if err := repo.Open(); err != nil {
+		return wrapError(ErrRepository, err, "Error opening module '%s'", rm.Path)
+	}
+	modified, err := repo.Modified()
+	if err != nil {
+		return wrapError(ErrRepository, err, "Error checking module '%s' for changes", rm.Path)
+	}
+	if modified && !rm.Force {
+		return errorf(ErrDirtyModule, "Module '%s' has local changes, use --force to remove", rm.Path)
 	}
 
-	// TODO: Remove module path from .gitignore
+	if err := os.RemoveAll(repo.path); err != nil {
+		return wrapError(ErrFilesystem, err, "Error removing module '%s'", rm.Path)
+	}
 
 	return nil
 }
 
+// newRepo creates a repo instance for a dependency, reporting transfer progress if enabled
+func (gpm *GPM) newRepo(d *Dependency, modulePath string) *Repo {
+	repo := NewRepo(modulePath, d.URL).
+		WithDepth(d.CloneDepth()).
+		WithSubmodules(d.Submodules).
+		WithLFS(d.LFSEndpoint()).
+		WithRetry(gpm.retryPolicy(d))
+	if gpm.progress != nil {
+		repo.WithProgress(&progressWriter{path: d.Path, progress: gpm.progress})
+	}
+	return repo
+}
+
+// retryPolicy builds the clone and fetch retry policy for a dependency, logging and
+// reporting progress for each retry
+func (gpm *GPM) retryPolicy(d *Dependency) RetryPolicy {
+	return RetryPolicy{
+		Retries: gpm.options.Retries,
+		Backoff: gpm.options.RetryBackoff,
+		Timeout: gpm.options.Timeout,
+		OnRetry: func(retry int, delay time.Duration, err error) {
+			gpm.logf("Warning: error fetching module '%s' (%s), retrying in %s (%d/%d)\n", d.Path, err, delay, retry, gpm.options.Retries)
+			gpm.emitProgress(ProgressEvent{Type: ProgressRetrying, Path: d.Path, URL: d.URL, Retry: retry, Err: err})
+		},
+	}
+}
+
+// fetchRepo clones a module repository if it does not exist, or opens and fetches updates if it does
+func (gpm *GPM) fetchRepo(ctx context.Context, d *Dependency, modulePath string) (*Repo, error) {
+	repo := gpm.newRepo(d, modulePath)
+
+	if !repo.Exists() {
+		if gpm.options.Verbose {
+			gpm.logf("Sync (%s) not found, cloning with depth %d\n", d.Path, d.CloneDepth())
+		}
+		if err := gpm.cloneRepo(ctx, repo); err != nil {
+			return nil, err
+		}
+		return repo, nil
+	}
+
+	if gpm.options.Verbose {
+		gpm.logf("Sync (%s) opening\n", d.Path)
+	}
+	if err := repo.Open(); err != nil {
+		return nil, wrapError(ErrRepository, err, "Error opening module '%s'", d.Path)
+	}
+	if url := repo.RemoteURL(); url != d.URL {
+		if gpm.options.Verbose {
+			gpm.logf("Sync (%s) updating remote from '%s' to '%s'\n", d.Path, url, d.URL)
+		}
+		if err := repo.Sync(); err != nil {
+			return nil, wrapError(ErrRemote, err, "Error updating remote for module '%s'", d.Path)
+		}
+	}
+	if gpm.options.Verbose {
+		gpm.logf("Sync (%s) updating\n", d.Path)
+	}
+	gpm.emitProgress(ProgressEvent{Type: ProgressFetchStarted, Path: d.Path, URL: d.URL})
+	if err := repo.Fetch(ctx); err != nil {
+		return nil, wrapError(ErrRemote, contextError(ctx, err), "Error fetching module '%s'", d.Path)
+	}
+
+	// Fetch the full history for existing shallow clones where required
+	if d.FullHistory && repo.Shallow() {
+		if gpm.options.Verbose {
+			gpm.logf("Sync (%s) fetching full history\n", d.Path)
+		}
+		if err := repo.Unshallow(ctx); err != nil {
+			return nil, wrapError(ErrRemote, contextError(ctx, err), "Error fetching history for module '%s'", d.Path)
+		}
+	}
+
+	return repo, nil
+}
+
+// moduleDigest computes the submodule and LFS digest for a synced module
+func (gpm *GPM) moduleDigest(d *Dependency, repo *Repo) (string, error) {
+	digest, err := repo.Digest()
+	return digest, wrapError(ErrRepository, err, "Error computing digest for module '%s'", d.Path)
+}
+
+// lockModule builds the lock for a module synced to the provided hash
+func (gpm *GPM) lockModule(d *Dependency, repo *Repo, hash string) (Lock, error) {
+	digest, err := gpm.moduleDigest(d, repo)
+	if err != nil {
+		return Lock{}, err
+	}
+	return Lock{Hash: hash, URL: d.URL, Digest: digest}, nil
+}
+
+// reconcileLock records the dependency remote in a module lock, warning where the
+// remote has changed and the locked commit cannot be found on the new remote
+func (gpm *GPM) reconcileLock(ctx context.Context, d *Dependency, repo *Repo, lock *Lock) {
+	if lock.URL != "" {
+		if gpm.options.Verbose {
+			gpm.logf("Sync (%s) remote changed from '%s' to '%s'\n", d.Path, lock.URL, d.URL)
+		}
+		if ok, err := repo.RemoteContains(ctx, lock.Hash); err != nil {
+			gpm.logf("Warning: unable to check remote '%s' for locked commit '%s' of module '%s' (%s)\n", d.URL, lock.Hash, d.Path, err)
+		} else if !ok {
+			gpm.logf("Warning: locked commit '%s' for module '%s' was not found on remote '%s', try 'gpm update'\n", lock.Hash, d.Path, d.URL)
+		}
+	}
+
+	lock.URL = d.URL
+}
+
+// newModule builds a module description for a dependency synced to the provided hash
+func (gpm *GPM) newModule(d Dependency, repo *Repo, hash string) Module {
+	m := Module{Dependency: d, Hash: hash}
+	if tags, err := gpm.moduleTags(&d, repo, true); err == nil {
+		m.Tag, _ = tags.Find(hash)
+	}
+	return m
+}
+
+// moduleTags loads the tags eligible as versions for a dependency, applying the dependency
+// tag prefix and including prereleases if enabled for the dependency or by the caller
+func (gpm *GPM) moduleTags(d *Dependency, repo *Repo, prerelease bool) (Tags, error) {
+	all, err := repo.GetTags()
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error reading tags for module '%s'", d.Path)
+	}
+
+	tags, skipped := all.Select(d.Prefix, d.Prerelease || prerelease || namesPrerelease(d.Version))
+
+	if gpm.options.Verbose {
+		for t, reason := range skipped {
+			gpm.logf("Tags (%s) skipping tag '%s' (%s)", d.Path, t, reason)
+		}
+	}
+
+	return tags, nil
+}
+
+// logf writes a formatted log output using the configured logger
+func (gpm *GPM) logf(format string, args ...interface{}) {
+	if gpm.logger == nil {
+		gpm.logger = stdLogger{}
+	}
+	gpm.logger.Printf(format, args...)
+}
+
 func (gpm *GPM) loadProjectConfig() (pc ProjectConfig, err error) {
-	err = gpm.options.loadYaml(ProjectConfigName, &pc)
+	name, err := gpm.options.projectFile()
+	if err != nil {
+		return ProjectConfig{Dependencies: make(Dependencies, 0)}, err
+	}
+	err = gpm.options.loadProject(name, &pc)
 	if err != nil && gpm.options.Verbose {
-		log.Printf("Error loading project config '%s", ProjectConfigName)
+		gpm.logf("Error loading project config '%s'", name)
 	}
 	if pc.Dependencies == nil {
 		pc.Dependencies = make(Dependencies, 0)
 	}
-	return pc, err
+	return pc, wrapError(ErrProjectConfig, err, "Error loading project config '%s'", name)
 }
 
 func (gpm *GPM) writeProjectConfig(pc *ProjectConfig) error {
-	return gpm.options.writeYaml(ProjectConfigName, pc)
+	name, err := gpm.options.projectFile()
+	if err != nil {
+		return err
+	}
+	return gpm.options.writeProject(name, pc)
 }
 
 func (gpm *GPM) loadLockfile() (locks Locks, err error) {
 	err = gpm.options.loadYaml(LockfileName, &locks)
 	if err != nil && gpm.options.Verbose {
-		log.Printf("Error loading lock file '%s", LockfileName)
+		gpm.logf("Error loading lock file '%s", LockfileName)
 	}
-	return locks, err
+	return locks, wrapError(ErrLockfile, err, "Error loading lock file '%s'", LockfileName)
 }
 
 func (gpm *GPM) writeLockfile(locks *Locks) error {
@@ -1,50 +1,74 @@
 package gpm
 
 import (
-	"fmt"
+	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestMain(t *testing.T) {
+func TestGPM(t *testing.T) {
+	gpmtest.RequireGit(t)
+
 	// Setup temporary test directory
 	testDir, err := ioutil.TempDir("", "gpm-test")
 	if err != nil {
 		t.FailNow()
 	}
-	//	defer os.Remove(testDir)
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0")
 
-	configFile := fmt.Sprintf("%s/%s", testDir, ProjectConfigName)
-	t.Logf("GPM test dir: %s config: %s", testDir, configFile)
+	server := gpmtest.NewServer(t, testDir)
+	defer server.Close()
+
+	// Run the suite against each supported transport
+	transports := []struct {
+		name, url string
+	}{
+		{"file", module.URL()},
+		{"http", server.RepoURL(module)},
+	}
+
+	for _, tr := range transports {
+		t.Run(tr.name, func(t *testing.T) {
+			projectDir := filepath.Join(testDir, tr.name)
+			assert.Nil(t, os.Mkdir(projectDir, 0755))
+			testProject(t, projectDir, tr.url, module.Hashes)
+		})
+	}
+}
+
+// testProject runs the project lifecycle against a module repository
+func testProject(t *testing.T, testDir, testRepo string, hashes map[string]string) {
+	t.Logf("GPM test dir: %s repository: %s", testDir, testRepo)
 
 	o := CommonOptions{
-		BasePath:  testDir,
-		Verbose:   true,
-		NoCleanup: true,
+		BasePath: testDir,
+		Verbose:  true,
 	}
 
 	gpm := GPM{options: &o}
 
 	po := InitOptions{
 		Name:       "Test Project",
-		Repository: "https://github.com/ryankurte/test-repo",
+		Repository: testRepo,
 		License:    "MIT",
 	}
 
 	versionZeroOneZero := "v0.1.0"
-	hashZeroOneZero := "5a83540f87665410bb6440b97f6c2f71a27471bd"
+	hashZeroOneZero := hashes[versionZeroOneZero]
 
 	versionZeroTwoZero := "v0.2.0"
-	hashZeroTwoZero := "2075d8e0a26e6104b212428186bec59edad15e42"
-
-	testRepo := "https://github.com/ryankurte/test-repo"
+	hashZeroTwoZero := hashes[versionZeroTwoZero]
 
 	t.Run("Initialise a project", func(t *testing.T) {
 		// Initialise project with the provided options
-		err := gpm.Init(&po)
+		_, err := gpm.Init(context.Background(), &po)
 		assert.Nil(t, err)
 
 		// Check config is correct
@@ -55,14 +79,14 @@ func TestMain(t *testing.T) {
 	})
 
 	t.Run("Re-init fails", func(t *testing.T) {
-		err := gpm.Init(&po)
+		_, err := gpm.Init(context.Background(), &po)
 		assert.NotNil(t, err)
 	})
 
 	t.Run("Add a dependency (no version)", func(t *testing.T) {
 		d, _ := NewDependency("test1", testRepo, "")
 
-		err := gpm.Add(&AddOptions{d.Path, d.URL, d.Version})
+		_, err := gpm.Add(context.Background(), &AddOptions{Path: d.Path, URL: d.URL, Version: d.Version})
 		assert.Nil(t, err)
 
 		// Check dependency got added to config
@@ -79,13 +103,17 @@ func TestMain(t *testing.T) {
 
 		locks, err := gpm.loadLockfile()
 		assert.Nil(t, err)
-		assert.EqualValues(t, hashZeroTwoZero, locks["test1"])
+		assert.EqualValues(t, hashZeroTwoZero, locks["test1"].Hash)
+
+		ignore, err := ioutil.ReadFile(filepath.Join(testDir, GitIgnoreName))
+		assert.Nil(t, err)
+		assert.Contains(t, string(ignore), "/test1/\n")
 	})
 
 	t.Run("Add a dependency (with version)", func(t *testing.T) {
 		d, _ := NewDependency("test2", testRepo, versionZeroOneZero)
 
-		err := gpm.Add(&AddOptions{d.Path, d.URL, d.Version})
+		_, err := gpm.Add(context.Background(), &AddOptions{Path: d.Path, URL: d.URL, Version: d.Version})
 		assert.Nil(t, err)
 
 		// Check dependency got added to config
@@ -102,7 +130,37 @@ func TestMain(t *testing.T) {
 
 		locks, err := gpm.loadLockfile()
 		assert.Nil(t, err)
-		assert.EqualValues(t, hashZeroOneZero, locks["test2"])
+		assert.EqualValues(t, hashZeroOneZero, locks["test2"].Hash)
+	})
+
+	t.Run("Rejects duplicate and nested dependencies", func(t *testing.T) {
+		_, err := gpm.Add(context.Background(), &AddOptions{Path: "test2", URL: testRepo})
+		assert.EqualValues(t, ErrInvalidOptions, Code(err))
+
+		_, err = gpm.Add(context.Background(), &AddOptions{Path: "test2/nested", URL: testRepo})
+		assert.EqualValues(t, ErrInvalidPath, Code(err))
+
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(pc.Dependencies))
+		assert.NoDirExists(t, filepath.Join(testDir, "test2/nested"))
+	})
+
+	t.Run("Replaces a dependency in place", func(t *testing.T) {
+		for _, version := range []string{versionZeroTwoZero, versionZeroOneZero} {
+			_, err := gpm.Add(context.Background(), &AddOptions{Path: "test2", URL: testRepo, Version: version, Replace: true})
+			assert.Nil(t, err)
+
+			pc, err := gpm.loadProjectConfig()
+			assert.Nil(t, err)
+			if assert.EqualValues(t, 2, len(pc.Dependencies)) {
+				assert.EqualValues(t, Dependency{Path: "test2", URL: testRepo, Version: version}, pc.Dependencies[1])
+			}
+
+			locks, err := gpm.loadLockfile()
+			assert.Nil(t, err)
+			assert.EqualValues(t, hashes[version], locks["test2"].Hash)
+		}
 	})
 
 	t.Run("Updates dependencies", func(t *testing.T) {
@@ -111,19 +169,19 @@ func TestMain(t *testing.T) {
 		pc.Dependencies.Set("test2", *d)
 		assert.Nil(t, gpm.writeProjectConfig(&pc))
 
-		err = gpm.Update(&UpdateOptions{})
+		_, err = gpm.Update(context.Background(), &UpdateOptions{})
 		assert.Nil(t, err)
 
 		locks, err := gpm.loadLockfile()
 		assert.Nil(t, err)
-		assert.EqualValues(t, hashZeroTwoZero, locks["test2"])
+		assert.EqualValues(t, hashZeroTwoZero, locks["test2"].Hash)
 	})
 
 	t.Run("Syncs removed dependencies", func(t *testing.T) {
 		p, _ := o.fullPath("test2")
 		os.RemoveAll(p)
 
-		err := gpm.Sync(&SyncOptions{})
+		_, err := gpm.Sync(context.Background(), &SyncOptions{})
 		assert.Nil(t, err)
 
 		if _, err := os.Stat(p); os.IsNotExist(err) {
@@ -135,7 +193,7 @@ func TestMain(t *testing.T) {
 		p, _ := o.fullPath("test2")
 		os.RemoveAll(p)
 
-		err := gpm.Remove(&RemoveOptions{"test2"})
+		_, err := gpm.Remove(context.Background(), &RemoveOptions{Path: "test2"})
 		assert.Nil(t, err)
 
 		if _, err := os.Stat(p); !os.IsNotExist(err) {
@@ -145,6 +203,10 @@ func TestMain(t *testing.T) {
 		pc, err := gpm.loadProjectConfig()
 		assert.Nil(t, err)
 		assert.EqualValues(t, 1, len(pc.Dependencies))
+
+		ignore, err := ioutil.ReadFile(filepath.Join(testDir, GitIgnoreName))
+		assert.Nil(t, err)
+		assert.Equal(t, gitIgnoreBegin+"\n/test1/\n"+gitIgnoreEnd+"\n", string(ignore))
 	})
 
 }
@@ -0,0 +1,247 @@
+// Package gpmtest provides fixture git repositories for testing gpm without network access.
+//
+// Fixture repositories are built on the fly with arbitrary commit and tag histories,
+// and are available to tests as bare repositories via file:// URLs or a local HTTP server.
+// Building fixtures requires the git command line tool, use RequireGit to skip tests where
+// it is not available.
+package gpmtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const (
+	// Name is the author, committer and tagger name used for fixture repositories
+	Name = "gpm"
+	// Email is the author, committer and tagger email used for fixture repositories
+	Email = "gpm@example.com"
+)
+
+// TagKind is the kind of tag created in a fixture repository
+type TagKind int
+
+// Tag kinds
+const (
+	Annotated   TagKind = iota // Annotated tag object
+	Lightweight                // Lightweight reference to a commit
+	Signed                     // Annotated tag object signed with the repository signing key
+)
+
+// RequireGit skips the test if the git command line tool is not available
+func RequireGit(t testing.TB) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+}
+
+// Git runs a git command in the provided directory with a fixed identity, returning the
+// trimmed output and failing the test on error
+func Git(t testing.TB, dir string, args ...string) string {
+	t.Helper()
+
+	out, err := run(dir, args...)
+	if err != nil {
+		t.Fatalf("git %v failed: %s (%s)", args, err, out)
+	}
+	return out
+}
+
+// run runs a git command in the provided directory with a fixed identity
+func run(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{
+		"-c", "protocol.file.allow=always",
+		"-c", "init.defaultBranch=master",
+		"-c", "commit.gpgsign=false",
+		"-c", "tag.gpgsign=false",
+	}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+Name, "GIT_AUTHOR_EMAIL="+Email,
+		"GIT_COMMITTER_NAME="+Name, "GIT_COMMITTER_EMAIL="+Email,
+		"GIT_CONFIG_NOSYSTEM=1",
+	)
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// Repo is a fixture git repository, built in a working copy and published to a bare repository
+type Repo struct {
+	Path   string            // Path is the bare repository path
+	Hashes map[string]string // Hashes are the commit hashes by commit message
+
+	t    testing.TB
+	work string
+	key  *openpgp.Entity
+}
+
+// NewRepo creates an empty fixture repository in the provided directory, with the
+// bare repository at `<dir>/bare.git`
+func NewRepo(t testing.TB, dir string) *Repo {
+	t.Helper()
+
+	r := Repo{
+		Path:   filepath.Join(dir, "bare.git"),
+		Hashes: make(map[string]string),
+		t:      t,
+		work:   filepath.Join(dir, "work"),
+	}
+
+	if err := os.MkdirAll(r.work, 0755); err != nil {
+		t.Fatalf("Error creating fixture directory '%s' (%s)", r.work, err)
+	}
+	Git(t, dir, "init", "-q", "--bare", r.Path)
+	Git(t, r.work, "init", "-q")
+	Git(t, r.work, "remote", "add", "origin", r.Path)
+
+	return &r
+}
+
+// Versions creates a fixture repository with a commit for each provided name, tagging
+// commits with names starting with "v", alternating between annotated and lightweight tags
+func Versions(t testing.TB, dir string, names ...string) *Repo {
+	t.Helper()
+
+	r := NewRepo(t, dir)
+	for i, name := range names {
+		r.Commit(name, nil)
+		if !strings.HasPrefix(name, "v") {
+			continue
+		}
+		if i%2 == 0 {
+			r.Tag(name, Annotated)
+		} else {
+			r.Tag(name, Lightweight)
+		}
+	}
+
+	return r
+}
+
+// URL fetches the file:// URL for the bare repository
+func (r *Repo) URL() string {
+	return "file://" + filepath.ToSlash(r.Path)
+}
+
+// Commit writes the provided files (or a `version` file containing the message if no files
+// are provided) and commits all changes, returning the commit hash
+func (r *Repo) Commit(message string, files map[string]string) string {
+	r.t.Helper()
+
+	if files == nil {
+		files = map[string]string{"version": message}
+	}
+	for name, data := range files {
+		p := filepath.Join(r.work, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			r.t.Fatalf("Error creating fixture directory '%s' (%s)", filepath.Dir(p), err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			r.t.Fatalf("Error writing fixture file '%s' (%s)", p, err)
+		}
+	}
+
+	Git(r.t, r.work, "add", "-A")
+	Git(r.t, r.work, "commit", "-q", "--allow-empty", "-m", message)
+	hash := Git(r.t, r.work, "rev-parse", "HEAD")
+	r.Hashes[message] = hash
+	r.publish()
+
+	return hash
+}
+
+// Tag tags the most recent commit
+func (r *Repo) Tag(name string, kind TagKind) {
+	r.t.Helper()
+
+	switch kind {
+	case Annotated:
+		Git(r.t, r.work, "tag", "-a", "-m", name, name)
+	case Lightweight:
+		Git(r.t, r.work, "tag", name)
+	case Signed:
+		r.signedTag(name)
+	default:
+		r.t.Fatalf("Unsupported tag kind %d", kind)
+	}
+
+	r.publish()
+}
+
+// Git runs a git command in the working copy, publishing any changes to the bare repository
+func (r *Repo) Git(args ...string) string {
+	r.t.Helper()
+
+	out := Git(r.t, r.work, args...)
+	r.publish()
+
+	return out
+}
+
+// SigningKey fetches the key used to sign tags, generating it on first use
+func (r *Repo) SigningKey() *openpgp.Entity {
+	r.t.Helper()
+
+	if r.key == nil {
+		key, err := openpgp.NewEntity(Name, "fixture", Email, &packet.Config{RSABits: 1024})
+		if err != nil {
+			r.t.Fatalf("Error generating signing key (%s)", err)
+		}
+		r.key = key
+	}
+
+	return r.key
+}
+
+// signedTag creates a signed annotated tag for the most recent commit
+func (r *Repo) signedTag(name string) {
+	r.t.Helper()
+
+	repo, err := git.PlainOpen(r.work)
+	if err != nil {
+		r.t.Fatalf("Error opening fixture repository (%s)", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		r.t.Fatalf("Error reading fixture HEAD (%s)", err)
+	}
+
+	_, err = repo.CreateTag(name, head.Hash(), &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: Name, Email: Email, When: time.Now()},
+		Message: name,
+		SignKey: r.SigningKey(),
+	})
+	if err != nil {
+		r.t.Fatalf("Error creating signed tag '%s' (%s)", name, err)
+	}
+}
+
+// publish pushes all branches and tags from the working copy to the bare repository,
+// removing any that no longer exist
+func (r *Repo) publish() {
+	r.t.Helper()
+
+	// Empty repositories have nothing to publish
+	if _, err := run(r.work, "rev-parse", "--verify", "-q", "HEAD^{commit}"); err != nil {
+		return
+	}
+	Git(r.t, r.work, "push", "-q", "--force", "--prune", "origin",
+		"refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*")
+}
+
+// String describes the fixture repository
+func (r *Repo) String() string {
+	return fmt.Sprintf("fixture '%s'", r.Path)
+}
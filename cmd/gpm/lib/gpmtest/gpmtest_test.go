@@ -0,0 +1,116 @@
+package gpmtest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestFixtures(t *testing.T) {
+	RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0", "untagged")
+	module.Commit("v0.3.0-rc.1", nil)
+	module.Tag("v0.3.0-rc.1", Signed)
+
+	server := NewServer(t, testDir)
+	defer server.Close()
+
+	t.Run("Builds commit and tag histories", func(t *testing.T) {
+		r, err := git.PlainOpen(module.Path)
+		assert.Nil(t, err)
+
+		for _, name := range []string{"v0.1.0", "v0.2.0", "untagged", "v0.3.0-rc.1"} {
+			_, err := r.CommitObject(plumbing.NewHash(module.Hashes[name]))
+			assert.Nil(t, err, name)
+		}
+
+		// Check each tag kind was created
+		tags := map[string]*object.Tag{}
+		refs, err := r.Tags()
+		assert.Nil(t, err)
+		assert.Nil(t, refs.ForEach(func(ref *plumbing.Reference) error {
+			tag, _ := r.TagObject(ref.Hash())
+			tags[ref.Name().Short()] = tag
+			return nil
+		}))
+
+		assert.EqualValues(t, 3, len(tags))
+		assert.NotNil(t, tags["v0.1.0"])
+		assert.Nil(t, tags["v0.2.0"])
+		if assert.NotNil(t, tags["v0.3.0-rc.1"]) {
+			assert.NotEmpty(t, tags["v0.3.0-rc.1"].PGPSignature)
+		}
+	})
+
+	t.Run("Signs tags with the repository key", func(t *testing.T) {
+		r, err := git.PlainOpen(module.Path)
+		assert.Nil(t, err)
+		ref, err := r.Tag("v0.3.0-rc.1")
+		assert.Nil(t, err)
+		tag, err := r.TagObject(ref.Hash())
+		assert.Nil(t, err)
+
+		keyRing := bytes.Buffer{}
+		w, err := armor.Encode(&keyRing, openpgp.PublicKeyType, nil)
+		assert.Nil(t, err)
+		assert.Nil(t, module.SigningKey().Serialize(w))
+		assert.Nil(t, w.Close())
+
+		_, err = tag.Verify(keyRing.String())
+		assert.Nil(t, err)
+	})
+
+	t.Run("Serves repositories over file and HTTP", func(t *testing.T) {
+		for i, url := range []string{module.URL(), server.RepoURL(module)} {
+			r, err := git.PlainClone(filepath.Join(testDir, "clones", strconv.Itoa(i)), true, &git.CloneOptions{URL: url})
+			if !assert.Nil(t, err, url) {
+				continue
+			}
+
+			head, err := r.Head()
+			assert.Nil(t, err)
+			assert.EqualValues(t, module.Hashes["v0.3.0-rc.1"], head.Hash().String())
+		}
+	})
+
+	t.Run("Injects faults into HTTP responses", func(t *testing.T) {
+		url := server.RepoURL(module)
+		server.Inject(
+			Fault{Path: "git-upload-pack", Truncate: true},
+			Fault{Status: http.StatusTooManyRequests, RetryAfter: 5},
+		)
+
+		// Faults apply to the next matching request only
+		res, err := http.Get(url + "/info/refs?service=git-upload-pack")
+		if assert.Nil(t, err) {
+			res.Body.Close()
+			assert.EqualValues(t, http.StatusTooManyRequests, res.StatusCode)
+			assert.EqualValues(t, "5", res.Header.Get("Retry-After"))
+		}
+
+		_, err = git.PlainClone(filepath.Join(testDir, "clones", "truncated"), true, &git.CloneOptions{URL: url})
+		assert.NotNil(t, err)
+
+		_, err = git.PlainClone(filepath.Join(testDir, "clones", "recovered"), true, &git.CloneOptions{URL: url})
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,154 @@
+package gpmtest
+
+import (
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server serves fixture repositories over the git smart HTTP protocol using `git http-backend`
+type Server struct {
+	*httptest.Server
+
+	t       testing.TB
+	root    string
+	backend http.Handler
+
+	mu       sync.Mutex
+	faults   []Fault
+	requests int
+	closed   chan struct{}
+}
+
+// Fault is a failure injected into a server response, used to simulate unreliable remotes
+type Fault struct {
+	Path       string        // Only match requests with URL paths containing Path (ie. "git-upload-pack"), empty to match all requests
+	Status     int           // Respond with the status code in place of the repository response
+	RetryAfter int           // Retry-After header (in seconds) sent with Status responses
+	Delay      time.Duration // Delay prior to responding
+	Truncate   bool          // Abort the connection part way through the repository response
+}
+
+// NewServer starts a server for repositories under the provided root directory,
+// the server must be closed by the caller
+func NewServer(t testing.TB, root string) *Server {
+	t.Helper()
+
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not available")
+	}
+
+	h := cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Dir:  root,
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + root,
+			"GIT_HTTP_EXPORT_ALL=1",
+			"GIT_CONFIG_NOSYSTEM=1",
+		},
+	}
+
+	s := Server{t: t, root: root, backend: &h, closed: make(chan struct{})}
+	s.Server = httptest.NewServer(&s)
+
+	return &s
+}
+
+// RepoURL fetches the HTTP URL for a fixture repository under the server root
+func (s *Server) RepoURL(r *Repo) string {
+	s.t.Helper()
+
+	rel, err := filepath.Rel(s.root, r.Path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		s.t.Fatalf("Repository '%s' is not served from '%s'", r.Path, s.root)
+	}
+	return s.URL + "/" + filepath.ToSlash(rel)
+}
+
+// Close releases delayed requests and shuts down the server
+func (s *Server) Close() {
+	close(s.closed)
+	s.Server.Close()
+}
+
+// Inject queues faults for subsequent requests, each fault applying to the next matching request
+func (s *Server) Inject(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, faults...)
+}
+
+// Requests fetches the number of requests received by the server
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+}
+
+// ServeHTTP serves repository requests, injecting any queued fault matching the request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, ok := s.next(r)
+	if !ok {
+		s.backend.ServeHTTP(w, r)
+		return
+	}
+
+	if f.Delay > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		case <-time.After(f.Delay):
+		}
+	}
+
+	switch {
+	case f.Status != 0:
+		if f.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(f.RetryAfter))
+		}
+		http.Error(w, http.StatusText(f.Status), f.Status)
+	case f.Truncate:
+		res := httptest.NewRecorder()
+		s.backend.ServeHTTP(res, r)
+
+		for k, v := range res.Header() {
+			w.Header()[k] = v
+		}
+		w.Header().Del("Content-Length")
+		w.WriteHeader(res.Code)
+		w.Write(res.Body.Bytes()[:res.Body.Len()/2])
+		w.(http.Flusher).Flush()
+
+		panic(http.ErrAbortHandler)
+	default:
+		s.backend.ServeHTTP(w, r)
+	}
+}
+
+// next counts a request and removes the first queued fault matching the request
+func (s *Server) next(r *http.Request) (Fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	for i, f := range s.faults {
+		if strings.Contains(r.URL.Path, f.Path) {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			return f, true
+		}
+	}
+	return Fault{}, false
+}
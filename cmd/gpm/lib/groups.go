@@ -0,0 +1,81 @@
+package gpm
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultGroup is the dependency group including all ungrouped dependencies
+	DefaultGroup = "default"
+)
+
+// InGroup checks whether a dependency is included in any of the named groups,
+// where ungrouped dependencies are included in the default group
+func (d *Dependency) InGroup(groups ...string) bool {
+	for _, g := range groups {
+		if len(d.Groups) == 0 && g == DefaultGroup {
+			return true
+		}
+		for _, dg := range d.Groups {
+			if dg == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Groups lists the dependency groups used in the project, including the default group
+func (d *Dependencies) Groups() []string {
+	names := map[string]bool{DefaultGroup: true}
+	for _, v := range *d {
+		for _, g := range v.Groups {
+			names[g] = true
+		}
+	}
+
+	groups := make([]string, 0, len(names))
+	for g := range names {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	return groups
+}
+
+// validateGroups checks dependency group names are valid
+func validateGroups(groups []string) error {
+	for _, g := range groups {
+		if strings.TrimSpace(g) == "" || strings.ContainsAny(g, ", ") {
+			return errorf(ErrInvalidOptions, "Invalid dependency group name '%s'", g)
+		}
+	}
+	return nil
+}
+
+// validate checks the selected groups are used by the project dependencies, so that
+// misspelt groups do not silently include or exclude dependencies
+func (gro *GroupOptions) validate(deps Dependencies) error {
+	known := make(map[string]bool)
+	for _, g := range deps.Groups() {
+		known[g] = true
+	}
+
+	for _, g := range append(append([]string{}, gro.Groups...), gro.Without...) {
+		if !known[g] {
+			return errorf(ErrInvalidOptions, "Unknown dependency group '%s' (groups: %s)", g, strings.Join(deps.Groups(), ", "))
+		}
+	}
+
+	return nil
+}
+
+// selects checks whether a dependency is included by the group selection, where all
+// dependencies are included if no groups are specified
+func (gro *GroupOptions) selects(d *Dependency) bool {
+	if len(gro.Groups) > 0 && !d.InGroup(gro.Groups...) {
+		return false
+	}
+	return !d.InGroup(gro.Without...)
+}
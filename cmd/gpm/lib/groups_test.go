@@ -0,0 +1,138 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroups(t *testing.T) {
+	deps := Dependencies{
+		{Path: "lib/core"},
+		{Path: "lib/fixtures", Groups: []string{"test"}},
+		{Path: "tools/lint", Groups: []string{"dev", "test"}},
+	}
+
+	t.Run("Lists project groups", func(t *testing.T) {
+		assert.EqualValues(t, []string{"default", "dev", "test"}, deps.Groups())
+	})
+
+	t.Run("Selects dependencies by group", func(t *testing.T) {
+		tests := []struct {
+			groups, without []string
+			expected        []string
+		}{
+			{nil, nil, []string{"lib/core", "lib/fixtures", "tools/lint"}},
+			{nil, []string{"dev"}, []string{"lib/core", "lib/fixtures"}},
+			{nil, []string{"dev", "test"}, []string{"lib/core"}},
+			{[]string{"test"}, nil, []string{"lib/fixtures", "tools/lint"}},
+			{[]string{"default", "test"}, []string{"dev"}, []string{"lib/core", "lib/fixtures"}},
+			{[]string{"default"}, nil, []string{"lib/core"}},
+		}
+
+		for _, test := range tests {
+			gro := GroupOptions{Groups: test.groups, Without: test.without}
+			assert.Nil(t, gro.validate(deps))
+
+			selected := make([]string, 0)
+			for _, d := range deps {
+				if gro.selects(&d) {
+					selected = append(selected, d.Path)
+				}
+			}
+			assert.EqualValues(t, test.expected, selected, "%+v", test)
+		}
+	})
+
+	t.Run("Rejects unknown groups", func(t *testing.T) {
+		gro := GroupOptions{Without: []string{"dve"}}
+		assert.EqualValues(t, ErrInvalidOptions, Code(gro.validate(deps)))
+
+		assert.EqualValues(t, ErrInvalidOptions, Code(validateGroups([]string{""})))
+		assert.EqualValues(t, ErrInvalidOptions, Code(validateGroups([]string{"dev,test"})))
+		assert.Nil(t, validateGroups([]string{"dev", "test"}))
+	})
+
+	gpmtest.RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0")
+	bare, hashes := module.URL(), module.Hashes
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+	o := CommonOptions{BasePath: projectDir}
+	gpm := NewGPM(&o)
+	ctx := context.Background()
+
+	pc := ProjectConfig{Name: "project"}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+	assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+	t.Run("Adds modules to groups", func(t *testing.T) {
+		_, err := gpm.Add(ctx, &AddOptions{Path: "lib", URL: bare, Version: "v0.1.0"})
+		assert.Nil(t, err)
+		_, err = gpm.Add(ctx, &AddOptions{Path: "fixtures", URL: bare, Version: "v0.1.0", Groups: []string{"test"}})
+		assert.Nil(t, err)
+
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		d, ok := pc.Dependencies.Find("fixtures")
+		if assert.True(t, ok) {
+			assert.EqualValues(t, []string{"test"}, d.Groups)
+		}
+	})
+
+	t.Run("Syncs without excluded groups", func(t *testing.T) {
+		assert.Nil(t, os.RemoveAll(filepath.Join(projectDir, "lib")))
+		assert.Nil(t, os.RemoveAll(filepath.Join(projectDir, "fixtures")))
+
+		modules, err := gpm.Sync(ctx, &SyncOptions{GroupOptions: GroupOptions{Without: []string{"test"}}})
+		assert.Nil(t, err)
+		if assert.EqualValues(t, 1, len(modules)) {
+			assert.EqualValues(t, "lib", modules[0].Path)
+		}
+
+		_, err = os.Stat(filepath.Join(projectDir, "lib"))
+		assert.Nil(t, err)
+		_, err = os.Stat(filepath.Join(projectDir, "fixtures"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Updates selected groups preserving other locks", func(t *testing.T) {
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		for _, p := range []string{"lib", "fixtures"} {
+			d, _ := pc.Dependencies.Find(p)
+			d.Version = "^v0.1.0"
+			pc.Dependencies.Set(p, *d)
+		}
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+
+		modules, err := gpm.Update(ctx, &UpdateOptions{GroupOptions{Groups: []string{DefaultGroup}}})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1, len(modules))
+
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, hashes["v0.2.0"], locks["lib"].Hash)
+		assert.EqualValues(t, hashes["v0.1.0"], locks["fixtures"].Hash)
+	})
+
+	t.Run("Rejects unknown groups when syncing", func(t *testing.T) {
+		_, err := gpm.Sync(ctx, &SyncOptions{GroupOptions: GroupOptions{Groups: []string{"prod"}}})
+		assert.EqualValues(t, ErrInvalidOptions, Code(err))
+	})
+}
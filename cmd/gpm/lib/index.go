@@ -0,0 +1,378 @@
+package gpm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/yaml.v3"
+)
+
+// IndexEntry is a named package in a package index
+type IndexEntry struct {
+	Name        string   `yaml:"name" json:"name"`                        // Name is the short package name used with `gpm add`
+	URL         string   `yaml:"url" json:"url"`                          // URL is the canonical git repository URL
+	Description string   `yaml:",omitempty" json:"description,omitempty"` // Description is a one line package summary
+	License     string   `yaml:",omitempty" json:"license,omitempty"`     // License is the package license (https://spdx.org/licenses/)
+	Homepage    string   `yaml:",omitempty" json:"homepage,omitempty"`    // Homepage is the package homepage
+	Keywords    []string `yaml:",omitempty" json:"keywords,omitempty"`    // Keywords are matched by `gpm search`
+	Prefix      string   `yaml:",omitempty" json:"prefix,omitempty"`      // Prefix is the tag prefix for packages in monorepos
+	Index       string   `yaml:"-" json:"index"`                          // Index is the index the entry was loaded from
+}
+
+// String formats an index entry as a human readable summary line
+func (e IndexEntry) String() string {
+	if e.Description == "" {
+		return fmt.Sprintf("%s: %s", e.Name, e.URL)
+	}
+	return fmt.Sprintf("%s: %s (%s)", e.Name, e.URL, e.Description)
+}
+
+// packageName matches valid package names, which may be namespaced (ie. org/mylib)
+var packageName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*(/[A-Za-z0-9][A-Za-z0-9._-]*)*$`)
+
+// Search lists the packages in the configured indexes with names, descriptions or keywords
+// containing the query (case insensitive), or all packages where no query is provided
+func (gpm *GPM) Search(ctx context.Context, so *SearchOptions) ([]IndexEntry, error) {
+	pc, err := gpm.indexProject()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := gpm.loadIndexes(ctx, &pc)
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.ToLower(so.Args.Query)
+	matches := make([]IndexEntry, 0)
+	for _, e := range entries {
+		fields := append([]string{e.Name, e.Description}, e.Keywords...)
+		for _, f := range fields {
+			if strings.Contains(strings.ToLower(f), query) {
+				matches = append(matches, e)
+				break
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// resolvePackage resolves a package reference (ie. mylib@^1.2) through the configured
+// indexes, filling the add options with the canonical package URL
+func (gpm *GPM) resolvePackage(ctx context.Context, pc *ProjectConfig, ao *AddOptions) error {
+	name, version := ao.Args.Package, ""
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		name, version = name[:i], name[i+1:]
+	}
+	if !packageName.MatchString(name) {
+		return errorf(ErrInvalidOptions, "Invalid package name '%s'", name)
+	}
+	if ao.URL != "" {
+		return errorf(ErrInvalidOptions, "Module url cannot be provided with a package name")
+	}
+	if version != "" && ao.Version != "" && version != ao.Version {
+		return errorf(ErrInvalidOptions, "Conflicting versions '%s' and '%s' for package '%s'", version, ao.Version, name)
+	}
+
+	entries, err := gpm.loadIndexes(ctx, pc)
+	if err != nil {
+		return err
+	}
+	var entry *IndexEntry
+	for i := range entries {
+		if entries[i].Name == name {
+			entry = &entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		return errorf(ErrUnknownPackage, "Package '%s' not found in package indexes", name)
+	}
+
+	if gpm.options.Verbose {
+		gpm.logf("Add resolved package '%s' to '%s' from index '%s'", name, entry.URL, entry.Index)
+	}
+
+	ao.URL = entry.URL
+	if version != "" {
+		ao.Version = version
+	}
+	if ao.Path == "" {
+		ao.Path = name
+	}
+	if ao.Prefix == "" {
+		ao.Prefix = entry.Prefix
+	}
+
+	return nil
+}
+
+// indexProject loads the project config for index configuration, where a project exists
+func (gpm *GPM) indexProject() (ProjectConfig, error) {
+	name, err := gpm.options.projectFile()
+	if err != nil {
+		return ProjectConfig{}, err
+	}
+	p, err := gpm.options.fullPath(name)
+	if err != nil {
+		return ProjectConfig{}, err
+	}
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return ProjectConfig{}, nil
+	}
+	return gpm.loadProjectConfig()
+}
+
+// loadIndexes loads the entries from the project indexes followed by those provided
+// in options, where entries from earlier indexes take precedence
+func (gpm *GPM) loadIndexes(ctx context.Context, pc *ProjectConfig) ([]IndexEntry, error) {
+	sources := make([]string, 0, len(pc.Indexes)+len(gpm.options.Indexes))
+	for _, s := range pc.Indexes {
+		// Local project indexes are relative to the project directory
+		if !remoteIndex(s) && !filepath.IsAbs(s) {
+			s = filepath.Join(gpm.options.BasePath, s)
+		}
+		sources = append(sources, s)
+	}
+	sources = append(sources, gpm.options.Indexes...)
+	if len(sources) == 0 {
+		return nil, errorf(ErrInvalidOptions, "No package indexes configured, add `indexes` to the project file or use --index")
+	}
+
+	entries := make([]IndexEntry, 0)
+	seen := make(map[string]bool)
+	for _, s := range sources {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+
+		files, err := gpm.indexFiles(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		index, err := parseIndex(s, files)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range index {
+			if seen[e.Name] {
+				if gpm.options.Verbose {
+					gpm.logf("Index (%s) skipping package '%s' (provided by an earlier index)", s, e.Name)
+				}
+				continue
+			}
+			seen[e.Name] = true
+			entries = append(entries, e)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	return entries, nil
+}
+
+// remoteIndex checks whether an index source is a git URL rather than a local directory
+func remoteIndex(source string) bool {
+	return strings.Contains(source, "://") || scpURL.MatchString(source)
+}
+
+// scpURL matches scp style git URLs (ie. git@github.com:org/index.git)
+var scpURL = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9._-]+:`)
+
+// indexFiles reads the entry files of an index, fetching remote indexes into the index cache
+func (gpm *GPM) indexFiles(ctx context.Context, source string) (map[string][]byte, error) {
+	if !remoteIndex(source) {
+		files, err := readDataDir(source, isIndexFile)
+		return files, wrapError(ErrFilesystem, err, "Error reading package index '%s'", source)
+	}
+
+	cache, err := gpm.indexCache(source)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := NewRepo(cache, source).WithRetry(RetryPolicy{
+		Retries: gpm.options.Retries,
+		Backoff: gpm.options.RetryBackoff,
+		Timeout: gpm.options.Timeout,
+		OnRetry: func(retry int, delay time.Duration, err error) {
+			gpm.logf("Warning: error fetching package index '%s' (%s), retrying in %s (%d/%d)\n", source, err, delay, retry, gpm.options.Retries)
+		},
+	})
+
+	if !repo.Exists() {
+		if gpm.options.Verbose {
+			gpm.logf("Index (%s) cloning into '%s'", source, cache)
+		}
+		if err := repo.Clone(ctx); err != nil {
+			return nil, wrapError(ErrRemote, contextError(ctx, err), "Error cloning package index '%s'", source)
+		}
+	} else {
+		if err := repo.Open(); err != nil {
+			return nil, wrapError(ErrRepository, err, "Error opening package index '%s'", cache)
+		}
+		// Fall back to the cached index where the remote is unavailable
+		if err := repo.Fetch(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, wrapError(ErrRemote, contextError(ctx, err), "Error fetching package index '%s'", source)
+			}
+			gpm.logf("Warning: error fetching package index '%s' (%s), using cached index\n", source, err)
+		}
+	}
+
+	files, err := readIndexTree(repo.repository)
+	return files, wrapError(ErrRepository, err, "Error reading package index '%s'", source)
+}
+
+// indexCache fetches the cache directory for a remote index
+func (gpm *GPM) indexCache(source string) (string, error) {
+	dir := gpm.options.IndexCache
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return "", wrapError(ErrFilesystem, err, "Error locating package index cache, use --index-cache")
+		}
+		dir = filepath.Join(userCache, "gpm", "indexes")
+	}
+
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])), nil
+}
+
+// isIndexFile checks whether a file within an index holds index entries
+func isIndexFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// hiddenPath checks whether any component of a slash separated path is hidden
+func hiddenPath(p string) bool {
+	for _, c := range strings.Split(p, "/") {
+		if strings.HasPrefix(c, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// readDataDir reads the matching files in a local index or database directory, skipping hidden files
+func readDataDir(dir string, match func(name string) bool) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !match(p) {
+			return nil
+		}
+
+		d, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = d
+		return nil
+	})
+	return files, err
+}
+
+// readIndexTree reads the index entry files from the default branch of a cloned index,
+// using the fetched remote branch so the cached worktree never needs updating
+func readIndexTree(r *git.Repository) (map[string][]byte, error) {
+	head, err := r.Head()
+	if err != nil {
+		return nil, err
+	}
+	hash := head.Hash()
+	if head.Name().IsBranch() {
+		if remote, err := r.Reference(plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true); err == nil {
+			hash = remote.Hash()
+		}
+	}
+
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if !isIndexFile(f.Name) || hiddenPath(f.Name) {
+			return nil
+		}
+		contents, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		files[f.Name] = []byte(contents)
+		return nil
+	})
+	return files, err
+}
+
+// parseIndex decodes the entries from index files, where each file contains a single
+// entry or a list of entries
+func parseIndex(source string, files map[string][]byte) ([]IndexEntry, error) {
+	names := make([]string, 0, len(files))
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	entries := make([]IndexEntry, 0)
+	for _, n := range names {
+		doc := yaml.Node{}
+		if err := yaml.Unmarshal(files[n], &doc); err != nil {
+			return nil, wrapError(ErrFilesystem, err, "Error decoding package index file '%s' in '%s'", n, source)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+
+		decoded := make([]IndexEntry, 0)
+		var err error
+		if doc.Content[0].Kind == yaml.SequenceNode {
+			err = doc.Content[0].Decode(&decoded)
+		} else {
+			decoded = append(decoded, IndexEntry{})
+			err = doc.Content[0].Decode(&decoded[0])
+		}
+		if err != nil {
+			return nil, wrapError(ErrFilesystem, err, "Error decoding package index file '%s' in '%s'", n, source)
+		}
+
+		for _, e := range decoded {
+			if !packageName.MatchString(e.Name) || e.URL == "" {
+				return nil, errorf(ErrFilesystem, "Invalid package '%s' in package index file '%s' in '%s' (packages require a valid name and url)", e.Name, n, source)
+			}
+			e.Index = source
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
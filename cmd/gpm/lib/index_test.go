@@ -0,0 +1,140 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0")
+
+	// Remote index with a single entry per file, and a hidden file that is not an entry
+	remote := gpmtest.NewRepo(t, filepath.Join(testDir, "index"))
+	remote.Commit("Add mylib", map[string]string{
+		"m/mylib.yml":          "name: mylib\nurl: " + module.URL() + "\ndescription: My library\nkeywords: [parser]\n",
+		".github/workflow.yml": "on: push\n",
+		"README.md":            "Package index\n",
+	})
+
+	// Local index with a list of entries, overriding the remote index
+	localDir := filepath.Join(testDir, "local")
+	assert.Nil(t, os.MkdirAll(localDir, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(localDir, "packages.yml"), []byte(
+		"- name: org/tools\n  url: https://example.com/tools\n  prefix: tools-\n"+
+			"- name: mylib\n  url: https://example.com/fork\n"), 0644))
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+	o := CommonOptions{BasePath: projectDir, Verbose: true, IndexCache: filepath.Join(testDir, "cache"), Indexes: []string{remote.URL()}}
+	gpm := NewGPM(&o)
+	ctx := context.Background()
+
+	search := func(t *testing.T, query string) []string {
+		so := SearchOptions{}
+		so.Args.Query = query
+		entries, err := gpm.Search(ctx, &so)
+		assert.Nil(t, err)
+		names := make([]string, 0)
+		for _, e := range entries {
+			names = append(names, e.Name+" "+e.URL)
+		}
+		return names
+	}
+
+	t.Run("Searches indexes without a project", func(t *testing.T) {
+		assert.EqualValues(t, []string{"mylib " + module.URL()}, search(t, ""))
+		assert.EqualValues(t, []string{"mylib " + module.URL()}, search(t, "PARSER"))
+		assert.EqualValues(t, []string{}, search(t, "missing"))
+	})
+
+	t.Run("Fetches index updates", func(t *testing.T) {
+		remote.Commit("Add other", map[string]string{"o/other.yml": "name: other\nurl: https://example.com/other\n"})
+		assert.EqualValues(t, []string{"mylib " + module.URL(), "other https://example.com/other"}, search(t, ""))
+	})
+
+	t.Run("Uses cached indexes when the remote is unavailable", func(t *testing.T) {
+		assert.Nil(t, os.Rename(remote.Path, remote.Path+".moved"))
+		defer os.Rename(remote.Path+".moved", remote.Path)
+
+		o.Retries = 0
+		assert.EqualValues(t, []string{"mylib " + module.URL(), "other https://example.com/other"}, search(t, ""))
+	})
+
+	t.Run("Prefers project indexes", func(t *testing.T) {
+		pc := ProjectConfig{Name: "project", Indexes: []string{"../local"}}
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+		assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+		assert.EqualValues(t, []string{"mylib https://example.com/fork", "org/tools https://example.com/tools", "other https://example.com/other"}, search(t, ""))
+
+		pc.Indexes = nil
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+	})
+
+	t.Run("Adds packages by name", func(t *testing.T) {
+		ao := AddOptions{}
+		ao.Args.Package = "mylib@v0.1.0"
+		m, err := gpm.Add(ctx, &ao)
+		assert.Nil(t, err)
+		if assert.NotNil(t, m) {
+			assert.EqualValues(t, module.Hashes["v0.1.0"], m.Hash)
+		}
+
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		d, ok := pc.Dependencies.Find("mylib")
+		assert.True(t, ok)
+		assert.EqualValues(t, &Dependency{Path: "mylib", URL: module.URL(), Version: "v0.1.0"}, d)
+		assert.DirExists(t, filepath.Join(projectDir, "mylib"))
+	})
+
+	t.Run("Rejects unknown and invalid packages", func(t *testing.T) {
+		tests := []struct {
+			ao   AddOptions
+			code ErrorCode
+		}{
+			{AddOptions{}, ErrUnknownPackage},
+			{AddOptions{URL: "https://example.com/mylib"}, ErrInvalidOptions},
+			{AddOptions{Version: "v0.2.0"}, ErrInvalidOptions},
+		}
+		packages := []string{"missing", "mylib", "mylib@v0.1.0"}
+
+		for i, test := range tests {
+			test.ao.Path = "lib/pkg"
+			test.ao.Args.Package = packages[i]
+			_, err := gpm.Add(ctx, &test.ao)
+			assert.EqualValues(t, test.code, Code(err), "%+v", test)
+		}
+
+		ao := AddOptions{}
+		ao.Args.Package = "../escape"
+		_, err := gpm.Add(ctx, &ao)
+		assert.EqualValues(t, ErrInvalidOptions, Code(err))
+
+		_, err = NewGPM(&CommonOptions{BasePath: projectDir}).Search(ctx, &SearchOptions{})
+		assert.EqualValues(t, ErrInvalidOptions, Code(err))
+	})
+
+	t.Run("Rejects invalid index entries", func(t *testing.T) {
+		_, err := parseIndex("index", map[string][]byte{"bad.yml": []byte("name: bad\n")})
+		assert.EqualValues(t, ErrFilesystem, Code(err))
+
+		_, err = parseIndex("index", map[string][]byte{"bad.yml": []byte("name: [bad\n")})
+		assert.EqualValues(t, ErrFilesystem, Code(err))
+	})
+}
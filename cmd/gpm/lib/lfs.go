@@ -0,0 +1,264 @@
+package gpm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const (
+	// lfsPointerVersion is the first line of a git LFS pointer file
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	// lfsPointerMaxSize is the maximum size of a git LFS pointer file
+	lfsPointerMaxSize = 1024
+	// lfsMediaType is the media type used by the git LFS batch API
+	lfsMediaType = "application/vnd.git-lfs+json"
+)
+
+// lfsClient is the HTTP client used to fetch LFS objects
+var lfsClient = &http.Client{}
+
+// lfsPointer is a git LFS pointer stored in place of a large file
+type lfsPointer struct {
+	Path string
+	OID  string
+	Size int64
+}
+
+// parseLFSPointer parses a git LFS pointer file, returning false if the data is not a pointer
+func parseLFSPointer(path string, data []byte) (lfsPointer, bool) {
+	p := lfsPointer{Path: path}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 3 || lines[0] != lfsPointerVersion {
+		return p, false
+	}
+
+	for _, l := range lines[1:] {
+		switch {
+		case strings.HasPrefix(l, "oid sha256:"):
+			p.OID = strings.TrimPrefix(l, "oid sha256:")
+		case strings.HasPrefix(l, "size "):
+			size, err := strconv.ParseInt(strings.TrimPrefix(l, "size "), 10, 64)
+			if err != nil {
+				return p, false
+			}
+			p.Size = size
+		}
+	}
+
+	if len(p.OID) != sha256.Size*2 {
+		return p, false
+	}
+
+	return p, true
+}
+
+// lfsEndpoint derives the default LFS endpoint for a repository URL
+func lfsEndpoint(url string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git") + ".git/info/lfs"
+}
+
+type lfsBatchRequest struct {
+	Operation string         `json:"operation"`
+	Transfers []string       `json:"transfers"`
+	Objects   []lfsBatchItem `json:"objects"`
+}
+
+type lfsBatchItem struct {
+	OID     string                    `json:"oid"`
+	Size    int64                     `json:"size"`
+	Actions map[string]lfsBatchAction `json:"actions,omitempty"`
+	Error   *lfsBatchError            `json:"error,omitempty"`
+}
+
+type lfsBatchAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type lfsBatchError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lfsBatchResponse struct {
+	Objects []lfsBatchItem `json:"objects"`
+}
+
+// lfsPointers finds the LFS pointers in the tree of the current HEAD commit
+func (repo *Repo) lfsPointers() ([]lfsPointer, error) {
+	head, err := repo.repository.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.repository.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	files, err := commit.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	pointers := make([]lfsPointer, 0)
+	err = files.ForEach(func(f *object.File) error {
+		if f.Size > lfsPointerMaxSize {
+			return nil
+		}
+		contents, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		if p, ok := parseLFSPointer(f.Name, []byte(contents)); ok {
+			pointers = append(pointers, p)
+		}
+		return nil
+	})
+
+	return pointers, err
+}
+
+// lfsObjectPath builds the local cache path for an LFS object
+func (repo *Repo) lfsObjectPath(oid string) string {
+	return filepath.Join(gitDir(repo.path), "lfs", "objects", oid[0:2], oid[2:4], oid)
+}
+
+// fetchLFS downloads the LFS objects for the current HEAD commit from the configured
+// endpoint and replaces the pointer files in the worktree with the object contents
+func (repo *Repo) fetchLFS(ctx context.Context) error {
+	pointers, err := repo.lfsPointers()
+	if err != nil {
+		return err
+	}
+
+	// Request objects not already cached
+	missing := make(map[string]lfsPointer)
+	for _, p := range pointers {
+		if _, err := os.Stat(repo.lfsObjectPath(p.OID)); os.IsNotExist(err) {
+			missing[p.OID] = p
+		}
+	}
+	if len(missing) > 0 {
+		if err := repo.downloadLFS(ctx, missing); err != nil {
+			return err
+		}
+	}
+
+	// Replace pointers with object contents
+	for _, p := range pointers {
+		d, err := ioutil.ReadFile(repo.lfsObjectPath(p.OID))
+		if err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(repo.path, p.Path), d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// downloadLFS fetches LFS objects into the local cache using the LFS batch API
+func (repo *Repo) downloadLFS(ctx context.Context, objects map[string]lfsPointer) error {
+	req := lfsBatchRequest{Operation: "download", Transfers: []string{"basic"}}
+	for _, p := range objects {
+		req.Objects = append(req.Objects, lfsBatchItem{OID: p.OID, Size: p.Size})
+	}
+
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, repo.lfs+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Accept", lfsMediaType)
+	httpReq.Header.Set("Content-Type", lfsMediaType)
+
+	resp, err := lfsClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("LFS batch request to '%s' failed (%s)", repo.lfs, resp.Status)
+	}
+
+	batch := lfsBatchResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return err
+	}
+
+	for _, o := range batch.Objects {
+		if o.Error != nil {
+			return fmt.Errorf("LFS object '%s' unavailable (%d: %s)", o.OID, o.Error.Code, o.Error.Message)
+		}
+		if _, ok := objects[o.OID]; !ok {
+			continue
+		}
+		action, ok := o.Actions["download"]
+		if !ok {
+			return fmt.Errorf("LFS object '%s' has no download action", o.OID)
+		}
+		if err := repo.downloadLFSObject(ctx, o.OID, action); err != nil {
+			return err
+		}
+		delete(objects, o.OID)
+	}
+
+	for oid := range objects {
+		return fmt.Errorf("LFS object '%s' missing from batch response", oid)
+	}
+
+	return nil
+}
+
+// downloadLFSObject downloads and verifies a single LFS object into the local cache
+func (repo *Repo) downloadLFSObject(ctx context.Context, oid string, action lfsBatchAction) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, action.Href, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range action.Header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := lfsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("LFS download of '%s' failed (%s)", oid, resp.Status)
+	}
+
+	h := sha256.New()
+	d, err := ioutil.ReadAll(io.TeeReader(resp.Body, h))
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != oid {
+		return fmt.Errorf("LFS object '%s' failed verification", oid)
+	}
+
+	objectPath := repo.lfsObjectPath(oid)
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return err
+	}
+
+	return writeFile(objectPath, d)
+}
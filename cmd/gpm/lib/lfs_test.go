@@ -0,0 +1,182 @@
+package gpm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// lfsServer is a minimal git LFS batch API server for testing
+func lfsServer(t *testing.T, objects map[string][]byte, downloads *int32) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/objects/batch":
+			req := lfsBatchRequest{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.EqualValues(t, "download", req.Operation)
+
+			resp := lfsBatchResponse{}
+			for _, o := range req.Objects {
+				item := lfsBatchItem{OID: o.OID, Size: o.Size}
+				if _, ok := objects[o.OID]; ok {
+					item.Actions = map[string]lfsBatchAction{"download": {Href: server.URL + "/objects/" + o.OID}}
+				} else {
+					item.Error = &lfsBatchError{Code: 404, Message: "Object does not exist"}
+				}
+				resp.Objects = append(resp.Objects, item)
+			}
+			w.Header().Set("Content-Type", lfsMediaType)
+			json.NewEncoder(w).Encode(&resp)
+
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/objects/"):
+			d, ok := objects[strings.TrimPrefix(r.URL.Path, "/objects/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			atomic.AddInt32(downloads, 1)
+			w.Write(d)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+// lfsPointerFile builds the pointer file for LFS object data
+func lfsPointerFile(data []byte) (string, string) {
+	h := sha256.Sum256(data)
+	oid := hex.EncodeToString(h[:])
+	return oid, fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, oid, len(data))
+}
+
+func TestLFS(t *testing.T) {
+
+	t.Run("Parses LFS pointers", func(t *testing.T) {
+		oid, pointer := lfsPointerFile([]byte("test"))
+
+		p, ok := parseLFSPointer("test.bin", []byte(pointer))
+		assert.True(t, ok)
+		assert.EqualValues(t, lfsPointer{Path: "test.bin", OID: oid, Size: 4}, p)
+
+		_, ok = parseLFSPointer("test.txt", []byte("version 1\noid sha256:abcd\nsize 4\n"))
+		assert.False(t, ok)
+	})
+
+	t.Run("Derives LFS endpoints", func(t *testing.T) {
+		assert.EqualValues(t, "https://github.com/ryankurte/test.git/info/lfs", lfsEndpoint("https://github.com/ryankurte/test"))
+		assert.EqualValues(t, "https://github.com/ryankurte/test.git/info/lfs", lfsEndpoint("https://github.com/ryankurte/test.git"))
+	})
+
+	gpmtest.RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	// Create a module repository with an LFS tracked file
+	data := []byte(strings.Repeat("large binary data ", 128))
+	oid, pointer := lfsPointerFile(data)
+
+	moduleRepo := gpmtest.NewRepo(t, filepath.Join(testDir, "module"))
+	moduleRepo.Commit("Add data", map[string]string{
+		".gitattributes": "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"data.bin":       pointer,
+	})
+	moduleRepo.Tag("v0.1.0", gpmtest.Annotated)
+	module := moduleRepo.URL()
+
+	var downloads int32
+	server := lfsServer(t, map[string][]byte{oid: data}, &downloads)
+	defer server.Close()
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+	o := CommonOptions{BasePath: projectDir, Verbose: true}
+	gpm := GPM{options: &o}
+
+	pc := ProjectConfig{Name: "project"}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+	assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+	t.Run("Materialises LFS objects when adding modules", func(t *testing.T) {
+		m, err := gpm.Add(context.Background(), &AddOptions{Path: "lib", URL: module, LFS: true, LFSURL: server.URL})
+		assert.Nil(t, err)
+		assert.EqualValues(t, "v0.1.0", m.Tag)
+
+		d, err := ioutil.ReadFile(filepath.Join(projectDir, "lib", "data.bin"))
+		assert.Nil(t, err)
+		assert.EqualValues(t, data, d)
+		assert.EqualValues(t, 1, atomic.LoadInt32(&downloads))
+
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		expected := sha256.Sum256([]byte(fmt.Sprintf("lfs data.bin %s\n", oid)))
+		assert.EqualValues(t, fmt.Sprintf("sha256:%x", expected), locks["lib"].Digest)
+	})
+
+	t.Run("Syncs LFS objects from the local cache", func(t *testing.T) {
+		assert.Nil(t, os.RemoveAll(filepath.Join(projectDir, "lib", "data.bin")))
+
+		_, err := gpm.Sync(context.Background(), &SyncOptions{})
+		assert.Nil(t, err)
+
+		d, err := ioutil.ReadFile(filepath.Join(projectDir, "lib", "data.bin"))
+		assert.Nil(t, err)
+		assert.EqualValues(t, data, d)
+		assert.EqualValues(t, 1, atomic.LoadInt32(&downloads))
+	})
+
+	t.Run("Refuses to discard local changes", func(t *testing.T) {
+		p := filepath.Join(projectDir, "lib", "data.bin")
+		assert.Nil(t, ioutil.WriteFile(p, []byte("local changes"), 0644))
+
+		_, err := gpm.Sync(context.Background(), &SyncOptions{})
+		assert.EqualValues(t, ErrDirtyModule, Code(err))
+
+		d, err := ioutil.ReadFile(p)
+		assert.Nil(t, err)
+		assert.EqualValues(t, "local changes", string(d))
+
+		assert.Nil(t, ioutil.WriteFile(p, data, 0644))
+	})
+
+	t.Run("Rejects content not matching the locked digest", func(t *testing.T) {
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		lock := locks["lib"]
+		lock.Digest = "sha256:" + strings.Repeat("0", 64)
+		locks["lib"] = lock
+		assert.Nil(t, gpm.writeLockfile(&locks))
+
+		_, err = gpm.Sync(context.Background(), &SyncOptions{})
+		assert.EqualValues(t, ErrDigestMismatch, Code(err))
+	})
+
+	t.Run("Fails when LFS objects are unavailable", func(t *testing.T) {
+		empty := lfsServer(t, map[string][]byte{}, &downloads)
+		defer empty.Close()
+
+		_, err := gpm.Add(context.Background(), &AddOptions{Path: "missing", URL: module, LFS: true, LFSURL: empty.URL})
+		assert.EqualValues(t, ErrRepository, Code(err))
+	})
+}
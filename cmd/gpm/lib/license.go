@@ -0,0 +1,200 @@
+package gpm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// LicenseUnknown is the SPDX value used where a license could not be determined
+	LicenseUnknown = "NOASSERTION"
+)
+
+// licenseFiles are the (lower case) file names searched for module licenses, in order of preference
+var licenseFiles = []string{
+	"license", "license.md", "license.txt",
+	"licence", "licence.md", "licence.txt",
+	"copying", "copying.md", "copying.txt",
+}
+
+// licenseTitle matches the title of a license, which must be one of the leading lines of
+// the license text, followed by the license version on the same or the next line
+type licenseTitle struct {
+	ID      string
+	Title   string
+	Version string
+}
+
+// licenseTitles are matched against the license header, so references to other licenses
+// within the license body (ie. the AGPL in the GPL-3.0 and MPL-2.0) are not matched
+var licenseTitles = []licenseTitle{
+	{"AGPL-3.0-only", "gnu affero general public license", "version 3"},
+	{"LGPL-3.0-only", "gnu lesser general public license", "version 3"},
+	{"LGPL-2.1-only", "gnu lesser general public license", "version 2.1"},
+	{"GPL-3.0-only", "gnu general public license", "version 3"},
+	{"GPL-2.0-only", "gnu general public license", "version 2"},
+	{"Apache-2.0", "apache license", "version 2.0"},
+	{"MPL-2.0", "mozilla public license", "version 2.0"},
+	{"BSL-1.0", "boost software license", "version 1.0"},
+}
+
+// licensePattern matches the opening text of untitled licenses to an SPDX license identifier
+type licensePattern struct {
+	ID       string
+	Patterns []string // All patterns must be contained in the (normalised) license header
+}
+
+// licensePatterns are checked in order, so more specific licenses must come first
+var licensePatterns = []licensePattern{
+	{"Unlicense", []string{"free and unencumbered software released into the public domain"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"Zlib", []string{"this software is provided 'as-is'", "altered source versions must be plainly marked"}},
+}
+
+const (
+	// licenseTitleLines is the number of leading lines searched for a license title
+	licenseTitleLines = 4
+	// licenseHeaderLines is the number of leading lines searched for untitled license patterns
+	licenseHeaderLines = 20
+)
+
+var (
+	spdxIdentifier = regexp.MustCompile(`SPDX-License-Identifier:\s*([^\s*/]+(?:\s+(?:AND|OR|WITH)\s+[^\s*/]+)*)`)
+	whitespace     = regexp.MustCompile(`\s+`)
+	rule           = regexp.MustCompile(`^[-=*_#\s]*$`)
+)
+
+// DetectLicense detects the SPDX license expression for the module at the provided path,
+// using the license from a module project file where available and falling back to
+// license file heuristics. Returns LicenseUnknown if no license could be determined.
+func DetectLicense(path string) string {
+	// Use the module project file license if available
+	for _, name := range ProjectConfigNames {
+		d, err := ioutil.ReadFile(filepath.Join(path, name))
+		if err != nil {
+			continue
+		}
+		pc := ProjectConfig{}
+		if node, diags := parseProject(name, d); len(diags) == 0 && node.Decode(&pc) == nil && pc.License != "" {
+			return pc.License
+		}
+	}
+
+	// Otherwise match against license files
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return LicenseUnknown
+	}
+	for _, name := range licenseFiles {
+		for _, f := range files {
+			if f.IsDir() || strings.ToLower(f.Name()) != name {
+				continue
+			}
+			d, err := ioutil.ReadFile(filepath.Join(path, f.Name()))
+			if err != nil {
+				continue
+			}
+			if id := MatchLicense(string(d)); id != "" {
+				return id
+			}
+		}
+	}
+
+	return LicenseUnknown
+}
+
+// MatchLicense matches license text to an SPDX license identifier, returning an
+// empty string if the license is not recognised
+func MatchLicense(text string) string {
+	// Explicit SPDX identifiers take precedence
+	if m := spdxIdentifier.FindStringSubmatch(text); m != nil {
+		return m[1]
+	}
+
+	// Normalise the leading lines, skipping blank lines and rules (ie. markdown underlines)
+	lines := make([]string, 0, licenseHeaderLines)
+	for _, l := range strings.Split(text, "\n") {
+		if len(lines) == licenseHeaderLines {
+			break
+		}
+		if rule.MatchString(l) {
+			continue
+		}
+		l = strings.TrimSpace(whitespace.ReplaceAllString(strings.ToLower(l), " "))
+		lines = append(lines, strings.Trim(l, "#* "))
+	}
+
+	titles := lines
+	if len(titles) > licenseTitleLines {
+		titles = titles[:licenseTitleLines]
+	}
+	for _, l := range licenseTitles {
+		if matchTitle(titles, l) {
+			return l.ID
+		}
+	}
+
+	header := strings.Join(lines, " ")
+	for _, l := range licensePatterns {
+		matched := true
+		for _, p := range l.Patterns {
+			if !strings.Contains(header, p) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return l.ID
+		}
+	}
+
+	return ""
+}
+
+// matchTitle checks whether a line starts with the license title, followed by the
+// license version on the same or the next line
+func matchTitle(lines []string, l licenseTitle) bool {
+	for i, line := range lines {
+		if !strings.HasPrefix(line, l.Title) {
+			continue
+		}
+		rest := strings.TrimLeft(line[len(l.Title):], " ,-")
+		if rest == "" && i+1 < len(lines) {
+			rest = lines[i+1]
+		}
+		if !strings.HasPrefix(rest, l.Version) {
+			continue
+		}
+		// Versions must match exactly (ie. version 2 does not match version 2.1)
+		rest = rest[len(l.Version):]
+		if rest == "" || !strings.ContainsAny(rest[:1], "0123456789") && !strings.HasPrefix(rest, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleLicenses detects the licenses for each of the provided modules
+func (gpm *GPM) moduleLicenses(modules []Module) (map[string]string, error) {
+	licenses := make(map[string]string)
+
+	for _, m := range modules {
+		modulePath, err := gpm.modulePath(m.Path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(modulePath); err != nil {
+			licenses[m.Path] = LicenseUnknown
+			continue
+		}
+		licenses[m.Path] = DetectLicense(modulePath)
+	}
+
+	return licenses, nil
+}
@@ -0,0 +1,81 @@
+package gpm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLicense(t *testing.T) {
+
+	t.Run("Matches license text", func(t *testing.T) {
+		tests := []struct {
+			Name string
+			Text string
+			ID   string
+		}{
+			{"MIT", "MIT License\n\nPermission is hereby granted, free of\ncharge, to any person", "MIT"},
+			{"Apache", "Apache License\n   Version 2.0, January 2004", "Apache-2.0"},
+			{"GPL", "GNU GENERAL PUBLIC LICENSE\nVersion 3, 29 June 2007", "GPL-3.0-only"},
+			{"LGPL", "GNU LESSER GENERAL PUBLIC LICENSE\nVersion 3, 29 June 2007\nGNU General Public License", "LGPL-3.0-only"},
+			{"BSD-3-Clause", "Redistribution and use in source and binary forms...\nNeither the name of", "BSD-3-Clause"},
+			{"BSD-2-Clause", "Redistribution and use in source and binary forms...", "BSD-2-Clause"},
+			{"SPDX identifiers", "// SPDX-License-Identifier: MIT OR Apache-2.0\n", "MIT OR Apache-2.0"},
+			{"Unknown", "All rights reserved", ""},
+		}
+
+		for _, test := range tests {
+			t.Run(test.Name, func(t *testing.T) {
+				assert.Equal(t, test.ID, MatchLicense(test.Text))
+			})
+		}
+	})
+
+	t.Run("Matches full license texts", func(t *testing.T) {
+		// Copyleft licenses reference each other in their bodies, so only titles may match
+		tests := map[string]string{
+			"AGPL-3.0":   "AGPL-3.0-only",
+			"Apache-2.0": "Apache-2.0",
+			"GPL-2.0":    "GPL-2.0-only",
+			"GPL-3.0":    "GPL-3.0-only",
+			"LGPL-2.1":   "LGPL-2.1-only",
+			"LGPL-3.0":   "LGPL-3.0-only",
+			"MPL-2.0":    "MPL-2.0",
+		}
+
+		for name, id := range tests {
+			d, err := ioutil.ReadFile(filepath.Join("testdata", "licenses", name))
+			assert.Nil(t, err)
+			assert.Equal(t, id, MatchLicense(string(d)), name)
+		}
+
+		// License references after the license title are not matched
+		assert.Equal(t, "MIT", MatchLicense("MIT License\n\nCopyright (c) 2020 Example\n\n"+
+			"Permission is hereby granted, free of charge, to any person obtaining a copy\n"+
+			"of this software, except for the parser, which is licensed under the\n"+
+			"GNU General Public License\nVersion 3\n"))
+	})
+
+	t.Run("Detects module licenses", func(t *testing.T) {
+		testDir, err := ioutil.TempDir("", "gpm-test")
+		if err != nil {
+			t.FailNow()
+		}
+		defer os.RemoveAll(testDir)
+
+		assert.Equal(t, LicenseUnknown, DetectLicense(testDir))
+
+		// License files
+		err = ioutil.WriteFile(filepath.Join(testDir, "LICENSE.md"), []byte("Permission is hereby granted, free of charge"), 0644)
+		assert.Nil(t, err)
+		assert.Equal(t, "MIT", DetectLicense(testDir))
+
+		// Project files take precedence
+		err = ioutil.WriteFile(filepath.Join(testDir, ProjectConfigName), []byte("license: Apache-2.0\n"), 0644)
+		assert.Nil(t, err)
+		assert.Equal(t, "Apache-2.0", DetectLicense(testDir))
+	})
+}
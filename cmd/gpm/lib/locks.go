@@ -1,11 +1,32 @@
 package gpm
 
-import ()
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// Locks is a list mapping modules to locked git commits
+type Locks map[string]Lock
+
+// Lock records the commit a module is locked to and the remote it was resolved from
+type Lock struct {
+	Hash string `json:"hash"`                            // Hash is the locked git commit hash
+	URL  string `yaml:",omitempty" json:"url,omitempty"` // URL is the remote the commit was resolved from
 
-// Locks is a list mapping modules to git commit hashes
-type Locks map[string]string
+	Digest string `yaml:",omitempty" json:"digest,omitempty"` // Digest covers submodule commits and LFS objects, where enabled
+}
 
 const (
 	// LockfileName is the default project lock file name
 	LockfileName = ".lock.yml"
 )
+
+// UnmarshalYAML decodes a lock, accepting plain commit hashes from earlier lockfile versions
+func (l *Lock) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = Lock{Hash: value.Value}
+		return nil
+	}
+
+	type lock Lock
+	return value.Decode((*lock)(l))
+}
@@ -0,0 +1,133 @@
+package gpm
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocks(t *testing.T) {
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	t.Run("Loads legacy lockfiles", func(t *testing.T) {
+		projectDir := filepath.Join(testDir, "legacy")
+		assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+		o := CommonOptions{BasePath: projectDir}
+		gpm := GPM{options: &o}
+
+		legacy := "lib/a: 5a83540f87665410bb6440b97f6c2f71a27471bd\nlib/b:\n  hash: 2075d8e0a26e6104b212428186bec59edad15e42\n  url: https://github.com/ryankurte/b\n"
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(projectDir, LockfileName), []byte(legacy), 0644))
+
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, Locks{
+			"lib/a": {Hash: "5a83540f87665410bb6440b97f6c2f71a27471bd"},
+			"lib/b": {Hash: "2075d8e0a26e6104b212428186bec59edad15e42", URL: "https://github.com/ryankurte/b"},
+		}, locks)
+	})
+
+	gpmtest.RequireGit(t)
+
+	// Create an original remote, a mirror and an unrelated remote
+	originalRepo := gpmtest.Versions(t, filepath.Join(testDir, "original"), "v0.1.0", "v0.2.0")
+	original, hashes := originalRepo.URL(), originalRepo.Hashes
+	mirror := filepath.Join(testDir, "mirror.git")
+	gpmtest.Git(t, testDir, "clone", "-q", "--bare", original, mirror)
+	unrelatedRepo := gpmtest.Versions(t, filepath.Join(testDir, "unrelated"), "v1.0.0")
+	unrelated := unrelatedRepo.URL()
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+	o := CommonOptions{BasePath: projectDir, Verbose: true}
+	gpm := GPM{options: &o}
+
+	pc := ProjectConfig{Name: "project", Dependencies: Dependencies{{Path: "lib", URL: original, Version: "0.1.0"}}}
+	locks := Locks{"lib": {Hash: hashes["v0.1.0"]}}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+	assert.Nil(t, gpm.writeLockfile(&locks))
+
+	// setURL updates the project dependency URL
+	setURL := func(url string) {
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		pc.Dependencies[0].URL = url
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+	}
+
+	// syncLogged syncs the project, capturing log outputs
+	syncLogged := func() ([]Module, string, error) {
+		buff := bytes.NewBuffer(nil)
+		log.SetOutput(buff)
+		defer log.SetOutput(os.Stderr)
+
+		modules, err := gpm.Sync(context.Background(), &SyncOptions{})
+		return modules, buff.String(), err
+	}
+
+	t.Run("Records remotes for legacy locks", func(t *testing.T) {
+		_, out, err := syncLogged()
+		assert.Nil(t, err)
+		assert.NotContains(t, out, "Warning")
+
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, Lock{Hash: hashes["v0.1.0"], URL: original}, locks["lib"])
+	})
+
+	t.Run("Updates remotes when dependency URLs change", func(t *testing.T) {
+		setURL(mirror)
+
+		modules, out, err := syncLogged()
+		assert.Nil(t, err)
+		assert.NotContains(t, out, "Warning")
+		if assert.EqualValues(t, 1, len(modules)) {
+			assert.EqualValues(t, hashes["v0.1.0"], modules[0].Hash)
+		}
+
+		repo := NewRepo(filepath.Join(projectDir, "lib"), mirror)
+		assert.Nil(t, repo.Open())
+		assert.EqualValues(t, mirror, repo.RemoteURL())
+
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, Lock{Hash: hashes["v0.1.0"], URL: mirror}, locks["lib"])
+	})
+
+	t.Run("Warns when the new remote does not contain the locked commit", func(t *testing.T) {
+		setURL(unrelated)
+
+		_, out, err := syncLogged()
+		assert.Nil(t, err)
+		assert.Contains(t, out, "Warning: locked commit")
+
+		repo := NewRepo(filepath.Join(projectDir, "lib"), unrelated)
+		assert.Nil(t, repo.Open())
+		assert.EqualValues(t, unrelated, repo.RemoteURL())
+
+		// Tags from the previous remote are removed
+		tags, err := repo.GetTags()
+		assert.Nil(t, err)
+		_, ok := tags["v0.1.0"]
+		assert.False(t, ok)
+		_, ok = tags["v1.0.0"]
+		assert.True(t, ok)
+
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, Lock{Hash: hashes["v0.1.0"], URL: unrelated}, locks["lib"])
+	})
+}
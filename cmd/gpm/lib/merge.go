@@ -0,0 +1,478 @@
+package gpm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// GitAttributesName is the project git attributes file
+	GitAttributesName = ".gitattributes"
+
+	// MergeDriverName is the name the lockfile merge driver is registered under in git config
+	MergeDriverName = "gpm-lock"
+	// MergeDriverCommand is the git merge driver command line for merging lockfiles
+	MergeDriverCommand = "gpm merge-lock %O %A %B"
+)
+
+// Lockfile merge resolutions
+const (
+	MergeOurs     = "ours"     // Changed on the current branch only
+	MergeTheirs   = "theirs"   // Changed on the other branch only
+	MergeBoth     = "both"     // Changed identically on both branches
+	MergeResolved = "resolved" // Changed on both branches, resolved against the project config
+	MergeConflict = "conflict" // Changed on both branches and left for manual resolution
+)
+
+// LockMerge is the result of merging the lockfile entry for a module changed on either branch
+type LockMerge struct {
+	Path       string `json:"path"`           // Path is the module path
+	Resolution string `json:"resolution"`     // Resolution describes how the entry was merged
+	Hash       string `json:"hash,omitempty"` // Hash is the merged commit hash, empty where the entry was removed or conflicted
+}
+
+// String formats a lockfile merge as a human readable summary line
+func (m LockMerge) String() string {
+	if m.Hash == "" && m.Resolution != MergeConflict {
+		return fmt.Sprintf("%s: removed (%s)", m.Path, m.Resolution)
+	}
+	if m.Hash == "" {
+		return fmt.Sprintf("%s: %s", m.Path, m.Resolution)
+	}
+	return fmt.Sprintf("%s: %s (%s)", m.Path, m.Resolution, shortHash(m.Hash))
+}
+
+// lockConflict is a lockfile entry changed differently on both branches
+type lockConflict struct {
+	path               string
+	ours, theirs       Lock
+	hasOurs, hasTheirs bool
+	resolved           bool
+}
+
+// MergeLock performs a three-way merge of lockfiles as a git merge driver, writing the
+// result over the current lockfile. Entries changed on only one branch are merged
+// automatically, while entries changed on both are re-resolved against the merged project
+// config using the module tags available locally. Unresolved conflicts are written with
+// conflict markers and return ErrLockfile.
+func (gpm *GPM) MergeLock(ctx context.Context, mo *MergeLockOptions) (merges []LockMerge, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
+	files := []string{mo.Args.Base, mo.Args.Current, mo.Args.Other}
+	sides := make([]Locks, len(files))
+	for i, f := range files {
+		if sides[i], err = readLocks(f); err != nil {
+			return nil, err
+		}
+	}
+
+	merged, merges, conflicts := mergeLocks(sides[0], sides[1], sides[2])
+
+	// Re-resolve entries changed on both branches against the merged project config
+	if len(conflicts) > 0 {
+		if configs, err := gpm.loadMergeConfigs(); err != nil {
+			gpm.logf("Warning: unable to resolve lockfile conflicts (%s)\n", err)
+		} else {
+			for i, c := range conflicts {
+				lock, ok, err := gpm.resolveLockConflict(configs, c)
+				if err != nil {
+					gpm.logf("Warning: unable to resolve lockfile conflict for module '%s' (%s)\n", c.path, err)
+				}
+				if err != nil || !ok {
+					continue
+				}
+				conflicts[i].resolved = true
+				if lock != nil {
+					merged[c.path] = *lock
+				}
+				merges = setMerge(merges, LockMerge{Path: c.path, Resolution: MergeResolved, Hash: lockHash(lock)})
+			}
+		}
+	}
+
+	// Write the merged lockfile, with markers for any remaining conflicts
+	d, err := encodeYaml(merged)
+	if err != nil {
+		return nil, wrapError(ErrLockfile, err, "Error encoding merged lockfile")
+	}
+	unresolved := make([]string, 0)
+	for _, c := range conflicts {
+		if c.resolved {
+			continue
+		}
+		unresolved = append(unresolved, c.path)
+		markers, err := conflictMarkers(c)
+		if err != nil {
+			return nil, wrapError(ErrLockfile, err, "Error encoding lockfile conflict")
+		}
+		d = append(d, markers...)
+	}
+	if err := writeFile(mo.Args.Current, d); err != nil {
+		return nil, wrapError(ErrFilesystem, err, "Error writing to file '%s'", mo.Args.Current)
+	}
+
+	if len(unresolved) > 0 {
+		return merges, errorf(ErrLockfile, "Unresolved lockfile conflicts for modules: %s", strings.Join(unresolved, ", "))
+	}
+
+	return merges, nil
+}
+
+// InstallMergeDriver registers the lockfile merge driver in the project repository config
+// and assigns it to the lockfile in the project .gitattributes file
+func (gpm *GPM) InstallMergeDriver(ctx context.Context, im *InstallMergeDriverOptions) (err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return err
+	}
+	defer gpm.end(&err)
+
+	r, err := git.PlainOpen(gpm.options.BasePath)
+	if err != nil {
+		return wrapError(ErrRepository, err, "Error opening project repository '%s'", gpm.options.BasePath)
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return wrapError(ErrRepository, err, "Error loading project repository config")
+	}
+
+	driver := cfg.Raw.Section("merge").Subsection(MergeDriverName)
+	driver.SetOption("name", "gpm lockfile merge driver")
+	driver.SetOption("driver", MergeDriverCommand)
+	if err := r.Storer.SetConfig(cfg); err != nil {
+		return wrapError(ErrRepository, err, "Error writing project repository config")
+	}
+
+	// Assign the driver to the lockfile, leaving any existing attributes in place
+	p, err := gpm.options.fullPath(GitAttributesName)
+	if err != nil {
+		return err
+	}
+	d, err := ioutil.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return wrapError(ErrFilesystem, err, "Error reading file '%s'", GitAttributesName)
+	}
+
+	attribute := fmt.Sprintf("%s merge=%s", LockfileName, MergeDriverName)
+	for _, l := range strings.Split(string(d), "\n") {
+		if strings.TrimSpace(l) == attribute {
+			return nil
+		}
+	}
+	if len(d) > 0 && !bytes.HasSuffix(d, []byte("\n")) {
+		d = append(d, '\n')
+	}
+	d = append(d, attribute+"\n"...)
+
+	if gpm.options.Verbose {
+		gpm.logf("Install merge driver adding '%s' to '%s'", attribute, GitAttributesName)
+	}
+
+	return wrapError(ErrFilesystem, writeFile(p, d), "Error writing to file '%s'", GitAttributesName)
+}
+
+// readLocks loads a lockfile by path, where empty files (ie. a missing merge base) are empty lockfiles
+func readLocks(path string) (Locks, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, wrapError(ErrLockfile, err, "Error reading lockfile '%s'", path)
+	}
+
+	locks := make(Locks)
+	if err := yaml.Unmarshal(d, &locks); err != nil {
+		return nil, wrapError(ErrLockfile, err, "Error decoding lockfile '%s'", path)
+	}
+	if locks == nil {
+		locks = make(Locks)
+	}
+
+	return locks, nil
+}
+
+// mergeLocks performs a three-way merge of lockfile entries, returning the merged locks,
+// the entries merged from either branch and the entries changed differently on both
+func mergeLocks(base, ours, theirs Locks) (Locks, []LockMerge, []lockConflict) {
+	merged := make(Locks)
+	merges := make([]LockMerge, 0)
+	conflicts := make([]lockConflict, 0)
+
+	paths := make(map[string]bool)
+	for _, locks := range []Locks{base, ours, theirs} {
+		for p := range locks {
+			paths[p] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	for _, p := range sorted {
+		b, hasBase := base[p]
+		o, hasOurs := ours[p]
+		t, hasTheirs := theirs[p]
+
+		changedOurs := hasOurs != hasBase || o != b
+		changedTheirs := hasTheirs != hasBase || t != b
+
+		switch {
+		case hasOurs == hasTheirs && o == t:
+			if hasOurs {
+				merged[p] = o
+			}
+			if changedOurs {
+				merges = append(merges, LockMerge{Path: p, Resolution: MergeBoth, Hash: o.Hash})
+			}
+		case !changedTheirs:
+			if hasOurs {
+				merged[p] = o
+			}
+			merges = append(merges, LockMerge{Path: p, Resolution: MergeOurs, Hash: o.Hash})
+		case !changedOurs:
+			if hasTheirs {
+				merged[p] = t
+			}
+			merges = append(merges, LockMerge{Path: p, Resolution: MergeTheirs, Hash: t.Hash})
+		default:
+			conflicts = append(conflicts, lockConflict{path: p, ours: o, theirs: t, hasOurs: hasOurs, hasTheirs: hasTheirs})
+			merges = append(merges, LockMerge{Path: p, Resolution: MergeConflict})
+		}
+	}
+
+	return merged, merges, conflicts
+}
+
+// mergeConfigs are the project configs on the current branch, the merged branch and
+// their merge base, where base and theirs are nil if the merged commit is not known
+type mergeConfigs struct {
+	base, ours, theirs *ProjectConfig
+}
+
+// loadMergeConfigs loads the project config from the working tree, and from the merged
+// commit and merge base where git identifies the commit being merged (`git merge` sets
+// GITHEAD_<hash> in the merge driver environment, while rebases and cherry-picks do not)
+func (gpm *GPM) loadMergeConfigs() (*mergeConfigs, error) {
+	ours, err := gpm.loadProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+	configs := mergeConfigs{ours: &ours}
+
+	heads := make([]plumbing.Hash, 0, 1)
+	for _, e := range os.Environ() {
+		k := strings.SplitN(e, "=", 2)[0]
+		if !strings.HasPrefix(k, "GITHEAD_") {
+			continue
+		}
+		hex := strings.TrimPrefix(k, "GITHEAD_")
+		if h := plumbing.NewHash(hex); h.String() == hex {
+			heads = append(heads, h)
+		}
+	}
+	if len(heads) != 1 {
+		return &configs, nil
+	}
+
+	name, err := gpm.options.projectFile()
+	if err != nil {
+		return nil, err
+	}
+	r, err := git.PlainOpen(gpm.options.BasePath)
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error opening project repository '%s'", gpm.options.BasePath)
+	}
+	head, err := r.Head()
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error reading project repository HEAD")
+	}
+	current, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error reading commit '%s'", head.Hash())
+	}
+	other, err := r.CommitObject(heads[0])
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error reading merged commit '%s'", heads[0])
+	}
+
+	if configs.theirs, err = commitProjectConfig(other, name); err != nil {
+		return nil, err
+	}
+	bases, err := current.MergeBase(other)
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error finding merge base of '%s' and '%s'", current.Hash, other.Hash)
+	}
+	configs.base = &ProjectConfig{}
+	if len(bases) == 1 {
+		if configs.base, err = commitProjectConfig(bases[0], name); err != nil {
+			return nil, err
+		}
+	}
+
+	return &configs, nil
+}
+
+// commitProjectConfig loads a project config file from a commit, where a missing file is an empty config
+func commitProjectConfig(c *object.Commit, name string) (*ProjectConfig, error) {
+	f, err := c.File(name)
+	if err == object.ErrFileNotFound {
+		return &ProjectConfig{}, nil
+	} else if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error reading '%s' from commit '%s'", name, c.Hash)
+	}
+	d, err := f.Contents()
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error reading '%s' from commit '%s'", name, c.Hash)
+	}
+	pc, err := decodeProject(name, []byte(d))
+	if err != nil {
+		return nil, wrapError(ErrProjectConfig, err, "Error loading '%s' from commit '%s'", name, c.Hash)
+	}
+	return &pc, nil
+}
+
+// dependency merges the dependency for a module path from the project configs on either
+// branch, returning nil where the dependency has been removed and false where both
+// branches changed the dependency differently
+func (mc *mergeConfigs) dependency(path string) (*Dependency, bool) {
+	find := func(pc *ProjectConfig) *Dependency {
+		d, _ := pc.Dependencies.Find(path)
+		return d
+	}
+
+	ours := find(mc.ours)
+	if mc.theirs == nil {
+		return ours, true
+	}
+	theirs, base := find(mc.theirs), find(mc.base)
+
+	switch {
+	case reflect.DeepEqual(ours, theirs), reflect.DeepEqual(theirs, base):
+		return ours, true
+	case reflect.DeepEqual(ours, base):
+		return theirs, true
+	}
+	return nil, false
+}
+
+// resolveLockConflict resolves a conflicting lockfile entry against the merged project
+// config, locking the latest tag matching the merged dependency version (or no lock where
+// the dependency has been removed). Only tags already in the module repository are used, as
+// merge drivers run mid-merge and must not fetch or change module worktrees, so returns
+// false where the module has not been cloned or no local tag matches.
+func (gpm *GPM) resolveLockConflict(configs *mergeConfigs, c lockConflict) (*Lock, bool, error) {
+	d, ok := configs.dependency(c.path)
+	if !ok {
+		if gpm.options.Verbose {
+			gpm.logf("Merge lock (%s) dependency changed on both branches", c.path)
+		}
+		return nil, false, nil
+	}
+	if d == nil {
+		return nil, true, nil
+	}
+
+	modulePath, err := gpm.modulePath(d.Path)
+	if err != nil {
+		return nil, false, err
+	}
+	repo := gpm.newRepo(d, modulePath)
+	if !repo.Exists() {
+		if gpm.options.Verbose {
+			gpm.logf("Merge lock (%s) module not found", d.Path)
+		}
+		return nil, false, nil
+	}
+	if err := repo.Open(); err != nil {
+		return nil, false, wrapError(ErrRepository, err, "Error opening module '%s'", d.Path)
+	}
+	if url := repo.RemoteURL(); url != d.URL {
+		if gpm.options.Verbose {
+			gpm.logf("Merge lock (%s) module remote '%s' does not match '%s'", d.Path, url, d.URL)
+		}
+		return nil, false, nil
+	}
+
+	tags, err := gpm.moduleTags(d, repo, false)
+	if err != nil {
+		return nil, false, err
+	}
+	latestTag, latestHash, err := tags.GetLatest(d.Version)
+	if err != nil {
+		return nil, false, wrapError(ErrInvalidVersion, err, "Invalid version range '%s' for module '%s'", d.Version, d.Path)
+	}
+	if latestHash == "" {
+		return nil, false, nil
+	}
+
+	if gpm.options.Verbose {
+		gpm.logf("Merge lock (%s) resolved to tag '%s' hash '%s'", d.Path, latestTag, latestHash)
+	}
+
+	// Keep the digest of a matching lock from either branch, otherwise sync records it
+	for _, l := range []Lock{c.ours, c.theirs} {
+		if l.Hash == latestHash && (l.URL == "" || l.URL == d.URL) {
+			return &Lock{Hash: latestHash, URL: d.URL, Digest: l.Digest}, true, nil
+		}
+	}
+	return &Lock{Hash: latestHash, URL: d.URL}, true, nil
+}
+
+// conflictMarkers formats a conflicting lockfile entry with git conflict markers
+func conflictMarkers(c lockConflict) ([]byte, error) {
+	side := func(l Lock, ok bool) ([]byte, error) {
+		if !ok {
+			return nil, nil
+		}
+		return encodeYaml(Locks{c.path: l})
+	}
+
+	ours, err := side(c.ours, c.hasOurs)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := side(c.theirs, c.hasTheirs)
+	if err != nil {
+		return nil, err
+	}
+
+	buff := bytes.NewBufferString("<<<<<<< ours\n")
+	buff.Write(ours)
+	buff.WriteString("=======\n")
+	buff.Write(theirs)
+	buff.WriteString(">>>>>>> theirs\n")
+
+	return buff.Bytes(), nil
+}
+
+// setMerge replaces the merge result for a module path
+func setMerge(merges []LockMerge, m LockMerge) []LockMerge {
+	for i := range merges {
+		if merges[i].Path == m.Path {
+			merges[i] = m
+			return merges
+		}
+	}
+	return append(merges, m)
+}
+
+// lockHash fetches the hash of an optional lock
+func lockHash(l *Lock) string {
+	if l == nil {
+		return ""
+	}
+	return l.Hash
+}
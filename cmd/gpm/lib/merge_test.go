@@ -0,0 +1,215 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeLocks(t *testing.T) {
+	a, b, c := Lock{Hash: "aaaa"}, Lock{Hash: "bbbb"}, Lock{Hash: "cccc"}
+
+	base := Locks{"unchanged": a, "ours": a, "theirs": a, "both": a, "removed": a, "conflict": a, "edited": a}
+	ours := Locks{"unchanged": a, "ours": b, "theirs": a, "both": b, "added": c, "conflict": b}
+	theirs := Locks{"unchanged": a, "ours": a, "theirs": b, "both": b, "removed": a, "added": c, "conflict": c}
+
+	merged, merges, conflicts := mergeLocks(base, ours, theirs)
+
+	assert.EqualValues(t, Locks{"unchanged": a, "ours": b, "theirs": b, "both": b, "added": c}, merged)
+	assert.EqualValues(t, []LockMerge{
+		{Path: "added", Resolution: MergeBoth, Hash: "cccc"},
+		{Path: "both", Resolution: MergeBoth, Hash: "bbbb"},
+		{Path: "conflict", Resolution: MergeConflict},
+		{Path: "edited", Resolution: MergeBoth},
+		{Path: "ours", Resolution: MergeOurs, Hash: "bbbb"},
+		{Path: "removed", Resolution: MergeOurs},
+		{Path: "theirs", Resolution: MergeTheirs, Hash: "bbbb"},
+	}, merges)
+	assert.EqualValues(t, []lockConflict{{path: "conflict", ours: b, theirs: c, hasOurs: true, hasTheirs: true}}, conflicts)
+
+	t.Run("Conflicts where an entry is changed on one branch and removed on the other", func(t *testing.T) {
+		_, _, conflicts := mergeLocks(Locks{"lib": a}, Locks{"lib": b}, Locks{})
+		assert.EqualValues(t, []lockConflict{{path: "lib", ours: b, hasOurs: true}}, conflicts)
+	})
+}
+
+func TestMergeLock(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0")
+	untagged := module.Commit("Untagged", map[string]string{"untagged": "untagged"})
+	url, hashes := module.URL(), module.Hashes
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+	o := CommonOptions{BasePath: projectDir, Verbose: true}
+	gpm := NewGPM(&o)
+	ctx := context.Background()
+
+	pc := ProjectConfig{Name: "project", Dependencies: Dependencies{
+		{Path: "lib/a", URL: url, Version: ">=0.1.0"},
+		{Path: "lib/b", URL: url, Version: "0.1.0"},
+		{Path: "lib/c", URL: url},
+		{Path: "lib/missing", URL: url},
+	}}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+
+	// Conflicts are resolved against the tags of existing module clones
+	for _, p := range []string{"lib/a", "lib/b", "lib/c"} {
+		gpmtest.Git(t, projectDir, "clone", "-q", url, p)
+	}
+
+	write := func(name string, locks Locks) string {
+		p := filepath.Join(testDir, name)
+		d, err := encodeYaml(locks)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile(p, d, 0644))
+		return p
+	}
+
+	v1, v2 := Lock{Hash: hashes["v0.1.0"], URL: url}, Lock{Hash: hashes["v0.2.0"], URL: url}
+
+	t.Run("Resolves conflicting entries against the project config", func(t *testing.T) {
+		mo := MergeLockOptions{}
+		mo.Args.Base = write("base.yml", Locks{"lib/a": v1, "lib/c": v1, "lib/removed": v1})
+		mo.Args.Current = write("current.yml", Locks{"lib/a": v1, "lib/b": v1, "lib/c": v1, "lib/removed": v2})
+		mo.Args.Other = write("other.yml", Locks{"lib/a": v2, "lib/b": v2, "lib/c": v2, "lib/removed": {Hash: untagged, URL: url}})
+
+		merges, err := gpm.MergeLock(ctx, &mo)
+		assert.Nil(t, err)
+		assert.EqualValues(t, []LockMerge{
+			{Path: "lib/a", Resolution: MergeTheirs, Hash: v2.Hash},
+			{Path: "lib/b", Resolution: MergeResolved, Hash: v1.Hash},
+			{Path: "lib/c", Resolution: MergeTheirs, Hash: v2.Hash},
+			{Path: "lib/removed", Resolution: MergeResolved},
+		}, merges)
+
+		locks, err := readLocks(mo.Args.Current)
+		assert.Nil(t, err)
+		assert.EqualValues(t, Locks{"lib/a": v2, "lib/b": v1, "lib/c": v2}, locks)
+	})
+
+	t.Run("Selects the latest matching tag", func(t *testing.T) {
+		mo := MergeLockOptions{}
+		mo.Args.Base = write("base.yml", Locks{})
+		mo.Args.Current = write("current.yml", Locks{"lib/a": v2})
+		mo.Args.Other = write("other.yml", Locks{"lib/a": v1})
+
+		merges, err := gpm.MergeLock(ctx, &mo)
+		assert.Nil(t, err)
+		assert.EqualValues(t, []LockMerge{{Path: "lib/a", Resolution: MergeResolved, Hash: v2.Hash}}, merges)
+	})
+
+	t.Run("Re-resolves against the dependency version", func(t *testing.T) {
+		mo := MergeLockOptions{}
+		mo.Args.Base = write("base.yml", Locks{"lib/b": v1})
+		mo.Args.Current = write("current.yml", Locks{"lib/b": v2})
+		mo.Args.Other = write("other.yml", Locks{"lib/b": {Hash: untagged, URL: url}})
+
+		merges, err := gpm.MergeLock(ctx, &mo)
+		assert.Nil(t, err)
+		assert.EqualValues(t, []LockMerge{{Path: "lib/b", Resolution: MergeResolved, Hash: v1.Hash}}, merges)
+	})
+
+	t.Run("Leaves unresolvable conflicts with markers", func(t *testing.T) {
+		head := gpmtest.Git(t, filepath.Join(projectDir, "lib/a"), "rev-parse", "HEAD")
+
+		mo := MergeLockOptions{}
+		mo.Args.Base = write("base.yml", Locks{"lib/a": v1, "lib/missing": v1})
+		mo.Args.Current = write("current.yml", Locks{"lib/a": v2, "lib/missing": v2})
+		mo.Args.Other = write("other.yml", Locks{"lib/a": v1, "lib/missing": {Hash: untagged, URL: url}})
+
+		merges, err := gpm.MergeLock(ctx, &mo)
+		assert.EqualValues(t, ErrLockfile, Code(err))
+		assert.EqualValues(t, []LockMerge{
+			{Path: "lib/a", Resolution: MergeOurs, Hash: v2.Hash},
+			{Path: "lib/missing", Resolution: MergeConflict},
+		}, merges)
+
+		d, err := ioutil.ReadFile(mo.Args.Current)
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(string(d), "lib/a:\n"), string(d))
+		assert.Contains(t, string(d), "<<<<<<< ours\nlib/missing:\n  hash: "+v2.Hash)
+		assert.Contains(t, string(d), "=======\nlib/missing:\n  hash: "+untagged)
+		assert.True(t, strings.HasSuffix(string(d), ">>>>>>> theirs\n"), string(d))
+
+		// Missing modules are not cloned, and existing modules are left in place
+		_, err = os.Stat(filepath.Join(projectDir, "lib/missing"))
+		assert.True(t, os.IsNotExist(err))
+		assert.EqualValues(t, head, gpmtest.Git(t, filepath.Join(projectDir, "lib/a"), "rev-parse", "HEAD"))
+	})
+
+	t.Run("Resolves against the merged project config", func(t *testing.T) {
+		gpmtest.Git(t, projectDir, "init", "-q")
+		gpmtest.Git(t, projectDir, "add", ProjectConfigName)
+		gpmtest.Git(t, projectDir, "-c", "user.name=gpm", "-c", "user.email=gpm@example.com", "commit", "-q", "-m", "Base")
+		gpmtest.Git(t, projectDir, "checkout", "-q", "-b", "other")
+
+		// Change the dependency version on the merged branch only
+		pc.Dependencies[1].Version = ">=0.2.0"
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+		gpmtest.Git(t, projectDir, "-c", "user.name=gpm", "-c", "user.email=gpm@example.com", "commit", "-q", "-a", "-m", "Other")
+		other := gpmtest.Git(t, projectDir, "rev-parse", "HEAD")
+		gpmtest.Git(t, projectDir, "checkout", "-q", "-")
+
+		os.Setenv("GITHEAD_"+other, "other")
+		defer os.Unsetenv("GITHEAD_" + other)
+
+		mo := MergeLockOptions{}
+		mo.Args.Base = write("base.yml", Locks{"lib/b": v1})
+		mo.Args.Current = write("current.yml", Locks{"lib/b": {Hash: untagged, URL: url}})
+		mo.Args.Other = write("other.yml", Locks{"lib/b": v2})
+
+		merges, err := gpm.MergeLock(ctx, &mo)
+		assert.Nil(t, err)
+		assert.EqualValues(t, []LockMerge{{Path: "lib/b", Resolution: MergeResolved, Hash: v2.Hash}}, merges)
+
+		// Dependencies changed differently on both branches are left for manual resolution
+		pc.Dependencies[1].Version = "<0.2.0"
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+
+		mo.Args.Current = write("current.yml", Locks{"lib/b": {Hash: untagged, URL: url}})
+		merges, err = gpm.MergeLock(ctx, &mo)
+		assert.EqualValues(t, ErrLockfile, Code(err))
+		assert.EqualValues(t, []LockMerge{{Path: "lib/b", Resolution: MergeConflict}}, merges)
+	})
+}
+
+func TestInstallMergeDriver(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	projectDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(projectDir)
+
+	gpmtest.Git(t, projectDir, "init", "-q")
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(projectDir, GitAttributesName), []byte("*.png binary"), 0644))
+
+	gpm := NewGPM(&CommonOptions{BasePath: projectDir})
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, gpm.InstallMergeDriver(context.Background(), &InstallMergeDriverOptions{}))
+	}
+
+	assert.EqualValues(t, MergeDriverCommand, strings.TrimSpace(gpmtest.Git(t, projectDir, "config", "merge.gpm-lock.driver")))
+	assert.EqualValues(t, "merge: gpm-lock", strings.TrimPrefix(strings.TrimSpace(gpmtest.Git(t, projectDir, "check-attr", "merge", LockfileName)), LockfileName+": "))
+
+	d, err := ioutil.ReadFile(filepath.Join(projectDir, GitAttributesName))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "*.png binary\n.lock.yml merge=gpm-lock\n", string(d))
+}
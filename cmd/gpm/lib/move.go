@@ -0,0 +1,184 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+)
+
+// Move relocates a dependency to a new path, moving the module worktree in place of
+// removing and re-cloning it so that local state is preserved
+func (gpm *GPM) Move(ctx context.Context, mo *MoveOptions) (m *Module, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	from, to := mo.Args.From, mo.Args.To
+	if from == "" || to == "" {
+		return nil, errorf(ErrInvalidOptions, "Module source and destination paths cannot be empty")
+	}
+	if from == to {
+		return nil, errorf(ErrInvalidOptions, "Module source and destination paths must differ")
+	}
+
+	dep, ok := pc.Dependencies.Find(from)
+	if !ok {
+		return nil, errorf(ErrNoDependency, "No dependency bound to location '%s'", from)
+	}
+	if _, ok := pc.Dependencies.Find(to); ok {
+		return nil, errorf(ErrInvalidOptions, "Dependency already bound to location '%s'", to)
+	}
+	if n, ok := pc.Dependencies.Nested(to); ok {
+		return nil, errorf(ErrInvalidPath, "Destination '%s' overlaps dependency '%s', modules cannot be nested", to, n.Path)
+	}
+
+	// Resolve full module paths, refusing destinations outside the project
+	fromPath, err := gpm.modulePath(from)
+	if err != nil {
+		return nil, err
+	}
+	toPath, err := gpm.modulePath(to)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(toPath); err == nil {
+		return nil, errorf(ErrInvalidPath, "Destination '%s' already exists", to)
+	}
+
+	if gpm.options.Verbose {
+		gpm.logf("Move (%s) moving module to '%s'", from, to)
+	}
+
+	// Update project config
+	dep.Path = to
+	pc.Dependencies.Set(from, *dep)
+	if err := gpm.writeProjectConfig(&pc); err != nil {
+		return nil, err
+	}
+
+	// Update lockfile
+	locks, err := gpm.loadLockfile()
+	if err != nil {
+		return nil, err
+	}
+	lock, locked := locks[from]
+	if locked {
+		delete(locks, from)
+		locks[to] = lock
+	}
+	if err := gpm.writeLockfile(&locks); err != nil {
+		return nil, err
+	}
+
+	if err := gpm.renameGitIgnore(from, to); err != nil {
+		return nil, err
+	}
+
+	// Move the worktree last, modules not yet synced are fetched by the next sync
+	repo := NewRepo(fromPath, dep.URL)
+	if repo.Exists() {
+		if err := gpm.moveRepo(repo, toPath); err != nil {
+			return nil, err
+		}
+		if err := gpm.markModule(repo); err != nil {
+			return nil, wrapError(ErrFilesystem, err, "Error writing module marker in '%s'", to)
+		}
+	} else if gpm.options.Verbose {
+		gpm.logf("Move (%s) module not found, skipping worktree", from)
+	}
+
+	return &Module{Dependency: *dep, Hash: lock.Hash}, nil
+}
+
+// moveRepo moves a module worktree, recording the move in the current transaction
+func (gpm *GPM) moveRepo(repo *Repo, path string) error {
+	tx := gpm.tx
+	if tx != nil {
+		tx.moves = append(tx.moves, move{repo: repo, from: repo.path, to: path})
+		if err := tx.save(); err != nil {
+			return err
+		}
+	}
+	if err := repo.Move(path); err != nil {
+		if tx != nil {
+			tx.moves = tx.moves[:len(tx.moves)-1]
+		}
+		return wrapError(ErrFilesystem, err, "Error moving module '%s' to '%s'", gpm.repoPath(repo), path)
+	}
+	return nil
+}
+
+// Move relocates the repo worktree, updating the git directory links of worktrees with
+// a separate git directory (ie. checked out git submodules)
+func (repo *Repo) Move(path string) error {
+	// Resolve separate git directories prior to moving
+	var separate string
+	if info, err := os.Stat(filepath.Join(repo.path, ".git")); err == nil && !info.IsDir() {
+		separate, err = filepath.Abs(gitDir(repo.path))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(repo.path, path); err != nil {
+		return err
+	}
+	repo.path = path
+	repo.repository = nil
+
+	if separate != "" {
+		if err := relinkGitDir(path, separate); err != nil {
+			return err
+		}
+	}
+
+	return repo.Open()
+}
+
+// relinkGitDir points a worktree at a separate git directory, and the git directory
+// back at the worktree where a worktree is configured
+func relinkGitDir(worktree, dir string) error {
+	abs, err := filepath.Abs(worktree)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(abs, dir)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: "+filepath.ToSlash(rel)+"\n"), 0644); err != nil {
+		return err
+	}
+
+	r, err := git.PlainOpen(worktree)
+	if err != nil {
+		return err
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	if cfg.Core.Worktree == "" {
+		return nil
+	}
+	back, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return err
+	}
+	cfg.Core.Worktree = strings.TrimSuffix(filepath.ToSlash(back), "/")
+
+	return r.Storer.SetConfig(cfg)
+}
@@ -0,0 +1,133 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMove(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0")
+	bare, hashes := module.URL(), module.Hashes
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+	events := make([]ProgressEvent, 0)
+	o := CommonOptions{BasePath: projectDir, Verbose: true}
+	gpm := NewGPM(&o).WithProgress(ProgressFunc(func(e ProgressEvent) {
+		events = append(events, e)
+	}))
+	ctx := context.Background()
+
+	pc := ProjectConfig{Name: "project"}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+	assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+	for _, p := range []string{"lib/a", "lib/b"} {
+		_, err := gpm.Add(ctx, &AddOptions{Path: p, URL: bare, Version: "v0.1.0"})
+		assert.Nil(t, err)
+	}
+
+	gitIgnore := "build/\n# BEGIN gpm modules\n/lib/a/\n/lib/b/\n# END gpm modules\n/lib/a/\n"
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(projectDir, GitIgnoreName), []byte(gitIgnore), 0644))
+
+	// Local changes are preserved by moves
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(projectDir, "lib/a/local"), []byte("changes"), 0644))
+
+	t.Run("Moves modules without recloning", func(t *testing.T) {
+		mo := MoveOptions{}
+		mo.Args.From, mo.Args.To = "lib/a", "vendor/a"
+		m, err := gpm.Move(ctx, &mo)
+		assert.Nil(t, err)
+		assert.EqualValues(t, "vendor/a", m.Path)
+		assert.EqualValues(t, hashes["v0.1.0"], m.Hash)
+
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		_, ok := pc.Dependencies.Find("lib/a")
+		assert.False(t, ok)
+		d, ok := pc.Dependencies.Find("vendor/a")
+		if assert.True(t, ok) {
+			assert.EqualValues(t, bare, d.URL)
+		}
+		assert.EqualValues(t, "vendor/a", pc.Dependencies[0].Path, "dependency order is preserved")
+
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, Locks{"vendor/a": {Hash: hashes["v0.1.0"], URL: bare}, "lib/b": {Hash: hashes["v0.1.0"], URL: bare}}, locks)
+
+		// Only entries in the managed block are updated
+		d2, err := ioutil.ReadFile(filepath.Join(projectDir, GitIgnoreName))
+		assert.Nil(t, err)
+		assert.EqualValues(t, "build/\n# BEGIN gpm modules\n/vendor/a/\n/lib/b/\n# END gpm modules\n/lib/a/\n", string(d2))
+
+		_, err = os.Stat(filepath.Join(projectDir, "lib/a"))
+		assert.True(t, os.IsNotExist(err))
+		local, err := ioutil.ReadFile(filepath.Join(projectDir, "vendor/a/local"))
+		assert.Nil(t, err)
+		assert.EqualValues(t, "changes", string(local))
+
+		marker := ModuleMarker{}
+		assert.Nil(t, o.loadYaml(filepath.Join("vendor/a/.git", ModuleMarkerName), &marker))
+		assert.EqualValues(t, "vendor/a", marker.Path)
+
+		// Moved modules sync in place
+		events = events[:0]
+		_, err = gpm.Sync(ctx, &SyncOptions{})
+		assert.Nil(t, err)
+		for _, e := range events {
+			assert.NotEqual(t, ProgressCloneStarted, e.Type, "%+v", e)
+		}
+	})
+
+	t.Run("Rejects invalid moves", func(t *testing.T) {
+		tests := []struct {
+			from, to string
+			code     ErrorCode
+		}{
+			{"lib/missing", "lib/c", ErrNoDependency},
+			{"lib/b", "vendor/a", ErrInvalidOptions},
+			{"lib/b", "lib/b", ErrInvalidOptions},
+			{"lib/b", "../outside", ErrInvalidPath},
+			{"lib/b", "vendor/a/b", ErrInvalidPath},
+			{"lib/b", "lib/b/c", ErrInvalidPath},
+			{"lib/b", "", ErrInvalidOptions},
+		}
+
+		for _, test := range tests {
+			mo := MoveOptions{}
+			mo.Args.From, mo.Args.To = test.from, test.to
+			_, err := gpm.Move(ctx, &mo)
+			assert.EqualValues(t, test.code, Code(err), "%+v", test)
+		}
+
+		// Existing unmanaged directories are not overwritten
+		assert.Nil(t, os.MkdirAll(filepath.Join(projectDir, "docs"), 0755))
+		mo := MoveOptions{}
+		mo.Args.From, mo.Args.To = "lib/b", "docs"
+		_, err := gpm.Move(ctx, &mo)
+		assert.EqualValues(t, ErrInvalidPath, Code(err))
+
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		_, ok := pc.Dependencies.Find("lib/b")
+		assert.True(t, ok)
+		_, err = os.Stat(filepath.Join(projectDir, "lib/b"))
+		assert.Nil(t, err)
+	})
+}
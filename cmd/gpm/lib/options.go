@@ -3,21 +3,37 @@ package gpm
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
-	"strings"
+	"time"
 
-	"github.com/go-yaml/yaml"
+	"gopkg.in/yaml.v3"
 )
 
 // Options is a go-flags compatible composite structure containing
 // the options for each GPM command as well as the CommonOptions across them
 type Options struct {
 	CommonOptions
-	Init   InitOptions   `command:"init"`
-	Add    AddOptions    `command:"add"`
-	Sync   SyncOptions   `command:"sync"`
-	Update UpdateOptions `command:"update"`
-	Remove RemoveOptions `command:"remove"`
+	Init    InitOptions    `command:"init"`
+	Add     AddOptions     `command:"add"`
+	Sync    SyncOptions    `command:"sync"`
+	Update  UpdateOptions  `command:"update"`
+	Upgrade UpgradeOptions `command:"upgrade"`
+	Remove  RemoveOptions  `command:"remove"`
+	Move    MoveOptions    `command:"mv" alias:"move"`
+	Import  ImportOptions  `command:"import"`
+	Export  ExportOptions  `command:"export"`
+	Check   CheckOptions   `command:"check"`
+	Release ReleaseOptions `command:"release"`
+	UI      UIOptions      `command:"ui"`
+	Clean   CleanOptions   `command:"clean"`
+	GC      GCOptions      `command:"gc"`
+	Fmt     FmtOptions     `command:"fmt"`
+
+	MergeLock          MergeLockOptions          `command:"merge-lock" description:"Merge lockfiles (git merge driver)"`
+	InstallMergeDriver InstallMergeDriverOptions `command:"install-merge-driver" description:"Register the lockfile merge driver with git"`
+	Search             SearchOptions             `command:"search" description:"Search package indexes"`
+	Audit              AuditOptions              `command:"audit" description:"Check locked module versions against an advisory database"`
 }
 
 // InitOptions defines the options for the Init command
@@ -27,6 +43,10 @@ type InitOptions struct {
 	Repository string            `short:"r" long:"repo" description:"Project repository"`
 	Homepage   string            `short:"h" long:"homepage" description:"Project homepage"`
 	Meta       map[string]string `short:"m" long:"meta" description:"Project metadata (key:value pairs)"`
+	Format     string            `long:"format" default:"yaml" choice:"yaml" choice:"toml" choice:"json" description:"Project file format"`
+
+	FromSubmodules bool `long:"from-submodules" description:"Import dependencies from existing git submodules"`
+	Deinit         bool `long:"deinit" description:"Deinitialise submodules once imported (with --from-submodules)"`
 }
 
 // AddOptions defines the options for the Add command
@@ -34,17 +54,134 @@ type AddOptions struct {
 	Path    string `short:"o" long:"path" description:"Module path"`
 	URL     string `short:"u" long:"url" description:"Module URL"`
 	Version string `short:"v" long:"version" description:"Module version filter (http://semver.org/)"`
+
+	Prefix     string `long:"tag-prefix" description:"Module tag prefix, stripped before version matching (ie. mylib- for mylib-v1.2.3)"`
+	Prerelease bool   `long:"prerelease" description:"Allow prerelease tags to match the module version"`
+
+	Depth       int  `long:"depth" description:"Number of commits fetched from each branch and tag when cloning (default 1)"`
+	FullHistory bool `long:"full-history" description:"Fetch the complete module history when cloning"`
+
+	Submodules bool   `long:"submodules" description:"Check out module submodules at their recorded commits"`
+	LFS        bool   `long:"lfs" description:"Fetch git LFS objects in place of pointer files"`
+	LFSURL     string `long:"lfs-url" description:"LFS endpoint (defaults to the module URL with .git/info/lfs)"`
+
+	Groups []string `short:"g" long:"group" description:"Dependency group including the module, ie. dev or test (repeatable)"`
+
+	Replace bool `long:"replace" description:"Replace an existing dependency at the module path in place"`
+
+	Args struct {
+		Package string `positional-arg-name:"package" description:"Package name from a package index, with an optional version (ie. mylib@^1.2)"`
+	} `positional-args:"yes"`
 }
 
 // SyncOptions defines the options for the Sync command
-type SyncOptions struct{}
+type SyncOptions struct {
+	GroupOptions
+
+	Restart bool `long:"restart" description:"Sync all modules, ignoring modules completed by an interrupted sync"`
+}
 
 // UpdateOptions defines the options for the Update command
-type UpdateOptions struct{}
+type UpdateOptions struct {
+	GroupOptions
+}
+
+// GroupOptions defines the dependency group selection for commands operating on all dependencies
+type GroupOptions struct {
+	Groups  []string `short:"g" long:"group" description:"Only include dependencies in the named group, 'default' for ungrouped dependencies (repeatable)"`
+	Without []string `long:"without" description:"Exclude dependencies in the named group (repeatable)"`
+}
+
+// UpgradeOptions defines the options for the Upgrade command
+type UpgradeOptions struct {
+	To     string `short:"t" long:"to" description:"Upgrade to an explicit version range (http://semver.org/)"`
+	Latest bool   `long:"latest" description:"Upgrade to the latest tag, including prereleases"`
+	Major  bool   `long:"major" description:"Upgrade to the latest release, allowing major version changes"`
+	Minor  bool   `long:"minor" description:"Upgrade to the latest release within the current major version (default)"`
+	Args   struct {
+		Path string `positional-arg-name:"path" description:"Module path (defaults to all modules)"`
+	} `positional-args:"yes"`
+}
 
 // RemoveOptions defines the options for the Remove command
 type RemoveOptions struct {
-	Path string `short:"o" long:"path" description:"Module path"`
+	Path  string `short:"o" long:"path" description:"Module path"`
+	Force bool   `short:"f" long:"force" description:"Remove modules with local changes"`
+}
+
+// MoveOptions defines the options for the Move command
+type MoveOptions struct {
+	Args struct {
+		From string `positional-arg-name:"from" description:"Current module path"`
+		To   string `positional-arg-name:"to" description:"New module path"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// ImportOptions defines the options for the Import command
+type ImportOptions struct {
+	Deinit bool `long:"deinit" description:"Deinitialise submodules once imported"`
+}
+
+// ExportOptions defines the options for the Export command
+type ExportOptions struct {
+	Format string `short:"f" long:"format" default:"spdx" choice:"spdx" choice:"cyclonedx" choice:"licenses" choice:"gitmodules" description:"Export format"`
+	File   string `long:"file" description:"Output file (defaults to stdout)"`
+}
+
+// CheckOptions defines the options for the Check command
+type CheckOptions struct{}
+
+// ReleaseOptions defines the options for the Release command
+type ReleaseOptions struct {
+	Prerelease string `long:"pre" description:"Prerelease identifier for the new version (ie. rc.1)"`
+	SignKey    string `long:"sign-key" description:"Armored PGP private key file used to sign the release tag"`
+	DryRun     bool   `long:"dry-run" description:"Validate and print the release without creating a tag"`
+	Args       struct {
+		Bump string `positional-arg-name:"bump" description:"Version bump (major, minor, patch) or explicit version"`
+	} `positional-args:"yes"`
+}
+
+// UIOptions defines the options for the interactive UI command
+type UIOptions struct{}
+
+// CleanOptions defines the options for the Clean command
+type CleanOptions struct {
+	DryRun bool `long:"dry-run" description:"List orphaned modules without removing them"`
+	Force  bool `short:"f" long:"force" description:"Remove orphaned modules with uncommitted or untracked changes"`
+}
+
+// GCOptions defines the options for the GC command
+type GCOptions struct {
+	Prune time.Duration `long:"prune" default:"336h" description:"Only prune unreachable objects older than this, as with git gc --prune (0 prunes all)"`
+}
+
+// MergeLockOptions defines the options for the MergeLock command
+type MergeLockOptions struct {
+	Args struct {
+		Base    string `positional-arg-name:"base" description:"Common ancestor lockfile (%O)"`
+		Current string `positional-arg-name:"current" description:"Current lockfile (%A), replaced with the merged lockfile"`
+		Other   string `positional-arg-name:"other" description:"Other branch lockfile (%B)"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// InstallMergeDriverOptions defines the options for the InstallMergeDriver command
+type InstallMergeDriverOptions struct{}
+
+// SearchOptions defines the options for the Search command
+type SearchOptions struct {
+	Args struct {
+		Query string `positional-arg-name:"query" description:"Text matched against package names, descriptions and keywords (lists all packages if empty)"`
+	} `positional-args:"yes"`
+}
+
+// AuditOptions defines the options for the Audit command
+type AuditOptions struct {
+	Database string `long:"db" env:"GPM_ADVISORY_DB" description:"Advisory database directory of OSV advisories (YAML or JSON)"`
+}
+
+// FmtOptions defines the options for the Fmt command
+type FmtOptions struct {
+	Check bool `long:"check" description:"Fail if the project file is not formatted, without modifying it"`
 }
 
 // CommonOptions defines common options for all GPM commands
@@ -52,22 +189,16 @@ type CommonOptions struct {
 	BasePath  string `short:"c" long:"chdir" description:"Change base directory"`
 	NoCleanup bool   `long:"no-cleanup" description:"Disable cleanup of temporary files/folders"`
 	Verbose   bool   `long:"verbose" description:"Enable verbose outputs"`
-}
+	Output    string `long:"output" default:"text" choice:"text" choice:"json" description:"Output format"`
 
-// fullPath resolves a filename to a full path using the provided options
-func (options *CommonOptions) fullPath(filename string) (string, error) {
-	full := fmt.Sprintf("%s/%s", options.BasePath, filename)
+	LockTimeout time.Duration `long:"lock-timeout" default:"30s" description:"Time to wait for other gpm processes to release the project lock"`
 
-	// Check path is inside basepath
-	rel, err := filepath.Rel(options.BasePath, full)
-	if err != nil {
-		return "", err
-	}
-	if strings.Contains(rel, "..") {
-		return "", fmt.Errorf("Invalid full path '%s' (must be within '%s')", full, options.BasePath)
-	}
+	Timeout      time.Duration `long:"timeout" default:"10m" description:"Timeout for each module clone or fetch attempt (0 for no timeout)"`
+	Retries      int           `long:"retries" default:"3" description:"Number of times to retry failed module clones and fetches"`
+	RetryBackoff time.Duration `long:"retry-backoff" default:"1s" description:"Delay before retrying a failed clone or fetch, doubled for each retry"`
 
-	return full, nil
+	Indexes    []string `long:"index" env:"GPM_INDEX" env-delim:"," description:"Package index (git URL or local directory) resolving package names, after any project indexes (repeatable)"`
+	IndexCache string   `long:"index-cache" env:"GPM_INDEX_CACHE" description:"Directory caching cloned package indexes (defaults to the user cache directory)"`
 }
 
 // loadYaml loads a yaml file into an object using the provided options
@@ -97,15 +228,70 @@ func (options *CommonOptions) writeYaml(filename string, obj interface{}) error
 		return err
 	}
 
-	b, err := yaml.Marshal(obj)
+	b, err := encodeYaml(obj)
 	if err != nil {
-		return fmt.Errorf("Error marshaling object to file '%s' (%s)", filename, err)
+		return wrapError(ErrFilesystem, err, "Error marshaling object to file '%s'", filename)
 	}
 
-	err = ioutil.WriteFile(fullpath, b, 0644)
+	err = writeFile(fullpath, b)
 	if err != nil {
-		return fmt.Errorf("Error writing to file '%s' (%s)", filename, err)
+		return wrapError(ErrFilesystem, err, "Error writing to file '%s'", filename)
 	}
 
 	return nil
 }
+
+// updateYaml updates an existing yaml file to match an object, preserving comments and ordering
+// of unchanged entries, or creates a new file if none exists
+func (options *CommonOptions) updateYaml(filename string, obj interface{}) error {
+	fullpath, err := options.fullPath(filename)
+	if err != nil {
+		return err
+	}
+
+	d, err := ioutil.ReadFile(fullpath)
+	if os.IsNotExist(err) {
+		return options.writeYaml(filename, obj)
+	} else if err != nil {
+		return wrapError(ErrFilesystem, err, "Error reading file '%s'", filename)
+	}
+
+	b, err := mergeYaml(d, obj)
+	if err != nil {
+		return wrapError(ErrFilesystem, err, "Error updating file '%s'", filename)
+	}
+
+	err = writeFile(fullpath, b)
+	if err != nil {
+		return wrapError(ErrFilesystem, err, "Error writing to file '%s'", filename)
+	}
+
+	return nil
+}
+
+// writeFile atomically replaces a file by writing to a temporary file in the
+// same directory then renaming it into place
+func writeFile(fullpath string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(fullpath), "."+filepath.Base(fullpath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), fullpath)
+}
@@ -0,0 +1,132 @@
+package gpm
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// reservedPaths are project files that cannot be used as module paths
+var reservedPaths = []string{ProjectConfigName, ProjectConfigTOMLName, ProjectConfigJSONName, LockfileName, ProjectLockName, JournalName, GitIgnoreName, GitAttributesName, GitModulesName, SyncStateName}
+
+// fullPath resolves a project relative path to a path within the base directory, rejecting
+// absolute paths, the base directory itself, and paths escaping the base directory either
+// directly or via symlinks
+func (options *CommonOptions) fullPath(filename string) (string, error) {
+	if filename == "" || filepath.IsAbs(filename) || filepath.VolumeName(filename) != "" {
+		return "", errorf(ErrInvalidPath, "Invalid path '%s' (must be relative to '%s')", filename, options.BasePath)
+	}
+
+	rel := filepath.Clean(filepath.FromSlash(filename))
+	if rel == "." || !within(".", rel) {
+		return "", errorf(ErrInvalidPath, "Invalid path '%s' (must be within '%s')", filename, options.BasePath)
+	}
+	full := filepath.Join(options.BasePath, rel)
+
+	if err := options.checkSymlinks(full); err != nil {
+		return "", wrapError(ErrInvalidPath, err, "Invalid path '%s'", filename)
+	}
+
+	return full, nil
+}
+
+// checkSymlinks resolves the existing portion of a path, checking it does not
+// resolve outside the base directory
+func (options *CommonOptions) checkSymlinks(full string) error {
+	base, err := resolvePath(options.BasePath)
+	if err != nil {
+		return err
+	}
+
+	// Find the deepest existing path, missing paths cannot contain symlinks
+	existing := full
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return nil
+		}
+		existing = parent
+	}
+
+	resolved, err := resolvePath(existing)
+	if err != nil {
+		return fmt.Errorf("path cannot be resolved (%s)", err)
+	}
+	if !within(base, resolved) {
+		return fmt.Errorf("path resolves outside '%s'", options.BasePath)
+	}
+
+	return nil
+}
+
+// resolvePath fetches the absolute path with all symlinks resolved
+func resolvePath(p string) (string, error) {
+	if p == "" {
+		p = "."
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// within checks whether a path is equal to or contained by the base path, where both
+// paths are either absolute or relative to the same directory
+func within(base, p string) bool {
+	rel, err := filepath.Rel(base, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// validateModulePath checks a dependency path is a clean, slash separated relative path
+// (ie. `lib/module`) that does not refer to the project root, git directories or project files
+func validateModulePath(p string) error {
+	switch {
+	case p == "":
+		return fmt.Errorf("path cannot be empty")
+	case strings.HasPrefix(p, "/") || filepath.IsAbs(p) || filepath.VolumeName(p) != "":
+		return fmt.Errorf("path must be relative to the project directory")
+	case strings.Contains(p, "\\"):
+		return fmt.Errorf("path must use '/' separators")
+	case p == ".":
+		return fmt.Errorf("path cannot be the project directory")
+	case path.Clean(p) != p:
+		return fmt.Errorf("path must be clean (ie. '%s')", path.Clean(p))
+	case p == ".." || strings.HasPrefix(p, "../"):
+		return fmt.Errorf("path must be within the project directory")
+	}
+
+	for _, c := range strings.Split(p, "/") {
+		if strings.EqualFold(c, ".git") {
+			return fmt.Errorf("path cannot include git directories")
+		}
+	}
+	for _, r := range reservedPaths {
+		if strings.EqualFold(p, r) {
+			return fmt.Errorf("path cannot be the project file '%s'", r)
+		}
+	}
+
+	return nil
+}
+
+// nestedPath checks whether a module path is nested inside a parent module path
+func nestedPath(parent, p string) bool {
+	return strings.HasPrefix(p, parent+"/")
+}
+
+// modulePath validates a dependency path and resolves it to a path within the project directory
+func (gpm *GPM) modulePath(p string) (string, error) {
+	if err := validateModulePath(p); err != nil {
+		return "", errorf(ErrInvalidPath, "Invalid module path '%s' (%s)", p, err)
+	}
+	return gpm.options.fullPath(p)
+}
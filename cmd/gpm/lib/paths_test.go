@@ -0,0 +1,252 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// pathsDir creates a project directory containing symlinks within and outside the project
+func pathsDir(t testing.TB) (string, func()) {
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+
+	projectDir := filepath.Join(testDir, "project")
+	outsideDir := filepath.Join(testDir, "outside")
+	for _, d := range []string{filepath.Join(projectDir, "lib"), outsideDir} {
+		assert.Nil(t, os.MkdirAll(d, 0755))
+	}
+	assert.Nil(t, os.Symlink(outsideDir, filepath.Join(projectDir, "escape")))
+	assert.Nil(t, os.Symlink("lib", filepath.Join(projectDir, "inside")))
+	assert.Nil(t, os.Symlink(filepath.Join(testDir, "missing"), filepath.Join(projectDir, "dangling")))
+
+	return projectDir, func() { os.RemoveAll(testDir) }
+}
+
+func TestPaths(t *testing.T) {
+	projectDir, cleanup := pathsDir(t)
+	defer cleanup()
+
+	o := CommonOptions{BasePath: projectDir}
+	gpm := GPM{options: &o}
+
+	t.Run("Resolves paths within the project", func(t *testing.T) {
+		tests := []struct {
+			path string
+			full string
+			code ErrorCode
+		}{
+			{"lib/module", "lib/module", ErrNone},
+			{"lib/../module", "module", ErrNone},
+			{"foo..bar", "foo..bar", ErrNone},
+			{"..foo/bar", "..foo/bar", ErrNone},
+			{"inside/module", "inside/module", ErrNone},
+			{ProjectConfigName, ProjectConfigName, ErrNone},
+			{"", "", ErrInvalidPath},
+			{".", "", ErrInvalidPath},
+			{"lib/..", "", ErrInvalidPath},
+			{"..", "", ErrInvalidPath},
+			{"../outside", "", ErrInvalidPath},
+			{"lib/../../outside", "", ErrInvalidPath},
+			{"/etc/passwd", "", ErrInvalidPath},
+			{"escape", "", ErrInvalidPath},
+			{"escape/module", "", ErrInvalidPath},
+			{"dangling/module", "", ErrInvalidPath},
+		}
+
+		for _, test := range tests {
+			full, err := o.fullPath(test.path)
+			assert.EqualValues(t, test.code, Code(err), "%+v: %v", test, err)
+			if test.code == ErrNone {
+				assert.EqualValues(t, filepath.Join(projectDir, test.full), full, "%+v", test)
+			}
+		}
+	})
+
+	t.Run("Validates module paths", func(t *testing.T) {
+		tests := []struct {
+			path string
+			ok   bool
+		}{
+			{"lib/module", true},
+			{"foo..bar", true},
+			{"lib/.github", true},
+			{"", false},
+			{".", false},
+			{"..", false},
+			{"../module", false},
+			{"/lib/module", false},
+			{"lib/module/", false},
+			{"lib//module", false},
+			{"./lib/module", false},
+			{"lib/../module", false},
+			{"lib\\module", false},
+			{".git", false},
+			{"lib/.GIT/module", false},
+			{ProjectConfigName, false},
+			{LockfileName, false},
+			{GitIgnoreName, false},
+			{GitModulesName, false},
+		}
+
+		for _, test := range tests {
+			err := validateModulePath(test.path)
+			assert.EqualValues(t, test.ok, err == nil, "%+v: %v", test, err)
+		}
+
+		_, err := gpm.modulePath("escape")
+		assert.EqualValues(t, ErrInvalidPath, Code(err))
+	})
+
+	t.Run("Validates dependency paths on load", func(t *testing.T) {
+		pc := ProjectConfig{Name: "project", Dependencies: Dependencies{{Path: "../outside", URL: "https://github.com/ryankurte/test"}}}
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+
+		_, err := gpm.loadProjectConfig()
+		assert.EqualValues(t, ErrProjectConfig, Code(err))
+	})
+}
+
+func TestRemovePaths(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	projectDir, cleanup := pathsDir(t)
+	defer cleanup()
+
+	module := gpmtest.Versions(t, filepath.Join(filepath.Dir(projectDir), "module"), "v0.1.0")
+
+	o := CommonOptions{BasePath: projectDir}
+	gpm := GPM{options: &o}
+	ctx := context.Background()
+
+	pc := ProjectConfig{Name: "project"}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+	assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+	_, err := gpm.Add(ctx, &AddOptions{Path: "lib/module", URL: module.URL(), Version: "v0.1.0"})
+	assert.Nil(t, err)
+
+	t.Run("Refuses to add invalid paths", func(t *testing.T) {
+		for _, p := range []string{".", ".git", "../module", "escape/module"} {
+			_, err := gpm.Add(ctx, &AddOptions{Path: p, URL: module.URL()})
+			assert.EqualValues(t, ErrInvalidPath, Code(err), p)
+		}
+	})
+
+	t.Run("Refuses to remove modified modules", func(t *testing.T) {
+		local := filepath.Join(projectDir, "lib/module/local")
+		assert.Nil(t, ioutil.WriteFile(local, []byte("changes"), 0644))
+
+		_, err := gpm.Remove(ctx, &RemoveOptions{Path: "lib/module"})
+		assert.EqualValues(t, ErrDirtyModule, Code(err))
+
+		// Project config is restored with the module
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		_, ok := pc.Dependencies.Find("lib/module")
+		assert.True(t, ok)
+		_, err = os.Stat(local)
+		assert.Nil(t, err)
+
+		_, err = gpm.Remove(ctx, &RemoveOptions{Path: "lib/module", Force: true})
+		assert.Nil(t, err)
+		_, err = os.Stat(filepath.Join(projectDir, "lib/module"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Refuses to remove non-repository paths", func(t *testing.T) {
+		// Replace the module with a plain directory and a symlink to it
+		assert.Nil(t, os.MkdirAll(filepath.Join(projectDir, "lib/plain"), 0755))
+		assert.Nil(t, os.Symlink("plain", filepath.Join(projectDir, "lib/link")))
+
+		for _, p := range []string{"lib/plain", "lib/link"} {
+			pc, err := gpm.loadProjectConfig()
+			assert.Nil(t, err)
+			pc.Dependencies = append(pc.Dependencies, Dependency{Path: p, URL: module.URL()})
+			assert.Nil(t, gpm.writeProjectConfig(&pc))
+
+			_, err = gpm.Remove(ctx, &RemoveOptions{Path: p, Force: true})
+			assert.EqualValues(t, ErrInvalidPath, Code(err), p)
+			_, err = os.Lstat(filepath.Join(projectDir, p))
+			assert.Nil(t, err, p)
+		}
+	})
+}
+
+// checkResolved checks an accepted path resolves within, and not to, the project directory
+func checkResolved(t *testing.T, base, p, full string) {
+	resolvedBase, err := resolvePath(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, err := filepath.Abs(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !within(resolvedBase, abs) || filepath.Clean(abs) == resolvedBase {
+		t.Errorf("Path '%s' resolved to '%s' outside '%s'", p, full, base)
+	}
+	if resolved, err := resolvePath(full); err == nil && !within(resolvedBase, resolved) {
+		t.Errorf("Path '%s' resolved via symlinks to '%s' outside '%s'", p, resolved, base)
+	}
+}
+
+var fuzzPaths = []string{
+	"lib/module", "foo..bar", "..", "../outside", "lib/../../outside", "/etc/passwd",
+	".", "", "escape/module", "inside/module", "dangling/x", ".git", "lib/.git/config",
+	"lib\\..\\..\\outside", "lib//module/", "./lib", "inside/../../outside", "lib/\x00",
+}
+
+func FuzzFullPath(f *testing.F) {
+	projectDir, cleanup := pathsDir(f)
+	defer cleanup()
+
+	for _, p := range fuzzPaths {
+		f.Add(p)
+	}
+
+	o := CommonOptions{BasePath: projectDir}
+	f.Fuzz(func(t *testing.T, p string) {
+		full, err := o.fullPath(p)
+		if err != nil {
+			if Code(err) != ErrInvalidPath {
+				t.Errorf("Unexpected error for path '%s': %v", p, err)
+			}
+			return
+		}
+		checkResolved(t, projectDir, p, full)
+	})
+}
+
+func FuzzModulePath(f *testing.F) {
+	projectDir, cleanup := pathsDir(f)
+	defer cleanup()
+
+	for _, p := range fuzzPaths {
+		f.Add(p)
+	}
+
+	gpm := GPM{options: &CommonOptions{BasePath: projectDir}}
+	f.Fuzz(func(t *testing.T, p string) {
+		full, err := gpm.modulePath(p)
+		if err != nil {
+			return
+		}
+		checkResolved(t, projectDir, p, full)
+
+		rel, err := filepath.Rel(projectDir, full)
+		if err != nil || filepath.ToSlash(rel) != p {
+			t.Errorf("Module path '%s' resolved to '%s'", p, rel)
+		}
+		if filepath.Base(full) == ".git" {
+			t.Errorf("Module path '%s' resolved to a git directory", p)
+		}
+	})
+}
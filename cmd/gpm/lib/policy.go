@@ -0,0 +1,143 @@
+package gpm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Policy defines project policies applied to dependencies
+type Policy struct {
+	Licenses LicensePolicy `yaml:",omitempty" toml:"licenses,omitempty" json:"licenses,omitempty"` // License policy for dependencies
+}
+
+// LicensePolicy defines the licenses permitted for project dependencies
+type LicensePolicy struct {
+	Allow        []string `yaml:",omitempty" toml:"allow,omitempty" json:"allow,omitempty"`                              // Permitted SPDX license identifiers (any if empty)
+	Deny         []string `yaml:",omitempty" toml:"deny,omitempty" json:"deny,omitempty"`                                // Forbidden SPDX license identifiers
+	AllowUnknown bool     `yaml:"allow-unknown,omitempty" toml:"allow-unknown,omitempty" json:"allow-unknown,omitempty"` // Permit modules with undetected licenses
+}
+
+// LicenseCheck is the result of checking a module license against the project policy
+type LicenseCheck struct {
+	Path    string `json:"path"`
+	License string `json:"license"`
+	Allowed bool   `json:"allowed"`
+}
+
+// String formats a license check as a human readable summary line
+func (c LicenseCheck) String() string {
+	status := "allowed"
+	if !c.Allowed {
+		status = "denied"
+	}
+	return fmt.Sprintf("%s: %s (%s)", c.Path, c.License, status)
+}
+
+// Allowed checks whether an SPDX license expression is permitted by the policy.
+// OR expressions are permitted if any option is allowed, AND expressions only if all terms are.
+func (p *LicensePolicy) Allowed(expr string) bool {
+	expr = strings.TrimSpace(strings.NewReplacer("(", " ", ")", " ").Replace(expr))
+	if expr == "" || expr == LicenseUnknown {
+		return p.AllowUnknown
+	}
+
+	for _, option := range strings.Split(expr, " OR ") {
+		allowed := true
+		for _, term := range strings.Split(option, " AND ") {
+			// License exceptions do not change the base license
+			id := strings.TrimSpace(strings.Split(term, " WITH ")[0])
+			if !p.allowedID(id) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowedID checks whether a single SPDX license identifier is permitted by the policy
+func (p *LicensePolicy) allowedID(id string) bool {
+	for _, d := range p.Deny {
+		if strings.EqualFold(d, id) {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, a := range p.Allow {
+		if strings.EqualFold(a, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check checks the licenses of all locked modules against the project license policy
+func (gpm *GPM) Check(ctx context.Context, co *CheckOptions) ([]LicenseCheck, error) {
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+	locks, err := gpm.loadLockfile()
+	if err != nil {
+		return nil, err
+	}
+
+	modules, err := gpm.lockedModules(ctx, &pc, locks)
+	if err != nil {
+		return nil, err
+	}
+	licenses, err := gpm.moduleLicenses(modules)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]LicenseCheck, 0, len(modules))
+	denied := 0
+
+	for _, m := range modules {
+		c := LicenseCheck{Path: m.Path, License: licenses[m.Path], Allowed: true}
+		if pc.Policy != nil {
+			c.Allowed = pc.Policy.Licenses.Allowed(c.License)
+		}
+		if !c.Allowed {
+			denied++
+		}
+		checks = append(checks, c)
+	}
+
+	if denied > 0 {
+		return checks, errorf(ErrLicensePolicy, "%d module(s) not permitted by the project license policy", denied)
+	}
+
+	return checks, nil
+}
+
+// checkLicense detects the license of a synced module and checks it against the project license policy
+func (gpm *GPM) checkLicense(pc *ProjectConfig, path string) error {
+	if pc.Policy == nil {
+		return nil
+	}
+
+	modulePath, err := gpm.modulePath(path)
+	if err != nil {
+		return err
+	}
+
+	license := DetectLicense(modulePath)
+	if gpm.options.Verbose {
+		gpm.logf("Check (%s) detected license: '%s'", path, license)
+	}
+
+	if !pc.Policy.Licenses.Allowed(license) {
+		return errorf(ErrLicensePolicy, "Module '%s' license '%s' is not permitted by the project license policy", path, license)
+	}
+
+	return nil
+}
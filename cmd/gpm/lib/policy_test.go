@@ -0,0 +1,142 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+
+	t.Run("Checks license expressions", func(t *testing.T) {
+		p := LicensePolicy{Allow: []string{"MIT", "Apache-2.0", "GPL-2.0-only"}, Deny: []string{"Apache-2.0"}}
+
+		tests := []struct {
+			Name    string
+			License string
+			Allowed bool
+		}{
+			{"Allowed licenses", "MIT", true},
+			{"Case insensitive", "mit", true},
+			{"Unlisted licenses", "BSD-3-Clause", false},
+			{"Denied licenses", "Apache-2.0", false},
+			{"Unknown licenses", LicenseUnknown, false},
+			{"OR expressions", "Apache-2.0 OR MIT", true},
+			{"AND expressions", "(MIT AND Apache-2.0)", false},
+			{"WITH exceptions", "GPL-2.0-only WITH Classpath-exception-2.0", true},
+		}
+
+		for _, test := range tests {
+			t.Run(test.Name, func(t *testing.T) {
+				assert.Equal(t, test.Allowed, p.Allowed(test.License))
+			})
+		}
+
+		p = LicensePolicy{Deny: []string{"GPL-3.0-only"}, AllowUnknown: true}
+		assert.True(t, p.Allowed("BSD-3-Clause"))
+		assert.True(t, p.Allowed(LicenseUnknown))
+		assert.False(t, p.Allowed("GPL-3.0-only"))
+	})
+
+	t.Run("Enforces project license policies", func(t *testing.T) {
+		gpmtest.RequireGit(t)
+
+		testDir, err := ioutil.TempDir("", "gpm-test")
+		if err != nil {
+			t.FailNow()
+		}
+		defer os.RemoveAll(testDir)
+
+		// Create a GPL licensed module repository
+		module := gpmtest.NewRepo(t, filepath.Join(testDir, "module"))
+		module.Commit("Initial commit", map[string]string{"COPYING": "GNU GENERAL PUBLIC LICENSE\nVersion 3"})
+		module.Tag("v0.1.0", gpmtest.Annotated)
+
+		projectDir := filepath.Join(testDir, "project")
+		assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+		o := CommonOptions{BasePath: projectDir}
+		gpm := GPM{options: &o}
+
+		_, err = gpm.Init(context.Background(), &InitOptions{Name: "Test Project", Repository: "https://github.com/ryankurte/test-repo"})
+		assert.Nil(t, err)
+
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		pc.Policy = &Policy{Licenses: LicensePolicy{Deny: []string{"GPL-3.0-only"}}}
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+
+		// Adding a denied module fails and is rolled back
+		_, err = gpm.Add(context.Background(), &AddOptions{Path: "lib", URL: module.URL()})
+		assert.Equal(t, ErrLicensePolicy, Code(err))
+
+		_, err = os.Stat(filepath.Join(projectDir, "lib"))
+		assert.True(t, os.IsNotExist(err))
+
+		// Check reports denied modules
+		pc.Policy = nil
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+		_, err = gpm.Add(context.Background(), &AddOptions{Path: "lib", URL: module.URL()})
+		assert.Nil(t, err)
+
+		pc, err = gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		pc.Policy = &Policy{Licenses: LicensePolicy{Deny: []string{"GPL-3.0-only"}}}
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+
+		checks, err := gpm.Check(context.Background(), &CheckOptions{})
+		assert.Equal(t, ErrLicensePolicy, Code(err))
+		assert.Equal(t, []LicenseCheck{{Path: "lib", License: "GPL-3.0-only", Allowed: false}}, checks)
+	})
+	t.Run("Applies policies to full license texts", func(t *testing.T) {
+		gpmtest.RequireGit(t)
+
+		testDir, err := ioutil.TempDir("", "gpm-test")
+		if err != nil {
+			t.FailNow()
+		}
+		defer os.RemoveAll(testDir)
+
+		// GPL-3.0 and MPL-2.0 licenses both reference the AGPL-3.0 in their bodies
+		modules := make(map[string]*gpmtest.Repo)
+		for _, name := range []string{"GPL-3.0", "MPL-2.0"} {
+			license, err := ioutil.ReadFile(filepath.Join("testdata", "licenses", name))
+			assert.Nil(t, err)
+
+			modules[name] = gpmtest.NewRepo(t, filepath.Join(testDir, name))
+			modules[name].Commit("Initial commit", map[string]string{"LICENSE": string(license)})
+			modules[name].Tag("v0.1.0", gpmtest.Annotated)
+		}
+
+		projectDir := filepath.Join(testDir, "project")
+		assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+		gpm := GPM{options: &CommonOptions{BasePath: projectDir}}
+		ctx := context.Background()
+
+		_, err = gpm.Init(ctx, &InitOptions{Name: "Test Project", Repository: "https://github.com/ryankurte/test-repo"})
+		assert.Nil(t, err)
+
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		pc.Policy = &Policy{Licenses: LicensePolicy{Allow: []string{"GPL-3.0-only", "MPL-2.0"}, Deny: []string{"AGPL-3.0-only"}}}
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+
+		_, err = gpm.Add(ctx, &AddOptions{Path: "lib/gpl", URL: modules["GPL-3.0"].URL()})
+		assert.Nil(t, err)
+		_, err = gpm.Add(ctx, &AddOptions{Path: "lib/mpl", URL: modules["MPL-2.0"].URL()})
+		assert.Nil(t, err)
+
+		checks, err := gpm.Check(ctx, &CheckOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, []LicenseCheck{
+			{Path: "lib/gpl", License: "GPL-3.0-only", Allowed: true},
+			{Path: "lib/mpl", License: "MPL-2.0", Allowed: true},
+		}, checks)
+	})
+}
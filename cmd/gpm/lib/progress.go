@@ -0,0 +1,129 @@
+package gpm
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// Logger is the interface used for GPM log outputs, compatible with *log.Logger
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// stdLogger writes log outputs using the standard library logger
+type stdLogger struct{}
+
+// Printf writes a formatted log output using the standard library logger
+func (stdLogger) Printf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// ProgressEventType identifies the stage of a module operation reported in a ProgressEvent
+type ProgressEventType int
+
+// Progress event types
+const (
+	ProgressCloneStarted    ProgressEventType = iota // Module clone started
+	ProgressFetchStarted                             // Module fetch started
+	ProgressObjectsReceived                          // Remote object transfer progress
+	ProgressCheckoutDone                             // Module worktree checked out
+	ProgressRetrying                                 // Module clone or fetch failed and will be retried
+)
+
+var progressEventNames = map[ProgressEventType]string{
+	ProgressCloneStarted:    "clone-started",
+	ProgressFetchStarted:    "fetch-started",
+	ProgressObjectsReceived: "objects-received",
+	ProgressCheckoutDone:    "checkout-done",
+	ProgressRetrying:        "retrying",
+}
+
+// String fetches the machine readable name for a progress event type
+func (t ProgressEventType) String() string {
+	return progressEventNames[t]
+}
+
+// ProgressEvent describes the progress of an operation on a module
+type ProgressEvent struct {
+	Type    ProgressEventType
+	Path    string // Module path
+	URL     string // Module URL, for clone and fetch events
+	Hash    string // Commit hash, for checkout events
+	Stage   string // Remote stage (ie. "Counting objects"), for object events
+	Current int    // Objects processed in the current stage, for object events
+	Total   int    // Total objects in the current stage, for object events
+	Retry   int    // Retry number, for retry events
+	Err     error  // Error causing the retry, for retry events
+}
+
+// Progress receives progress events for module operations
+type Progress interface {
+	OnProgress(e ProgressEvent)
+}
+
+// ProgressFunc adapts a function to the Progress interface
+type ProgressFunc func(e ProgressEvent)
+
+// OnProgress calls the underlying function with the progress event
+func (f ProgressFunc) OnProgress(e ProgressEvent) {
+	f(e)
+}
+
+// emitProgress reports a progress event if a progress receiver is configured
+func (gpm *GPM) emitProgress(e ProgressEvent) {
+	if gpm.progress != nil {
+		gpm.progress.OnProgress(e)
+	}
+}
+
+// repoPath fetches the project relative module path for a repo
+func (gpm *GPM) repoPath(repo *Repo) string {
+	if rel, err := filepath.Rel(gpm.options.BasePath, repo.path); err == nil {
+		return rel
+	}
+	return repo.path
+}
+
+// progressLine matches git remote progress messages, ie. "Counting objects:  50% (5/10)"
+var progressLine = regexp.MustCompile(`^(?:remote: )?([A-Za-z ]+):\s+\d+% \((\d+)/(\d+)\)`)
+
+// progressWriter parses git sideband progress messages into object progress events
+type progressWriter struct {
+	path     string
+	progress Progress
+	buff     []byte
+}
+
+// Write buffers sideband progress output, emitting an event for each complete progress line
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buff = append(w.buff, p...)
+
+	for {
+		i := bytes.IndexAny(w.buff, "\r\n")
+		if i < 0 {
+			break
+		}
+		line := string(w.buff[:i])
+		w.buff = w.buff[i+1:]
+
+		m := progressLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		current, _ := strconv.Atoi(m[2])
+		total, _ := strconv.Atoi(m[3])
+
+		w.progress.OnProgress(ProgressEvent{
+			Type:    ProgressObjectsReceived,
+			Path:    w.path,
+			Stage:   m[1],
+			Current: current,
+			Total:   total,
+		})
+	}
+
+	return len(p), nil
+}
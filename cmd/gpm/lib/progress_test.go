@@ -0,0 +1,142 @@
+package gpm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLogger collects log outputs for testing
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestProgress(t *testing.T) {
+
+	t.Run("Parses remote progress messages", func(t *testing.T) {
+		events := make([]ProgressEvent, 0)
+		w := progressWriter{path: "lib", progress: ProgressFunc(func(e ProgressEvent) {
+			events = append(events, e)
+		})}
+
+		w.Write([]byte("Enumerating objects: 12, done.\nCounting objects:  50% (5/10)\rCounting obj"))
+		w.Write([]byte("ects: 100% (10/10), done.\nremote: Compressing objects:  25% (1/4)\r"))
+
+		assert.EqualValues(t, []ProgressEvent{
+			{Type: ProgressObjectsReceived, Path: "lib", Stage: "Counting objects", Current: 5, Total: 10},
+			{Type: ProgressObjectsReceived, Path: "lib", Stage: "Counting objects", Current: 10, Total: 10},
+			{Type: ProgressObjectsReceived, Path: "lib", Stage: "Compressing objects", Current: 1, Total: 4},
+		}, events)
+	})
+
+	t.Run("Names progress event types", func(t *testing.T) {
+		assert.EqualValues(t, "clone-started", ProgressCloneStarted.String())
+		assert.EqualValues(t, "checkout-done", ProgressCheckoutDone.String())
+	})
+
+	gpmtest.RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0")
+	bare, hashes := module.URL(), module.Hashes
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+	events := make([]ProgressEvent, 0)
+	logger := testLogger{}
+
+	o := CommonOptions{BasePath: projectDir, Verbose: true}
+	gpm := NewGPM(&o).WithLogger(&logger).WithProgress(ProgressFunc(func(e ProgressEvent) {
+		events = append(events, e)
+	}))
+
+	pc := ProjectConfig{Name: "project"}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+	assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+	t.Run("Reports progress when adding modules", func(t *testing.T) {
+		_, err := gpm.Add(context.Background(), &AddOptions{Path: "lib", URL: bare})
+		assert.Nil(t, err)
+
+		if assert.True(t, len(events) >= 2) {
+			assert.EqualValues(t, ProgressEvent{Type: ProgressCloneStarted, Path: "lib", URL: bare}, events[0])
+			assert.EqualValues(t, ProgressEvent{Type: ProgressCheckoutDone, Path: "lib", Hash: hashes["v0.2.0"]}, events[len(events)-1])
+		}
+	})
+
+	t.Run("Reports progress when syncing modules", func(t *testing.T) {
+		events = events[:0]
+
+		_, err := gpm.Sync(context.Background(), &SyncOptions{})
+		assert.Nil(t, err)
+
+		if assert.True(t, len(events) >= 2) {
+			assert.EqualValues(t, ProgressEvent{Type: ProgressFetchStarted, Path: "lib", URL: bare}, events[0])
+			assert.EqualValues(t, ProgressEvent{Type: ProgressCheckoutDone, Path: "lib", Hash: hashes["v0.2.0"]}, events[len(events)-1])
+		}
+	})
+
+	t.Run("Writes logs to the injected logger", func(t *testing.T) {
+		assert.Contains(t, strings.Join(logger.lines, "\n"), "Adding dependency 'lib'")
+	})
+
+	t.Run("Canceled commands roll back changes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Cancel once the clone has started
+		gpm.WithProgress(ProgressFunc(func(e ProgressEvent) {
+			if e.Type == ProgressCloneStarted {
+				cancel()
+			}
+		}))
+		defer gpm.WithProgress(nil)
+
+		_, err := gpm.Add(ctx, &AddOptions{Path: "canceled", URL: bare})
+		assert.EqualValues(t, ErrCanceled, Code(err))
+
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		_, ok := pc.Dependencies.Find("canceled")
+		assert.False(t, ok)
+
+		_, err = os.Stat(filepath.Join(projectDir, "canceled"))
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(projectDir, ProjectLockName))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Canceled commands stop waiting for the project lock", func(t *testing.T) {
+		lockPath := filepath.Join(projectDir, ProjectLockName)
+		assert.Nil(t, acquireLock(context.Background(), lockPath, 0))
+		defer os.Remove(lockPath)
+
+		o.LockTimeout = time.Minute
+		defer func() { o.LockTimeout = 0 }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		_, err := gpm.Sync(ctx, &SyncOptions{})
+		assert.EqualValues(t, ErrCanceled, Code(err))
+	})
+}
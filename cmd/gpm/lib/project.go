@@ -2,15 +2,24 @@ package gpm
 
 // ProjectConfig is a project configuration object from a project file
 type ProjectConfig struct {
-	Name         string            `yaml:",omitempty"` // Project name
-	License      string            `yaml:",omitempty"` // Project license (https://spdx.org/licenses/)
-	Repository   string            `yaml:",omitempty"` // Project home repository
-	Homepage     string            `yaml:",omitempty"` // Project homepage
-	Meta         map[string]string `yaml:",omitempty"` // Project metadata
-	Dependencies Dependencies      `yaml:",omitempty"` // List of project dependencies
+	Name         string            `yaml:",omitempty" toml:"name,omitempty" json:"name,omitempty"`                 // Project name
+	License      string            `yaml:",omitempty" toml:"license,omitempty" json:"license,omitempty"`           // Project license (https://spdx.org/licenses/)
+	Repository   string            `yaml:",omitempty" toml:"repository,omitempty" json:"repository,omitempty"`     // Project home repository
+	Homepage     string            `yaml:",omitempty" toml:"homepage,omitempty" json:"homepage,omitempty"`         // Project homepage
+	Meta         map[string]string `yaml:",omitempty" toml:"meta,omitempty" json:"meta,omitempty"`                 // Project metadata
+	Policy       *Policy           `yaml:",omitempty" toml:"policy,omitempty" json:"policy,omitempty"`             // Dependency policies
+	Indexes      []string          `yaml:",omitempty" toml:"indexes,omitempty" json:"indexes,omitempty"`           // Package indexes (git URLs or local directories) resolving package names
+	Dependencies Dependencies      `yaml:",omitempty" toml:"dependencies,omitempty" json:"dependencies,omitempty"` // List of project dependencies
 }
 
 const (
 	// ProjectConfigName is the default project config file
 	ProjectConfigName = ".gpm.yml"
+	// ProjectConfigTOMLName is the project config file in TOML format
+	ProjectConfigTOMLName = "gpm.toml"
+	// ProjectConfigJSONName is the project config file in JSON format
+	ProjectConfigJSONName = "gpm.json"
 )
+
+// ProjectConfigNames are the supported project config files
+var ProjectConfigNames = []string{ProjectConfigName, ProjectConfigTOMLName, ProjectConfigJSONName}
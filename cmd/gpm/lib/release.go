@@ -0,0 +1,343 @@
+package gpm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Release version bumps
+const (
+	BumpMajor = "major"
+	BumpMinor = "minor"
+	BumpPatch = "patch"
+)
+
+const (
+	// SigningPassphraseEnv is the environment variable used to decrypt release signing keys
+	SigningPassphraseEnv = "GPM_SIGNING_PASSPHRASE"
+)
+
+// Release describes a project release tag
+type Release struct {
+	Tag      string   `json:"tag"`
+	Previous string   `json:"previous,omitempty"`
+	Hash     string   `json:"hash"`
+	Changes  []Change `json:"changes"`
+	Created  bool     `json:"created"`
+}
+
+// Change is a commit included in a release
+type Change struct {
+	Hash    string `json:"hash"`
+	Summary string `json:"summary"`
+}
+
+// String formats a release as a human readable change log
+func (r Release) String() string {
+	previous := r.Previous
+	if previous == "" {
+		previous = "initial release"
+	}
+
+	s := fmt.Sprintf("%s (%s, since %s)\n", r.Tag, shortHash(r.Hash), previous)
+	for _, c := range r.Changes {
+		s += fmt.Sprintf("  - %s %s\n", shortHash(c.Hash), c.Summary)
+	}
+	if !r.Created {
+		s += "(dry run, tag not created)\n"
+	}
+
+	return s
+}
+
+// Release validates the project and creates an annotated semver tag for the current commit
+func (gpm *GPM) Release(ctx context.Context, ro *ReleaseOptions) (*Release, error) {
+	r, err := git.PlainOpen(gpm.options.BasePath)
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error opening project repository '%s'", gpm.options.BasePath)
+	}
+
+	// Validate project and dependency locks
+	if err := gpm.validateRelease(ctx); err != nil {
+		return nil, err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error reading project HEAD")
+	}
+
+	// Compute the next version from the tags in the history of HEAD, so releases from
+	// maintenance branches follow the previous release on the branch
+	tags, err := NewTagsFromRepo(r)
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error reading project tags")
+	}
+	released, err := ancestorTags(r, head.Hash(), tags)
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error reading project history")
+	}
+	previous, previousHash, err := released.GetLatest("")
+	if err != nil {
+		return nil, wrapError(ErrInvalidVersion, err, "Error reading project tags")
+	}
+	tag, err := nextVersion(previous, ro.Args.Bump, ro.Prerelease)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tags[tag]; ok {
+		return nil, errorf(ErrInvalidVersion, "Release tag '%s' already exists", tag)
+	}
+
+	// Build change log since the previous release
+	changes, err := changeLog(r, head.Hash(), previousHash)
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error reading project history")
+	}
+
+	release := Release{Tag: tag, Previous: previous, Hash: head.Hash().String(), Changes: changes}
+
+	if gpm.options.Verbose {
+		gpm.logf("Release (%s) previous: '%s' commits: %d", tag, previous, len(changes))
+	}
+
+	if ro.DryRun {
+		return &release, nil
+	}
+
+	// Create annotated (and optionally signed) tag
+	opts := git.CreateTagOptions{
+		Tagger:  tagger(r),
+		Message: releaseMessage(&release),
+	}
+	if ro.SignKey != "" {
+		key, err := loadSigningKey(ro.SignKey)
+		if err != nil {
+			return nil, err
+		}
+		opts.SignKey = key
+	}
+
+	if _, err := r.CreateTag(tag, head.Hash(), &opts); err != nil {
+		return nil, wrapError(ErrRepository, err, "Error creating release tag '%s'", tag)
+	}
+	release.Created = true
+
+	return &release, nil
+}
+
+// validateRelease checks the project config is valid and all dependencies are locked to tagged commits
+func (gpm *GPM) validateRelease(ctx context.Context) error {
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return err
+	}
+	locks, err := gpm.loadLockfile()
+	if err != nil {
+		return err
+	}
+
+	if pc.Name == "" || pc.Repository == "" {
+		return errorf(ErrProjectConfig, "Project config must include a project name and repository")
+	}
+
+	for _, d := range pc.Dependencies {
+		if d.Path == "" || d.URL == "" {
+			return errorf(ErrProjectConfig, "Dependency path and url fields cannot be empty")
+		}
+		if d.Version != "" {
+			if _, err := semver.NewConstraint(d.Version); err != nil {
+				return wrapError(ErrInvalidVersion, err, "Invalid version range '%s' for module '%s'", d.Version, d.Path)
+			}
+		}
+	}
+
+	modules, err := gpm.lockedModules(ctx, &pc, locks)
+	if err != nil {
+		return err
+	}
+	for _, m := range modules {
+		if m.Tag == "" {
+			return errorf(ErrMissingLock, "Module '%s' is not locked to a tagged commit (or has not been synced)", m.Path)
+		}
+	}
+
+	return nil
+}
+
+// nextVersion computes the next release tag from the previous tag and a bump
+// (major, minor, patch or an explicit version), preserving the tag prefix
+func nextVersion(previous, bump, prerelease string) (string, error) {
+	prefix := "v"
+	current := semver.MustParse("0.0.0")
+	if previous != "" {
+		v, err := semver.NewVersion(previous)
+		if err != nil {
+			return "", wrapError(ErrInvalidVersion, err, "Invalid previous version '%s'", previous)
+		}
+		current = v
+		if !strings.HasPrefix(previous, "v") {
+			prefix = ""
+		}
+	}
+
+	var next semver.Version
+	switch bump {
+	case BumpMajor:
+		next = current.IncMajor()
+	case BumpMinor:
+		next = current.IncMinor()
+	case BumpPatch, "":
+		next = current.IncPatch()
+	default:
+		v, err := semver.NewVersion(bump)
+		if err != nil {
+			return "", wrapError(ErrInvalidVersion, err, "Invalid release bump '%s' (expected major, minor, patch or a version)", bump)
+		}
+		if previous != "" && !v.GreaterThan(current) {
+			return "", errorf(ErrInvalidVersion, "Release version '%s' must be greater than '%s'", bump, previous)
+		}
+		next = *v
+	}
+
+	if prerelease != "" {
+		v, err := next.SetPrerelease(prerelease)
+		if err != nil {
+			return "", wrapError(ErrInvalidVersion, err, "Invalid prerelease '%s'", prerelease)
+		}
+		next = v
+	}
+
+	return prefix + next.String(), nil
+}
+
+// changeLog lists the commits reachable from head but not from the previous release,
+// excluding all ancestors of the previous release so merged branches are included
+func changeLog(r *git.Repository, head plumbing.Hash, previous string) ([]Change, error) {
+	// Collect commits in the previous release
+	released := make(map[plumbing.Hash]bool)
+	if previous != "" {
+		iter, err := r.Log(&git.LogOptions{From: plumbing.NewHash(previous)})
+		if err != nil {
+			return nil, err
+		}
+		err = iter.ForEach(func(c *object.Commit) error {
+			released[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes := make([]Change, 0)
+
+	commit, err := r.CommitObject(head)
+	if err != nil {
+		return nil, err
+	}
+	if released[head] {
+		return changes, nil
+	}
+
+	err = object.NewCommitPreorderIter(commit, released, nil).ForEach(func(c *object.Commit) error {
+		summary := strings.SplitN(strings.TrimSpace(c.Message), "\n", 2)[0]
+		changes = append(changes, Change{Hash: c.Hash.String(), Summary: summary})
+		return nil
+	})
+
+	return changes, err
+}
+
+// ancestorTags selects the tags for commits in the history of the provided commit,
+// stopping at the boundary of shallow clones
+func ancestorTags(r *git.Repository, head plumbing.Hash, tags Tags) (Tags, error) {
+	commit, err := r.CommitObject(head)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make(map[plumbing.Hash]bool)
+	err = object.NewCommitPreorderIter(commit, history, nil).ForEach(func(c *object.Commit) error {
+		history[c.Hash] = true
+		return nil
+	})
+	if err != nil && err != plumbing.ErrObjectNotFound {
+		return nil, err
+	}
+
+	ancestors := make(Tags)
+	for t, h := range tags {
+		if history[plumbing.NewHash(h)] {
+			ancestors[t] = h
+		}
+	}
+	return ancestors, nil
+}
+
+// releaseMessage builds the annotated tag message for a release
+func releaseMessage(release *Release) string {
+	msg := fmt.Sprintf("Release %s\n\n", release.Tag)
+	for _, c := range release.Changes {
+		msg += fmt.Sprintf("- %s %s\n", shortHash(c.Hash), c.Summary)
+	}
+	return msg
+}
+
+// tagger builds the release tag signature from the repository user config or git environment variables
+func tagger(r *git.Repository) *object.Signature {
+	sig := object.Signature{Name: os.Getenv("GIT_COMMITTER_NAME"), Email: os.Getenv("GIT_COMMITTER_EMAIL"), When: time.Now()}
+
+	if cfg, err := r.Config(); err == nil {
+		user := cfg.Raw.Section("user")
+		if name := user.Option("name"); name != "" {
+			sig.Name = name
+		}
+		if email := user.Option("email"); email != "" {
+			sig.Email = email
+		}
+	}
+
+	if sig.Name == "" {
+		sig.Name = "gpm"
+	}
+
+	return &sig
+}
+
+// loadSigningKey loads an armored PGP private key for signing release tags, decrypting
+// it with the passphrase from the GPM_SIGNING_PASSPHRASE environment variable if required
+func loadSigningKey(file string) (*openpgp.Entity, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, wrapError(ErrFilesystem, err, "Error opening signing key '%s'", file)
+	}
+	defer f.Close()
+
+	keys, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, wrapError(ErrInvalidOptions, err, "Error reading signing key '%s'", file)
+	}
+	if len(keys) == 0 || keys[0].PrivateKey == nil {
+		return nil, errorf(ErrInvalidOptions, "No private key found in '%s'", file)
+	}
+
+	key := keys[0]
+	if key.PrivateKey.Encrypted {
+		passphrase := []byte(os.Getenv(SigningPassphraseEnv))
+		if err := key.PrivateKey.Decrypt(passphrase); err != nil {
+			return nil, wrapError(ErrInvalidOptions, err, "Error decrypting signing key '%s' (set %s)", file, SigningPassphraseEnv)
+		}
+	}
+
+	return key, nil
+}
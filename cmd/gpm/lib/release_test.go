@@ -0,0 +1,177 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelease(t *testing.T) {
+
+	t.Run("Computes next versions", func(t *testing.T) {
+		tests := []struct {
+			previous, bump, pre string
+			expected            string
+			err                 bool
+		}{
+			{"", "", "", "v0.0.1", false},
+			{"", "minor", "", "v0.1.0", false},
+			{"v1.2.3", "patch", "", "v1.2.4", false},
+			{"v1.2.3", "minor", "", "v1.3.0", false},
+			{"v1.2.3", "major", "", "v2.0.0", false},
+			{"1.2.3", "patch", "", "1.2.4", false},
+			{"v1.2.3", "minor", "rc.1", "v1.3.0-rc.1", false},
+			{"v1.3.0-rc.1", "patch", "", "v1.3.0", false},
+			{"v1.2.3", "v1.5.0", "", "v1.5.0", false},
+			{"v1.2.3", "v1.0.0", "", "", true},
+			{"v1.2.3", "huge", "", "", true},
+		}
+
+		for _, test := range tests {
+			next, err := nextVersion(test.previous, test.bump, test.pre)
+			if test.err {
+				assert.NotNil(t, err, "%+v", test)
+				assert.EqualValues(t, ErrInvalidVersion, Code(err))
+				continue
+			}
+			assert.Nil(t, err, "%+v", test)
+			assert.EqualValues(t, test.expected, next, "%+v", test)
+		}
+	})
+
+	gpmtest.RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	// Create a module repository with a tagged and an untagged commit
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "untagged")
+	untaggedHash := module.Hashes["untagged"]
+
+	// Create a project depending on the tagged module
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+	gpmtest.Git(t, projectDir, "init", "-q")
+	gpmtest.Git(t, projectDir, "config", "user.name", "gpm")
+	gpmtest.Git(t, projectDir, "config", "user.email", "gpm@example.com")
+
+	o := CommonOptions{BasePath: projectDir, Verbose: true}
+	gpm := GPM{options: &o}
+
+	_, err = gpm.Init(context.Background(), &InitOptions{Name: "project", Repository: "https://github.com/ryankurte/test-project"})
+	assert.Nil(t, err)
+	_, err = gpm.Add(context.Background(), &AddOptions{Path: "lib/module", URL: module.URL(), Version: "^0.1.0"})
+	assert.Nil(t, err)
+
+	gpmtest.Git(t, projectDir, "add", ProjectConfigName, LockfileName)
+	gpmtest.Git(t, projectDir, "commit", "-q", "-m", "Add module")
+
+	t.Run("Dry runs do not create tags", func(t *testing.T) {
+		ro := ReleaseOptions{DryRun: true}
+		release, err := gpm.Release(context.Background(), &ro)
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, "v0.0.1", release.Tag)
+		assert.False(t, release.Created)
+		assert.EqualValues(t, "", gpmtest.Git(t, projectDir, "tag", "-l"))
+	})
+
+	t.Run("Creates annotated release tags", func(t *testing.T) {
+		ro := ReleaseOptions{}
+		release, err := gpm.Release(context.Background(), &ro)
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, "v0.0.1", release.Tag)
+		assert.EqualValues(t, "", release.Previous)
+		assert.True(t, release.Created)
+		if assert.EqualValues(t, 1, len(release.Changes)) {
+			assert.EqualValues(t, "Add module", release.Changes[0].Summary)
+		}
+
+		// Check the tag is annotated with the change log
+		assert.EqualValues(t, "tag", gpmtest.Git(t, projectDir, "cat-file", "-t", "v0.0.1"))
+		assert.Contains(t, gpmtest.Git(t, projectDir, "tag", "-l", "-n9", "v0.0.1"), "Add module")
+	})
+
+	t.Run("Lists changes since the previous release", func(t *testing.T) {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(projectDir, "README.md"), []byte("test"), 0644))
+		gpmtest.Git(t, projectDir, "add", "README.md")
+		gpmtest.Git(t, projectDir, "commit", "-q", "-m", "Add readme\n\nWith details")
+
+		ro := ReleaseOptions{}
+		ro.Args.Bump = BumpMinor
+		release, err := gpm.Release(context.Background(), &ro)
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, "v0.1.0", release.Tag)
+		assert.EqualValues(t, "v0.0.1", release.Previous)
+		if assert.EqualValues(t, 1, len(release.Changes)) {
+			assert.EqualValues(t, "Add readme", release.Changes[0].Summary)
+		}
+	})
+
+	t.Run("Rejects existing versions", func(t *testing.T) {
+		ro := ReleaseOptions{}
+		ro.Args.Bump = "v0.1.0"
+		_, err := gpm.Release(context.Background(), &ro)
+		assert.EqualValues(t, ErrInvalidVersion, Code(err))
+	})
+
+	t.Run("Lists changes merged from branches of earlier commits", func(t *testing.T) {
+		gpmtest.Git(t, projectDir, "checkout", "-q", "-b", "feature", "v0.0.1")
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(projectDir, "FEATURE.md"), []byte("feature"), 0644))
+		gpmtest.Git(t, projectDir, "add", "FEATURE.md")
+		gpmtest.Git(t, projectDir, "commit", "-q", "-m", "Add feature")
+		gpmtest.Git(t, projectDir, "checkout", "-q", "-")
+		gpmtest.Git(t, projectDir, "merge", "-q", "--no-ff", "-m", "Merge feature", "feature")
+
+		ro := ReleaseOptions{DryRun: true}
+		release, err := gpm.Release(context.Background(), &ro)
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, "v0.1.0", release.Previous)
+		summaries := make([]string, 0)
+		for _, c := range release.Changes {
+			summaries = append(summaries, c.Summary)
+		}
+		assert.ElementsMatch(t, []string{"Merge feature", "Add feature"}, summaries)
+	})
+
+	t.Run("Releases from maintenance branches", func(t *testing.T) {
+		gpmtest.Git(t, projectDir, "checkout", "-q", "-b", "maintenance", "v0.0.1")
+		defer gpmtest.Git(t, projectDir, "checkout", "-q", "-")
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(projectDir, "FIX.md"), []byte("fix"), 0644))
+		gpmtest.Git(t, projectDir, "add", "FIX.md")
+		gpmtest.Git(t, projectDir, "commit", "-q", "-m", "Add fix")
+
+		ro := ReleaseOptions{DryRun: true}
+		release, err := gpm.Release(context.Background(), &ro)
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, "v0.0.2", release.Tag)
+		assert.EqualValues(t, "v0.0.1", release.Previous)
+		if assert.EqualValues(t, 1, len(release.Changes)) {
+			assert.EqualValues(t, "Add fix", release.Changes[0].Summary)
+		}
+	})
+
+	t.Run("Rejects dependencies locked to untagged commits", func(t *testing.T) {
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		locks["lib/module"] = Lock{Hash: untaggedHash, URL: module.URL()}
+		assert.Nil(t, gpm.writeLockfile(&locks))
+
+		ro := ReleaseOptions{}
+		_, err = gpm.Release(context.Background(), &ro)
+		assert.EqualValues(t, ErrMissingLock, Code(err))
+	})
+}
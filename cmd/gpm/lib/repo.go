@@ -1,20 +1,60 @@
 package gpm
 
 import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
 	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	gitioutil "gopkg.in/src-d/go-git.v4/utils/ioutil"
+)
+
+const (
+	// DefaultCloneDepth is the number of commits fetched from each ref when cloning modules
+	DefaultCloneDepth = 1
+	// MaxDeepenDepth is the depth above which deepening falls back to fetching the full history
+	MaxDeepenDepth = 256
 )
 
 type Repo struct {
 	path       string
 	url        string
+	depth      int
+	submodules bool
+	lfs        string
+	progress   io.Writer
+	retry      RetryPolicy
 	repository *git.Repository
 }
 
 // NewRepo creates a new repo instance
 func NewRepo(path, url string) *Repo {
-	return &Repo{path: path, url: url, repository: nil}
+	return &Repo{path: path, url: url, depth: DefaultCloneDepth, repository: nil}
+}
+
+// WithDepth sets the clone depth for a repo, where a depth of 0 fetches the full history
+func (repo *Repo) WithDepth(depth int) *Repo {
+	repo.depth = depth
+	return repo
 }
 
 // Exists checks if a repo exists on disk
@@ -34,17 +74,244 @@ func (repo *Repo) Exists() bool {
 	return true
 }
 
-// Clone populates a new repo on disk
-func (repo *Repo) Clone() error {
-	cloneOpts := git.CloneOptions{URL: repo.url, Depth: 1, Tags: git.AllTags}
-	r, err := git.PlainClone(repo.path, false, &cloneOpts)
+// WithSubmodules enables checking out submodules at their recorded commits when syncing the repo
+func (repo *Repo) WithSubmodules(submodules bool) *Repo {
+	repo.submodules = submodules
+	return repo
+}
+
+// WithLFS enables fetching LFS objects from the provided endpoint when syncing the repo
+func (repo *Repo) WithLFS(endpoint string) *Repo {
+	repo.lfs = endpoint
+	return repo
+}
+
+// WithProgress sets the writer receiving remote progress messages when cloning or fetching the repo
+func (repo *Repo) WithProgress(progress io.Writer) *Repo {
+	repo.progress = progress
+	return repo
+}
+
+// WithRetry sets the timeout and retry policy used when cloning or fetching the repo
+func (repo *Repo) WithRetry(retry RetryPolicy) *Repo {
+	repo.retry = retry
+	return repo
+}
+
+// Clone populates a new repo on disk, fetching the configured depth of history for
+// each branch and tag
+func (repo *Repo) Clone(ctx context.Context) error {
+	_, statErr := os.Stat(repo.path)
+
+	return repo.retry.Do(ctx, func(ctx context.Context) error {
+		cloneOpts := git.CloneOptions{URL: repo.url, Depth: repo.depth, Tags: git.AllTags, Progress: repo.progress}
+		r, err := git.PlainCloneContext(ctx, repo.path, false, &cloneOpts)
+		if err != nil {
+			// Remove partial clones so the clone can be retried
+			if os.IsNotExist(statErr) {
+				os.RemoveAll(repo.path)
+			}
+			return err
+		}
+		repo.repository = r
+		return nil
+	})
+}
+
+// HasCommit checks whether a commit is available in the local repository
+func (repo *Repo) HasCommit(hash string) bool {
+	_, err := repo.repository.CommitObject(plumbing.NewHash(hash))
+	return err == nil
+}
+
+// Shallow checks whether the local repository has truncated history
+func (repo *Repo) Shallow() bool {
+	shallows, err := repo.repository.Storer.Shallow()
+	return err == nil && len(shallows) > 0
+}
+
+// Deepen fetches additional history for a shallow repository until the provided commit
+// is available, increasing the depth on each attempt and falling back to the full history.
+// History is fetched into the existing repository, which is never replaced, and modified
+// repositories are refused.
+func (repo *Repo) Deepen(ctx context.Context, hash string) error {
+	if !repo.Shallow() {
+		return fmt.Errorf("commit '%s' not found in repository '%s'", hash, repo.url)
+	}
+	if err := repo.requireUnmodified(); err != nil {
+		return err
+	}
+
+	depth := repo.depth
+	if depth < DefaultCloneDepth {
+		depth = DefaultCloneDepth
+	}
+
+	for {
+		depth *= 4
+		if depth > MaxDeepenDepth {
+			depth = 0
+		}
+
+		if err := repo.deepen(ctx, hash, depth); err != nil {
+			return err
+		}
+		if repo.HasCommit(hash) {
+			return nil
+		}
+		if depth == 0 {
+			return fmt.Errorf("commit '%s' not found in repository '%s'", hash, repo.url)
+		}
+	}
+}
+
+// Unshallow fetches the full history for a shallow repository, refusing modified repositories
+func (repo *Repo) Unshallow(ctx context.Context) error {
+	if !repo.Shallow() {
+		return nil
+	}
+	if err := repo.requireUnmodified(); err != nil {
+		return err
+	}
+	return repo.deepen(ctx, "", 0)
+}
+
+// requireUnmodified refuses to operate on repositories with uncommitted or untracked changes
+func (repo *Repo) requireUnmodified() error {
+	modified, err := repo.Modified()
 	if err != nil {
 		return err
 	}
-	repo.repository = r
+	if modified {
+		return errorf(ErrDirtyModule, "Repository '%s' has uncommitted or untracked changes", repo.path)
+	}
 	return nil
 }
 
+// deepen fetches history to the provided depth (or the full history for a depth of 0) into
+// the existing repository, sending the current shallow commits so only missing history is
+// transferred. The commit is requested directly where the remote permits, otherwise the
+// branches and tags of the remote are deepened.
+//
+// This performs the upload-pack exchange directly, as go-git fetches neither send the
+// shallow commits of the repository nor request history for commits already present.
+func (repo *Repo) deepen(ctx context.Context, hash string, depth int) error {
+	return repo.retry.Do(ctx, func(ctx context.Context) (err error) {
+		ep, err := transport.NewEndpoint(repo.url)
+		if err != nil {
+			return err
+		}
+		c, err := client.NewClient(ep)
+		if err != nil {
+			return err
+		}
+		session, err := c.NewUploadPackSession(ep, nil)
+		if err != nil {
+			return err
+		}
+		defer gitioutil.CheckClose(session, &err)
+
+		ar, err := session.AdvertisedReferences()
+		if err != nil {
+			return err
+		}
+
+		req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
+		if err := req.Capabilities.Set(capability.Shallow); err != nil {
+			return err
+		}
+		if repo.progress == nil && ar.Capabilities.Supports(capability.NoProgress) {
+			req.Capabilities.Set(capability.NoProgress)
+		}
+
+		// Unshallowing deepens to the maximum depth, as with `git fetch --unshallow`
+		req.Depth = packp.DepthCommits(depth)
+		if depth == 0 {
+			req.Depth = packp.DepthCommits(math.MaxInt32)
+		}
+		if req.Shallows, err = repo.repository.Storer.Shallow(); err != nil {
+			return err
+		}
+
+		if hash != "" && ar.Capabilities.Supports(capability.AllowReachableSHA1InWant) {
+			req.Wants = []plumbing.Hash{plumbing.NewHash(hash)}
+		} else {
+			refs, err := ar.AllReferences()
+			if err != nil {
+				return err
+			}
+			seen := make(map[plumbing.Hash]bool)
+			for _, ref := range refs {
+				if ref.Type() != plumbing.HashReference || seen[ref.Hash()] || !(ref.Name().IsBranch() || ref.Name().IsTag()) {
+					continue
+				}
+				seen[ref.Hash()] = true
+				req.Wants = append(req.Wants, ref.Hash())
+			}
+		}
+		if len(req.Wants) == 0 {
+			return nil
+		}
+
+		res, err := session.UploadPack(ctx, req)
+		if err != nil {
+			return err
+		}
+		defer gitioutil.CheckClose(res, &err)
+
+		var reader io.Reader = res
+		switch {
+		case req.Capabilities.Supports(capability.Sideband64k):
+			reader = sideband.NewDemuxer(sideband.Sideband64k, res)
+		case req.Capabilities.Supports(capability.Sideband):
+			reader = sideband.NewDemuxer(sideband.Sideband, res)
+		}
+		if d, ok := reader.(*sideband.Demuxer); ok && repo.progress != nil {
+			d.Progress = repo.progress
+		}
+
+		if err := packfile.UpdateObjectStorage(repo.repository.Storer, reader); err != nil {
+			return err
+		}
+
+		return repo.updateShallows(res.ShallowUpdate)
+	})
+}
+
+// updateShallows applies the shallow commits added and removed by a deepening fetch
+func (repo *Repo) updateShallows(update packp.ShallowUpdate) error {
+	current, err := repo.repository.Storer.Shallow()
+	if err != nil {
+		return err
+	}
+
+	removed := make(map[plumbing.Hash]bool)
+	for _, h := range update.Unshallows {
+		removed[h] = true
+	}
+
+	shallows := make([]plumbing.Hash, 0)
+	seen := make(map[plumbing.Hash]bool)
+	for _, h := range append(current, update.Shallows...) {
+		if removed[h] || seen[h] {
+			continue
+		}
+		seen[h] = true
+		shallows = append(shallows, h)
+	}
+
+	// git treats repositories with any shallow file as shallow, including empty files
+	if len(shallows) == 0 {
+		err := os.Remove(filepath.Join(gitDir(repo.path), "shallow"))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+
+	return repo.repository.Storer.SetShallow(shallows)
+}
+
 // Open loads a repo from the provided path
 func (repo *Repo) Open() error {
 	r, err := git.PlainOpen(repo.path)
@@ -56,56 +323,332 @@ func (repo *Repo) Open() error {
 	return nil
 }
 
-// Sync updates a repo and remotes
+// RemoteURL fetches the URL of the origin remote, returning an empty string if no origin exists
+func (repo *Repo) RemoteURL() string {
+	remote, err := repo.repository.Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+	return remote.Config().URLs[0]
+}
+
+// Sync updates the origin remote to match the repo URL, removing local tags that do
+// not exist on the updated remote
 func (repo *Repo) Sync() error {
-	_, err := repo.repository.Config()
-	if err != nil {
-		return err
+	if repo.RemoteURL() == repo.url {
+		return nil
 	}
 
 	// Update remote if mismatched
-	remote, err := repo.repository.Remote("origin")
 	origin := config.RemoteConfig{Name: "origin", URLs: []string{repo.url}}
-	origin.Validate()
-	if err == nil && remote.Config().URLs[0] != repo.url {
-		repo.repository.DeleteRemote("origin")
-		repo.repository.CreateRemote(&origin)
-	} else if err != nil {
-		repo.repository.CreateRemote(&origin)
+	if err := origin.Validate(); err != nil {
+		return err
+	}
+	if _, err := repo.repository.Remote("origin"); err == nil {
+		if err := repo.repository.DeleteRemote("origin"); err != nil {
+			return err
+		}
+	}
+	remote, err := repo.repository.CreateRemote(&origin)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	// Remove tags from the previous remote
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return err
+	}
+	remoteTags := make(map[plumbing.ReferenceName]bool)
+	for _, ref := range refs {
+		remoteTags[ref.Name()] = true
+	}
 
-// Fetch updates the tags in a given repo
-func (repo *Repo) Fetch() error {
-	err := repo.repository.Fetch(&git.FetchOptions{RemoteName: "origin", Tags: git.AllTags})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
+	tagIter, err := repo.repository.Tags()
+	if err != nil {
 		return err
 	}
+	stale := make([]plumbing.ReferenceName, 0)
+	tagIter.ForEach(func(ref *plumbing.Reference) error {
+		if !remoteTags[ref.Name()] {
+			stale = append(stale, ref.Name())
+		}
+		return nil
+	})
+	for _, name := range stale {
+		if err := repo.repository.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// RemoteContains checks whether a commit is reachable from the branches or tags of the
+// origin remote. Shallow repositories are deepened (up to the full history) until the commit
+// is found, so commits beyond the shallow boundary are not reported as missing.
+func (repo *Repo) RemoteContains(ctx context.Context, hash string) (bool, error) {
+	remote, err := repo.repository.Remote("origin")
+	if err != nil {
+		return false, err
+	}
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	target := plumbing.NewHash(hash)
+	depth := repo.depth
+	if depth < DefaultCloneDepth {
+		depth = DefaultCloneDepth
+	}
+
+	for {
+		found, err := repo.reachable(refs, target)
+		if err != nil || found || !repo.Shallow() || depth == 0 {
+			return found, err
+		}
+
+		depth *= 4
+		if depth > MaxDeepenDepth {
+			depth = 0
+		}
+		if err := repo.deepen(ctx, "", depth); err != nil {
+			return false, err
+		}
+	}
+}
+
+// reachable walks the history available in the local repository from the provided
+// references, stopping at the boundary of shallow clones
+func (repo *Repo) reachable(refs []*plumbing.Reference, target plumbing.Hash) (bool, error) {
+	seen := make(map[plumbing.Hash]bool)
+
+	for _, ref := range refs {
+		if ref.Type() != plumbing.HashReference {
+			continue
+		}
+
+		// Resolve annotated tags to the tagged commit
+		h := ref.Hash()
+		if t, err := repo.repository.TagObject(h); err == nil {
+			h = t.Target
+		}
+
+		commit, err := repo.repository.CommitObject(h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		found := false
+		err = object.NewCommitPreorderIter(commit, seen, nil).ForEach(func(c *object.Commit) error {
+			seen[c.Hash] = true
+			if c.Hash == target {
+				found = true
+				return storer.ErrStop
+			}
+			return nil
+		})
+		if found {
+			return true, nil
+		}
+		if err != nil && err != plumbing.ErrObjectNotFound {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// Fetch updates the tags in a given repo
+func (repo *Repo) Fetch(ctx context.Context) error {
+	return repo.retry.Do(ctx, func(ctx context.Context) error {
+		err := repo.repository.FetchContext(ctx, &git.FetchOptions{RemoteName: "origin", Tags: git.AllTags, Progress: repo.progress})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return err
+		}
+		return nil
+	})
+}
+
 // GetTags fetches semver compliant tags from a repo
 func (repo *Repo) GetTags() (Tags, error) {
 	return NewTagsFromRepo(repo.repository)
 }
 
-// SyncHash syncs a repo to a given commit hash
-func (repo *Repo) SyncHash(hash string) error {
-	// Checkout matching hash into worktree
+// Head fetches the commit hash currently checked out in the worktree
+func (repo *Repo) Head() (string, error) {
+	ref, err := repo.repository.Head()
+	if err != nil {
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
+
+// SyncHash syncs a repo to a given commit hash, including submodules and LFS objects if enabled
+func (repo *Repo) SyncHash(ctx context.Context, hash string) error {
+	// Checkout matching hash into worktree, discarding LFS objects from previous checkouts.
+	// Checkouts are only forced for unmodified worktrees, so local changes are never lost.
+	if repo.lfs != "" {
+		if err := repo.requireUnmodified(); err != nil {
+			return err
+		}
+	}
 	worktree, err := repo.repository.Worktree()
 	if err != nil {
 		return err
 	}
-	err = worktree.Checkout(&git.CheckoutOptions{Hash: plumbing.NewHash(hash)})
+	err = worktree.Checkout(&git.CheckoutOptions{Hash: plumbing.NewHash(hash), Force: repo.lfs != ""})
 	if err != nil {
 		return err
 	}
 
+	if repo.submodules {
+		if err := repo.updateSubmodules(ctx, worktree, repo.url, git.DefaultSubmoduleRecursionDepth); err != nil {
+			return err
+		}
+	}
+	if repo.lfs != "" {
+		if err := repo.fetchLFS(ctx); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// updateSubmodules checks out the submodules of a worktree at their recorded commits,
+// resolving relative submodule URLs against the parent repository URL
+func (repo *Repo) updateSubmodules(ctx context.Context, worktree *git.Worktree, parentURL string, depth git.SubmoduleRescursivity) error {
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range submodules {
+		s.Config().URL = resolveURL(parentURL, s.Config().URL)
+
+		err := s.UpdateContext(ctx, &git.SubmoduleUpdateOptions{Init: true})
+		if err != nil {
+			return fmt.Errorf("Error updating submodule '%s' (%s)", s.Config().Path, err)
+		}
+
+		if depth <= 1 {
+			continue
+		}
+		r, err := s.Repository()
+		if err != nil {
+			return err
+		}
+		w, err := r.Worktree()
+		if err != nil {
+			return err
+		}
+		if err := repo.updateSubmodules(ctx, w, s.Config().URL, depth-1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Digest computes a digest over the submodule commits and LFS object contents checked out
+// in the worktree, returning an empty string if neither submodules nor LFS are enabled
+func (repo *Repo) Digest() (string, error) {
+	if !repo.submodules && repo.lfs == "" {
+		return "", nil
+	}
+
+	lines := make([]string, 0)
+
+	if repo.submodules {
+		worktree, err := repo.repository.Worktree()
+		if err != nil {
+			return "", err
+		}
+		submodules, err := submoduleHeads(worktree, "")
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, submodules...)
+	}
+
+	if repo.lfs != "" {
+		pointers, err := repo.lfsPointers()
+		if err != nil {
+			return "", err
+		}
+		for _, p := range pointers {
+			d, err := ioutil.ReadFile(filepath.Join(repo.path, p.Path))
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, fmt.Sprintf("lfs %s %x", p.Path, sha256.Sum256(d)))
+		}
+	}
+
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, l := range lines {
+		fmt.Fprintln(h, l)
+	}
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// submoduleHeads lists the commits checked out for each (nested) submodule of a worktree
+func submoduleHeads(worktree *git.Worktree, prefix string) ([]string, error) {
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]string, 0)
+	for _, s := range submodules {
+		path := prefix + s.Config().Path
+
+		r, err := s.Repository()
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("submodule %s uninitialised", path))
+			continue
+		}
+		head, err := r.Head()
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("submodule %s uninitialised", path))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("submodule %s %s", path, head.Hash()))
+
+		w, err := r.Worktree()
+		if err != nil {
+			return nil, err
+		}
+		nested, err := submoduleHeads(w, path+"/")
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, nested...)
+	}
+
+	return lines, nil
+}
+
+// resolveURL resolves a relative submodule URL against the parent repository URL
+func resolveURL(base, rel string) string {
+	if !strings.HasPrefix(rel, "./") && !strings.HasPrefix(rel, "../") {
+		return rel
+	}
+
+	if u, err := url.Parse(base); err == nil && u.Scheme != "" && (u.Host != "" || u.Scheme == "file") {
+		u.Path = path.Join(u.Path, rel)
+		return u.String()
+	}
+
+	return path.Join(base, rel)
+}
+
 func (repo *Repo) Update(path, version string) error {
 	// Fetch matching tags
 	tags, err := NewTagsFromRepo(repo.repository)
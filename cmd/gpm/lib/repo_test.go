@@ -0,0 +1,209 @@
+package gpm
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepo(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, testDir, "v0.1.0", "untagged-1", "untagged-2", "v0.2.0", "untagged-3", "untagged-4", "v0.3.0", "head")
+	bare, hashes := module.URL(), module.Hashes
+
+	t.Run("Shallow clones include tagged commits", func(t *testing.T) {
+		repo := NewRepo(filepath.Join(testDir, "shallow"), bare)
+		assert.Nil(t, repo.Clone(context.Background()))
+
+		assert.True(t, repo.Shallow())
+		for _, name := range []string{"v0.1.0", "v0.2.0", "v0.3.0", "head"} {
+			assert.True(t, repo.HasCommit(hashes[name]), name)
+		}
+		assert.False(t, repo.HasCommit(hashes["untagged-1"]))
+
+		tags, err := repo.GetTags()
+		assert.Nil(t, err)
+		assert.EqualValues(t, 3, len(tags))
+	})
+
+	t.Run("Finds remote commits beyond the shallow boundary", func(t *testing.T) {
+		repo := NewRepo(filepath.Join(testDir, "contains"), bare)
+		assert.Nil(t, repo.Clone(context.Background()))
+		assert.False(t, repo.HasCommit(hashes["untagged-1"]))
+
+		ok, err := repo.RemoteContains(context.Background(), hashes["untagged-1"])
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		ok, err = repo.RemoteContains(context.Background(), strings.Repeat("a", 40))
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		assert.False(t, repo.Shallow())
+	})
+
+	t.Run("Deepens shallow clones on demand", func(t *testing.T) {
+		repo := NewRepo(filepath.Join(testDir, "deepen"), bare)
+		assert.Nil(t, repo.Clone(context.Background()))
+		assert.False(t, repo.HasCommit(hashes["untagged-3"]))
+
+		assert.Nil(t, repo.Deepen(context.Background(), hashes["untagged-3"]))
+		assert.True(t, repo.HasCommit(hashes["untagged-3"]))
+		assert.Nil(t, repo.SyncHash(context.Background(), hashes["untagged-3"]))
+
+		head, err := repo.Head()
+		assert.Nil(t, err)
+		assert.EqualValues(t, hashes["untagged-3"], head)
+		_, err = os.Stat(filepath.Join(testDir, "deepen.gpm-fetch"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Deepens in place and refuses modified repositories", func(t *testing.T) {
+		path := filepath.Join(testDir, "inplace")
+		repo := NewRepo(path, bare)
+		assert.Nil(t, repo.Clone(context.Background()))
+
+		// Local state outside the worktree is preserved
+		marker := filepath.Join(path, ".git", "gpm-marker")
+		assert.Nil(t, ioutil.WriteFile(marker, []byte("marker"), 0644))
+
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(path, "local.txt"), []byte("local"), 0644))
+		assert.EqualValues(t, ErrDirtyModule, Code(repo.Deepen(context.Background(), hashes["untagged-3"])))
+		assert.EqualValues(t, ErrDirtyModule, Code(repo.Unshallow(context.Background())))
+		assert.FileExists(t, filepath.Join(path, "local.txt"))
+		assert.True(t, repo.Shallow())
+
+		assert.Nil(t, os.Remove(filepath.Join(path, "local.txt")))
+		assert.Nil(t, repo.Deepen(context.Background(), hashes["untagged-3"]))
+		assert.True(t, repo.HasCommit(hashes["untagged-3"]))
+		assert.FileExists(t, marker)
+
+		assert.Nil(t, repo.Unshallow(context.Background()))
+		assert.False(t, repo.Shallow())
+		for name, hash := range hashes {
+			assert.True(t, repo.HasCommit(hash), name)
+		}
+		assert.FileExists(t, marker)
+
+		// The deepened repository remains valid for git, without temporary clones
+		gpmtest.Git(t, path, "fsck", "--no-progress")
+		assert.EqualValues(t, "false", gpmtest.Git(t, path, "rev-parse", "--is-shallow-repository"))
+		_, err := os.Stat(path + ".gpm-fetch")
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Fails to deepen to missing commits", func(t *testing.T) {
+		repo := NewRepo(filepath.Join(testDir, "missing"), bare)
+		assert.Nil(t, repo.Clone(context.Background()))
+
+		missing := strings.Repeat("a", 40)
+		assert.NotNil(t, repo.Deepen(context.Background(), missing))
+		assert.False(t, repo.Shallow())
+		assert.NotNil(t, repo.Deepen(context.Background(), missing))
+	})
+
+	t.Run("Clones full history", func(t *testing.T) {
+		repo := NewRepo(filepath.Join(testDir, "full"), bare).WithDepth(0)
+		assert.Nil(t, repo.Clone(context.Background()))
+
+		assert.False(t, repo.Shallow())
+		for name, hash := range hashes {
+			assert.True(t, repo.HasCommit(hash), name)
+		}
+	})
+
+	t.Run("Checks out submodules at recorded commits", func(t *testing.T) {
+		// Create a parent repository with a relative submodule pinned to a tag
+		sub := gpmtest.Versions(t, filepath.Join(testDir, "sub"), "v1.0.0", "v1.1.0")
+		parentRepo := gpmtest.NewRepo(t, filepath.Join(testDir, "parent"))
+		parentRepo.Git("submodule", "-q", "add", "../../sub/bare.git", "lib/sub")
+		parentRepo.Git("-C", "lib/sub", "checkout", "-q", "v1.0.0")
+		parentHash := parentRepo.Commit("Add submodule", map[string]string{})
+		parentRepo.Tag("v0.1.0", gpmtest.Annotated)
+		parent := parentRepo.URL()
+
+		t.Run("Without submodules", func(t *testing.T) {
+			repo := NewRepo(filepath.Join(testDir, "without-submodules"), parent)
+			assert.Nil(t, repo.Clone(context.Background()))
+			assert.Nil(t, repo.SyncHash(context.Background(), parentHash))
+
+			_, err := os.Stat(filepath.Join(testDir, "without-submodules", "lib/sub/version"))
+			assert.True(t, os.IsNotExist(err))
+
+			digest, err := repo.Digest()
+			assert.Nil(t, err)
+			assert.EqualValues(t, "", digest)
+		})
+
+		t.Run("With submodules", func(t *testing.T) {
+			repo := NewRepo(filepath.Join(testDir, "with-submodules"), parent).WithSubmodules(true)
+			assert.Nil(t, repo.Clone(context.Background()))
+			assert.Nil(t, repo.SyncHash(context.Background(), parentHash))
+
+			d, err := ioutil.ReadFile(filepath.Join(testDir, "with-submodules", "lib/sub/version"))
+			assert.Nil(t, err)
+			assert.EqualValues(t, "v1.0.0", string(d))
+
+			digest, err := repo.Digest()
+			assert.Nil(t, err)
+			expected := sha256.Sum256([]byte(fmt.Sprintf("submodule lib/sub %s\n", sub.Hashes["v1.0.0"])))
+			assert.EqualValues(t, fmt.Sprintf("sha256:%x", expected), digest)
+		})
+	})
+
+	t.Run("Syncs modules locked to untagged commits", func(t *testing.T) {
+		tests := []struct {
+			Name string
+			Dep  Dependency
+		}{
+			{"Default depth", Dependency{Path: "default", URL: bare, Version: "^0.1.0"}},
+			{"Configured depth", Dependency{Path: "depth", URL: bare, Version: "^0.1.0", Depth: 3}},
+			{"Full history", Dependency{Path: "full", URL: bare, Version: "^0.1.0", FullHistory: true}},
+		}
+
+		for i, test := range tests {
+			t.Run(test.Name, func(t *testing.T) {
+				projectDir := filepath.Join(testDir, fmt.Sprintf("project-%d", i))
+				assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+				o := CommonOptions{BasePath: projectDir, Verbose: true}
+				gpm := GPM{options: &o}
+
+				pc := ProjectConfig{Name: "project", Dependencies: Dependencies{test.Dep}}
+				locks := Locks{test.Dep.Path: {Hash: hashes["untagged-1"], URL: bare}}
+				assert.Nil(t, gpm.writeProjectConfig(&pc))
+				assert.Nil(t, gpm.writeLockfile(&locks))
+
+				modules, err := gpm.Sync(context.Background(), &SyncOptions{})
+				assert.Nil(t, err)
+				if assert.EqualValues(t, 1, len(modules)) {
+					assert.EqualValues(t, hashes["untagged-1"], modules[0].Hash)
+				}
+
+				repo := NewRepo(filepath.Join(projectDir, test.Dep.Path), bare)
+				assert.Nil(t, repo.Open())
+				head, err := repo.Head()
+				assert.Nil(t, err)
+				assert.EqualValues(t, hashes["untagged-1"], head)
+				if test.Dep.FullHistory {
+					assert.False(t, repo.Shallow())
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package gpm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// SyncStateName is the file recording modules completed by an interrupted sync
+	SyncStateName = ".gpm-sync.yml"
+)
+
+// syncStatePath locates the sync state, within the project .git directory where the project
+// is a git repository so the state is never committed, otherwise in the project directory
+func (gpm *GPM) syncStatePath() (string, error) {
+	if info, err := os.Stat(gitDir(gpm.options.BasePath)); err == nil && info.IsDir() {
+		return filepath.Join(gitDir(gpm.options.BasePath), SyncStateName), nil
+	}
+	return gpm.options.fullPath(SyncStateName)
+}
+
+// loadSyncState loads the locks for modules completed by an interrupted sync,
+// returning an empty state if no sync was interrupted
+func (gpm *GPM) loadSyncState() Locks {
+	state := make(Locks)
+	p, err := gpm.syncStatePath()
+	if err != nil {
+		return state
+	}
+	d, err := ioutil.ReadFile(p)
+	if err != nil || yaml.Unmarshal(d, &state) != nil || state == nil {
+		return make(Locks)
+	}
+	return state
+}
+
+// writeSyncState records the locks for modules completed by the current sync. The state is
+// kept along with the completed modules, so rolling back a failed sync restores the state
+// of the modules kept rather than discarding it or leaving entries for rolled back modules.
+func (gpm *GPM) writeSyncState(state Locks) error {
+	p, err := gpm.syncStatePath()
+	if err != nil {
+		return err
+	}
+	d, err := encodeYaml(state)
+	if err != nil {
+		return wrapError(ErrFilesystem, err, "Error marshaling object to file '%s'", SyncStateName)
+	}
+	if err := writeFile(p, d); err != nil {
+		return wrapError(ErrFilesystem, err, "Error writing to file '%s'", SyncStateName)
+	}
+
+	if tx := gpm.tx; tx != nil {
+		tx.files[p] = d
+		return tx.save()
+	}
+	return nil
+}
+
+// clearSyncState removes the sync state once all modules have been synced
+func (gpm *GPM) clearSyncState() error {
+	p, err := gpm.syncStatePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return wrapError(ErrFilesystem, err, "Error removing file '%s'", SyncStateName)
+	}
+	return nil
+}
+
+// resumeModule checks whether a module was synced to the locked commit by an interrupted
+// sync and remains checked out, opening the module repository if so
+func (gpm *GPM) resumeModule(d *Dependency, modulePath string, lock, done Lock) (*Repo, bool) {
+	if done.Hash == "" || done.Hash != lock.Hash || done.URL != d.URL {
+		return nil, false
+	}
+
+	repo := gpm.newRepo(d, modulePath)
+	if !repo.Exists() || repo.Open() != nil {
+		return nil, false
+	}
+	if head, err := repo.Head(); err != nil || head != done.Hash {
+		return nil, false
+	}
+
+	return repo, true
+}
+
+// keepModule commits the changes made to a module by the current transaction, so a
+// completed module is not rolled back if a later module fails
+func (gpm *GPM) keepModule(repo *Repo) {
+	tx := gpm.tx
+	if tx == nil {
+		return
+	}
+
+	cloned := tx.cloned[:0]
+	for _, p := range tx.cloned {
+		if p != repo.path {
+			cloned = append(cloned, p)
+		}
+	}
+	tx.cloned = cloned
+
+	checkouts := tx.checkouts[:0]
+	for _, c := range tx.checkouts {
+		if c.repo.path != repo.path {
+			checkouts = append(checkouts, c)
+		}
+	}
+	tx.checkouts = checkouts
+
+	if err := tx.save(); err != nil {
+		gpm.logf("Warning: %s\n", err)
+	}
+}
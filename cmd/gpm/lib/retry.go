@@ -0,0 +1,151 @@
+package gpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+// MaxRetryBackoff is the maximum delay between retries, including delays requested by
+// rate limited remotes
+const MaxRetryBackoff = time.Minute
+
+// RetryPolicy configures timeouts and retries for remote repository operations
+type RetryPolicy struct {
+	Retries int           // Number of retries following a failed attempt
+	Backoff time.Duration // Delay before the first retry, doubled for each subsequent retry
+	Timeout time.Duration // Timeout for each attempt, 0 for no timeout
+
+	// OnRetry is called prior to each retry with the retry number, delay and attempt error
+	OnRetry func(retry int, delay time.Duration, err error)
+}
+
+// timeoutError reports an attempt exceeding the policy timeout, distinct from the
+// cancellation of the caller context
+type timeoutError struct {
+	timeout time.Duration
+	err     error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s (%s)", e.timeout, e.err)
+}
+
+// Do runs an operation, retrying failed attempts with exponential backoff until the
+// operation succeeds, fails permanently, or the retries are exhausted
+func (p RetryPolicy) Do(ctx context.Context, op func(ctx context.Context) error) error {
+	for retry := 0; ; retry++ {
+		err := p.attempt(ctx, op)
+		if err == nil || retry >= p.Retries || !retryable(ctx, err) {
+			return err
+		}
+
+		delay := p.delay(retry, err)
+		if p.OnRetry != nil {
+			p.OnRetry(retry+1, delay, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// attempt runs a single attempt of an operation with the policy timeout
+func (p RetryPolicy) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	if p.Timeout <= 0 {
+		return op(ctx)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	err := op(attemptCtx)
+	if err != nil && ctx.Err() == nil && attemptCtx.Err() == context.DeadlineExceeded {
+		return &timeoutError{timeout: p.Timeout, err: err}
+	}
+	return err
+}
+
+// delay computes the backoff prior to a retry, using the delay requested by rate
+// limited remotes where this is longer
+func (p RetryPolicy) delay(retry int, err error) time.Duration {
+	delay := p.Backoff
+	for i := 0; i < retry && delay < MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if after := retryAfter(err); after > delay {
+		delay = after
+	}
+	if delay > MaxRetryBackoff {
+		delay = MaxRetryBackoff
+	}
+	return delay
+}
+
+// retryable checks whether an operation error may be resolved by retrying, where only
+// timeouts, network and transport errors, HTTP server errors, request timeouts and rate
+// limiting are retried and all other errors are permanent
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var timeout *timeoutError
+	if errors.As(err, &timeout) {
+		return true
+	}
+
+	if res := httpResponse(err); res != nil {
+		return res.StatusCode >= 500 || res.StatusCode == http.StatusRequestTimeout || res.StatusCode == http.StatusTooManyRequests
+	}
+
+	// Connections closed part way through a response
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var unexpected *plumbing.UnexpectedError
+	if errors.As(err, &unexpected) {
+		err = unexpected.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryAfter fetches the delay requested by a Retry-After header on a failed response
+func retryAfter(err error) time.Duration {
+	res := httpResponse(err)
+	if res == nil {
+		return 0
+	}
+	h := res.Header.Get("Retry-After")
+	if s, err := strconv.Atoi(h); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// httpResponse fetches the response for unexpected HTTP status errors
+func httpResponse(err error) *http.Response {
+	var unexpected *plumbing.UnexpectedError
+	if errors.As(err, &unexpected) {
+		err = unexpected.Err
+	}
+	var httpErr *githttp.Err
+	if errors.As(err, &httpErr) {
+		return httpErr.Response
+	}
+	return nil
+}
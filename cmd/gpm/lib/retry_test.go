@@ -0,0 +1,276 @@
+package gpm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+// statusError builds the error returned by go-git for unexpected HTTP responses
+func statusError(status int, retryAfter string) error {
+	res := http.Response{StatusCode: status, Header: http.Header{}, Request: &http.Request{}}
+	if retryAfter != "" {
+		res.Header.Set("Retry-After", retryAfter)
+	}
+	return plumbing.NewUnexpectedError(&githttp.Err{Response: &res})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Retries transient errors", func(t *testing.T) {
+		tests := []struct {
+			err      error
+			attempts int
+		}{
+			{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, 3},
+			{plumbing.NewUnexpectedError(&net.DNSError{Err: "timeout", IsTimeout: true}), 3},
+			{io.ErrUnexpectedEOF, 3},
+			{statusError(http.StatusServiceUnavailable, ""), 3},
+			{statusError(http.StatusRequestTimeout, ""), 3},
+			{statusError(http.StatusTooManyRequests, ""), 3},
+			{statusError(http.StatusBadRequest, ""), 1},
+			{transport.ErrRepositoryNotFound, 1},
+			{transport.ErrAuthenticationRequired, 1},
+			{errors.New("object not found"), 1},
+			{os.ErrPermission, 1},
+		}
+
+		for _, test := range tests {
+			attempts, retries := 0, 0
+			p := RetryPolicy{Retries: 2, OnRetry: func(int, time.Duration, error) { retries++ }}
+
+			err := p.Do(ctx, func(context.Context) error {
+				attempts++
+				return test.err
+			})
+			assert.EqualValues(t, test.err, err)
+			assert.EqualValues(t, test.attempts, attempts, "%v", test.err)
+			assert.EqualValues(t, test.attempts-1, retries, "%v", test.err)
+		}
+	})
+
+	t.Run("Stops retrying on success", func(t *testing.T) {
+		attempts := 0
+		err := RetryPolicy{Retries: 5}.Do(ctx, func(context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 3, attempts)
+	})
+
+	t.Run("Backs off exponentially", func(t *testing.T) {
+		p := RetryPolicy{Backoff: time.Second}
+		transient := errors.New("transient")
+
+		assert.EqualValues(t, time.Second, p.delay(0, transient))
+		assert.EqualValues(t, 4*time.Second, p.delay(2, transient))
+		assert.EqualValues(t, MaxRetryBackoff, p.delay(20, transient))
+
+		// Rate limited remotes may request longer delays
+		assert.EqualValues(t, 10*time.Second, p.delay(0, statusError(http.StatusTooManyRequests, "10")))
+		assert.EqualValues(t, time.Second, p.delay(0, statusError(http.StatusTooManyRequests, "invalid")))
+		assert.EqualValues(t, MaxRetryBackoff, p.delay(0, statusError(http.StatusTooManyRequests, "3600")))
+	})
+
+	t.Run("Times out attempts", func(t *testing.T) {
+		attempts := 0
+		p := RetryPolicy{Retries: 1, Timeout: 10 * time.Millisecond}
+
+		err := p.Do(ctx, func(ctx context.Context) error {
+			attempts++
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		assert.EqualValues(t, 2, attempts)
+		assert.Contains(t, err.Error(), "timed out")
+		assert.EqualValues(t, ErrRemote, Code(wrapError(ErrRemote, err, "Error fetching")))
+	})
+
+	t.Run("Stops retrying when canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		attempts := 0
+
+		err := RetryPolicy{Retries: 5, Backoff: time.Minute}.Do(ctx, func(context.Context) error {
+			attempts++
+			cancel()
+			return errors.New("transient")
+		})
+		assert.EqualValues(t, 1, attempts)
+		assert.EqualValues(t, ErrCanceled, Code(contextError(ctx, err)))
+	})
+}
+
+func TestRetry(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0")
+	hashes := module.Hashes
+
+	server := gpmtest.NewServer(t, testDir)
+	defer server.Close()
+	url := server.RepoURL(module)
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+	gpmtest.Git(t, projectDir, "init", "-q")
+
+	events := make([]ProgressEvent, 0)
+	o := CommonOptions{BasePath: projectDir, Verbose: true, Retries: 2, RetryBackoff: time.Millisecond}
+	gpm := NewGPM(&o).WithProgress(ProgressFunc(func(e ProgressEvent) {
+		events = append(events, e)
+	}))
+	ctx := context.Background()
+
+	pc := ProjectConfig{Name: "project"}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+	assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+	retries := func() int {
+		n := 0
+		for _, e := range events {
+			if e.Type == ProgressRetrying {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("Retries failed clones", func(t *testing.T) {
+		events = events[:0]
+		server.Inject(
+			gpmtest.Fault{Status: http.StatusServiceUnavailable},
+			gpmtest.Fault{Path: "git-upload-pack", Truncate: true},
+		)
+
+		m, err := gpm.Add(ctx, &AddOptions{Path: "lib/a", URL: url, Version: "v0.1.0"})
+		assert.Nil(t, err)
+		if assert.NotNil(t, m) {
+			assert.EqualValues(t, hashes["v0.1.0"], m.Hash)
+		}
+		assert.EqualValues(t, 2, retries())
+	})
+
+	t.Run("Retries timed out clones", func(t *testing.T) {
+		events = events[:0]
+		o.Timeout = 200 * time.Millisecond
+		defer func() { o.Timeout = 0 }()
+
+		server.Inject(gpmtest.Fault{Path: "git-upload-pack", Delay: 5 * time.Second})
+
+		_, err := gpm.Add(ctx, &AddOptions{Path: "lib/b", URL: url, Version: "v0.1.0"})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 1, retries())
+	})
+
+	t.Run("Fails once retries are exhausted", func(t *testing.T) {
+		events = events[:0]
+		server.Inject(
+			gpmtest.Fault{Status: http.StatusBadGateway},
+			gpmtest.Fault{Status: http.StatusBadGateway},
+			gpmtest.Fault{Status: http.StatusBadGateway},
+		)
+
+		_, err := gpm.Add(ctx, &AddOptions{Path: "lib/c", URL: url, Version: "v0.1.0"})
+		assert.EqualValues(t, ErrRemote, Code(err))
+		assert.EqualValues(t, 2, retries())
+
+		_, err = os.Stat(filepath.Join(projectDir, "lib/c"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Does not retry permanent errors", func(t *testing.T) {
+		events = events[:0]
+		requests := server.Requests()
+
+		_, err := gpm.Add(ctx, &AddOptions{Path: "lib/c", URL: url + "-missing"})
+		assert.EqualValues(t, ErrRemote, Code(err))
+		assert.EqualValues(t, 0, retries())
+		assert.EqualValues(t, requests+1, server.Requests())
+	})
+
+	t.Run("Resumes interrupted syncs", func(t *testing.T) {
+		for _, p := range []string{"lib/a", "lib/b"} {
+			assert.Nil(t, os.RemoveAll(filepath.Join(projectDir, p)))
+		}
+		lockfile, err := ioutil.ReadFile(filepath.Join(projectDir, LockfileName))
+		assert.Nil(t, err)
+
+		// Fail the second module clone after the first has completed
+		events = events[:0]
+		server.Inject(
+			gpmtest.Fault{Path: "git-upload-pack"},
+			gpmtest.Fault{Path: "git-upload-pack", Status: http.StatusInternalServerError},
+			gpmtest.Fault{Path: "git-upload-pack", Status: http.StatusInternalServerError},
+			gpmtest.Fault{Path: "git-upload-pack", Status: http.StatusInternalServerError},
+		)
+
+		_, err = gpm.Sync(ctx, &SyncOptions{})
+		assert.EqualValues(t, ErrRemote, Code(err))
+
+		// Completed modules are kept, and the failed module rolled back
+		_, err = os.Stat(filepath.Join(projectDir, "lib/a"))
+		assert.Nil(t, err)
+		_, err = os.Stat(filepath.Join(projectDir, "lib/b"))
+		assert.True(t, os.IsNotExist(err))
+
+		// Sync state is kept within the project repository, so is never committed
+		state := make(Locks)
+		assert.Nil(t, o.loadYaml(filepath.Join(".git", SyncStateName), &state))
+		assert.EqualValues(t, Locks{"lib/a": {Hash: hashes["v0.1.0"], URL: url}}, state)
+		_, err = os.Stat(filepath.Join(projectDir, SyncStateName))
+		assert.True(t, os.IsNotExist(err))
+
+		d, err := ioutil.ReadFile(filepath.Join(projectDir, LockfileName))
+		assert.Nil(t, err)
+		assert.EqualValues(t, string(lockfile), string(d))
+
+		// Syncing again resumes with the remaining modules
+		events = events[:0]
+		modules, err := gpm.Sync(ctx, &SyncOptions{})
+		assert.Nil(t, err)
+		assert.EqualValues(t, 2, len(modules))
+
+		for _, e := range events {
+			if e.Type == ProgressCloneStarted || e.Type == ProgressFetchStarted {
+				assert.EqualValues(t, "lib/b", e.Path, "%+v", e)
+			}
+		}
+		for _, p := range []string{"lib/a", "lib/b"} {
+			repo := NewRepo(filepath.Join(projectDir, p), url)
+			if assert.Nil(t, repo.Open(), p) {
+				head, err := repo.Head()
+				assert.Nil(t, err)
+				assert.EqualValues(t, hashes["v0.1.0"], head, p)
+			}
+		}
+
+		_, err = os.Stat(filepath.Join(projectDir, ".git", SyncStateName))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
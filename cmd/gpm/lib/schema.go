@@ -0,0 +1,386 @@
+package gpm
+
+import (
+	// Embeds the project schema
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectSchema is the JSON Schema (draft-07) for project files, applied to all project file formats
+//
+//go:embed project.schema.json
+var ProjectSchema []byte
+
+// Diagnostic describes a problem at a location in a project file
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`   // Line number, starting at 1, where available
+	Column  int    `json:"column,omitempty"` // Column number, starting at 1, where available
+	Path    string `json:"path,omitempty"`   // Path to the invalid value, ie. dependencies[0].url
+	Message string `json:"message"`
+}
+
+// String formats a diagnostic as `file:line:column: path: message`
+func (d Diagnostic) String() string {
+	loc := d.File
+	if d.Line > 0 {
+		loc = fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+	}
+	if d.Path != "" {
+		return fmt.Sprintf("%s: %s: %s", loc, d.Path, d.Message)
+	}
+	return fmt.Sprintf("%s: %s", loc, d.Message)
+}
+
+// Diagnostics are the problems found in a project file
+type Diagnostics []Diagnostic
+
+// Error formats the diagnostics, one per line where there are multiple problems
+func (d Diagnostics) Error() string {
+	if len(d) == 1 {
+		return d[0].String()
+	}
+	lines := make([]string, 0, len(d))
+	for _, v := range d {
+		lines = append(lines, "\n  "+v.String())
+	}
+	return fmt.Sprintf("%d problems:%s", len(d), strings.Join(lines, ""))
+}
+
+// schema is the subset of JSON Schema used by the project schema
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	Enum                 []string           `json:"enum"`
+	Pattern              string             `json:"pattern"`
+	Format               string             `json:"format"`
+	MinLength            int                `json:"minLength"`
+	Minimum              *float64           `json:"minimum"`
+	Definitions          map[string]*schema `json:"definitions"`
+
+	additional *schema
+	closed     bool
+	pattern    *regexp.Regexp
+}
+
+// schemaFormats are the string formats used by the project schema
+var schemaFormats = map[string]struct {
+	name  string
+	check func(string) error
+}{
+	"semver-range": {"semver range", func(s string) error {
+		_, err := semver.NewConstraint(s)
+		return err
+	}},
+	"module-path": {"module path", validateModulePath},
+}
+
+// projectSchema is the compiled project schema
+var projectSchema = mustCompileSchema(ProjectSchema)
+
+// mustCompileSchema loads a schema, compiling patterns and additional property schemas
+func mustCompileSchema(d []byte) *schema {
+	s := schema{}
+	if err := json.Unmarshal(d, &s); err != nil {
+		panic(fmt.Sprintf("invalid schema (%s)", err))
+	}
+	if err := s.compile(); err != nil {
+		panic(fmt.Sprintf("invalid schema (%s)", err))
+	}
+	return &s
+}
+
+func (s *schema) compile() error {
+	switch a := strings.TrimSpace(string(s.AdditionalProperties)); a {
+	case "", "true":
+	case "false":
+		s.closed = true
+	default:
+		s.additional = &schema{}
+		if err := json.Unmarshal(s.AdditionalProperties, s.additional); err != nil {
+			return err
+		}
+	}
+	if s.Pattern != "" {
+		p, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = p
+	}
+
+	children := []*schema{s.Items, s.additional}
+	for _, c := range s.Properties {
+		children = append(children, c)
+	}
+	for _, c := range s.Definitions {
+		children = append(children, c)
+	}
+	for _, c := range children {
+		if c == nil {
+			continue
+		}
+		if err := c.compile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validator checks a yaml node tree against a schema, collecting diagnostics
+type validator struct {
+	root  *schema
+	file  string
+	diags Diagnostics
+}
+
+// validateProject checks a parsed project file against the project schema, and
+// for duplicate or nested dependency paths
+func validateProject(file string, node *yaml.Node) Diagnostics {
+	v := validator{root: projectSchema, file: file}
+	v.validate(projectSchema, node, "")
+	v.checkPaths(node)
+	return v.diags
+}
+
+func (v *validator) report(n *yaml.Node, path, format string, args ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{File: v.file, Line: n.Line, Column: n.Column, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) resolve(s *schema) *schema {
+	if name := strings.TrimPrefix(s.Ref, "#/definitions/"); s.Ref != "" {
+		if d, ok := v.root.Definitions[name]; ok {
+			return d
+		}
+	}
+	return s
+}
+
+func (v *validator) validate(s *schema, n *yaml.Node, path string) {
+	s = v.resolve(s)
+	if n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+
+	if s.Type != "" && !matchesType(s.Type, n) {
+		v.report(n, path, "expected %s, found %s", s.Type, nodeType(n))
+		return
+	}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		v.validateObject(s, n, path)
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for i, item := range n.Content {
+				v.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case yaml.ScalarNode:
+		v.validateScalar(s, n, path)
+	}
+}
+
+func (v *validator) validateObject(s *schema, n *yaml.Node, path string) {
+	seen := make(map[string]bool)
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, value := n.Content[i], n.Content[i+1]
+		p := joinPath(path, k.Value)
+
+		if seen[k.Value] {
+			v.report(k, p, "duplicate key '%s'", k.Value)
+			continue
+		}
+		seen[k.Value] = true
+
+		if prop, ok := s.Properties[k.Value]; ok {
+			v.validate(prop, value, p)
+		} else if s.additional != nil {
+			v.validate(s.additional, value, p)
+		} else if s.closed {
+			if suggestion := suggestKey(k.Value, s.Properties); suggestion != "" {
+				v.report(k, p, "unknown key '%s' (did you mean '%s'?)", k.Value, suggestion)
+			} else {
+				v.report(k, p, "unknown key '%s'", k.Value)
+			}
+		}
+	}
+
+	for _, r := range s.Required {
+		if !seen[r] {
+			v.report(n, path, "missing required key '%s'", r)
+		}
+	}
+}
+
+func (v *validator) validateScalar(s *schema, n *yaml.Node, path string) {
+	if s.Type == "string" {
+		if len(n.Value) < s.MinLength {
+			v.report(n, path, "cannot be empty")
+			return
+		}
+		if s.pattern != nil && !s.pattern.MatchString(n.Value) {
+			v.report(n, path, "invalid value '%s' (must match '%s')", n.Value, s.Pattern)
+		}
+		if f, ok := schemaFormats[s.Format]; ok && n.Value != "" {
+			if err := f.check(n.Value); err != nil {
+				v.report(n, path, "invalid %s '%s' (%s)", f.name, n.Value, err)
+			}
+		}
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			found = found || e == n.Value
+		}
+		if !found {
+			v.report(n, path, "invalid value '%s' (must be one of %s)", n.Value, strings.Join(s.Enum, ", "))
+		}
+	}
+	if s.Minimum != nil {
+		if f, err := strconv.ParseFloat(n.Value, 64); err == nil && f < *s.Minimum {
+			v.report(n, path, "must be at least %v", *s.Minimum)
+		}
+	}
+}
+
+// checkPaths reports dependencies bound to the same path, or to paths nested inside
+// another dependency
+func (v *validator) checkPaths(root *yaml.Node) {
+	deps := mappingValue(root, "dependencies")
+	if deps == nil || deps.Kind != yaml.SequenceNode {
+		return
+	}
+
+	paths := make([]string, len(deps.Content))
+	for i, d := range deps.Content {
+		p := mappingValue(d, "path")
+		if p == nil || p.Kind != yaml.ScalarNode {
+			continue
+		}
+		paths[i] = p.Value
+
+		for j, other := range paths[:i] {
+			if conflict := pathConflict(p.Value, other); conflict != "" {
+				v.report(p, fmt.Sprintf("dependencies[%d].path", i), "%s (see dependencies[%d])", conflict, j)
+				break
+			}
+		}
+	}
+}
+
+// pathConflict describes a conflict between two dependency paths, returning an empty
+// string where the paths do not conflict
+func pathConflict(p, other string) string {
+	switch {
+	case other == "":
+		return ""
+	case p == other:
+		return fmt.Sprintf("duplicate dependency path '%s'", p)
+	case nestedPath(other, p):
+		return fmt.Sprintf("dependency path '%s' is nested inside '%s'", p, other)
+	case nestedPath(p, other):
+		return fmt.Sprintf("dependency path '%s' contains '%s'", p, other)
+	}
+	return ""
+}
+
+// matchesType checks whether a node matches a schema type. Plain scalars are accepted as
+// strings, as yaml decoding does for unquoted values such as `version: 1.2`.
+func matchesType(t string, n *yaml.Node) bool {
+	switch t {
+	case "object":
+		return n.Kind == yaml.MappingNode
+	case "array":
+		return n.Kind == yaml.SequenceNode
+	case "string":
+		return n.Kind == yaml.ScalarNode && n.ShortTag() != "!!null" && n.ShortTag() != "!!bool"
+	case "integer":
+		return n.Kind == yaml.ScalarNode && n.ShortTag() == "!!int"
+	case "number":
+		return n.Kind == yaml.ScalarNode && (n.ShortTag() == "!!int" || n.ShortTag() == "!!float")
+	case "boolean":
+		return n.Kind == yaml.ScalarNode && n.ShortTag() == "!!bool"
+	}
+	return true
+}
+
+// nodeType describes the type of a node using JSON Schema type names
+func nodeType(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch n.ShortTag() {
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "boolean"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	}
+	return "string"
+}
+
+// joinPath appends a key to a diagnostic path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// suggestKey finds a known key within a small edit distance of an unknown key
+func suggestKey(key string, properties map[string]*schema) string {
+	best, bestDistance := "", 3
+	for p := range properties {
+		if d := editDistance(key, p); d < bestDistance || (d == bestDistance && best != "" && p < best) {
+			best, bestDistance = p, d
+		}
+	}
+	return best
+}
+
+// editDistance computes the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,138 @@
+package gpm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchema(t *testing.T) {
+
+	t.Run("Publishes a valid JSON schema", func(t *testing.T) {
+		s := make(map[string]interface{})
+		assert.Nil(t, json.Unmarshal(ProjectSchema, &s))
+		assert.EqualValues(t, "http://json-schema.org/draft-07/schema#", s["$schema"])
+	})
+
+	t.Run("Accepts valid project files", func(t *testing.T) {
+		files := map[string]string{
+			ProjectConfigName:     "name: project\ndependencies:\n- path: lib/a\n  url: https://example.com/a\n  version: ^1.2.0\n  depth: 1\n  groups: [dev]\n",
+			ProjectConfigTOMLName: "name = \"project\"\n\n[[dependencies]]\npath = \"lib/a\"\nurl = \"https://example.com/a\"\nversion = \"^1.2.0\"\ndepth = 1\ngroups = [\"dev\"]\n",
+			ProjectConfigJSONName: `{"name": "project", "dependencies": [{"path": "lib/a", "url": "https://example.com/a", "version": "^1.2.0", "depth": 1, "groups": ["dev"]}]}`,
+		}
+
+		for name, data := range files {
+			pc, err := decodeProject(name, []byte(data))
+			assert.Nil(t, err, name)
+			assert.EqualValues(t, "project", pc.Name, name)
+			if assert.Len(t, pc.Dependencies, 1, name) {
+				d := pc.Dependencies[0]
+				assert.EqualValues(t, "lib/a", d.Path, name)
+				assert.EqualValues(t, "https://example.com/a", d.URL, name)
+				assert.EqualValues(t, "^1.2.0", d.Version, name)
+				assert.EqualValues(t, 1, d.Depth, name)
+				assert.EqualValues(t, []string{"dev"}, d.Groups, name)
+			}
+		}
+	})
+
+	t.Run("Locates problems in project files", func(t *testing.T) {
+		tests := []struct {
+			name string
+			data string
+			diag Diagnostic
+		}{
+			{
+				ProjectConfigName,
+				"name: project\nlicence: MIT\n",
+				Diagnostic{Line: 2, Column: 1, Path: "licence", Message: "unknown key 'licence' (did you mean 'license'?)"},
+			},
+			{
+				ProjectConfigName,
+				"dependencies:\n- path: lib/a\n  url: https://example.com/a\n  version: not-a-version\n",
+				Diagnostic{Line: 4, Column: 12, Path: "dependencies[0].version", Message: "invalid semver range 'not-a-version' (improper constraint: not-a-version)"},
+			},
+			{
+				ProjectConfigName,
+				"dependencies:\n- path: lib/a\n  url: https://example.com/a\n- path: lib/a\n  url: https://example.com/b\n",
+				Diagnostic{Line: 4, Column: 9, Path: "dependencies[1].path", Message: "duplicate dependency path 'lib/a' (see dependencies[0])"},
+			},
+			{
+				ProjectConfigName,
+				"dependencies:\n- path: lib\n  url: https://example.com/a\n- path: lib/b\n  url: https://example.com/b\n",
+				Diagnostic{Line: 4, Column: 9, Path: "dependencies[1].path", Message: "dependency path 'lib/b' is nested inside 'lib' (see dependencies[0])"},
+			},
+			{
+				ProjectConfigName,
+				"dependencies:\n- path: lib/b\n  url: https://example.com/b\n- path: lib\n  url: https://example.com/a\n- path: libs\n  url: https://example.com/c\n",
+				Diagnostic{Line: 4, Column: 9, Path: "dependencies[1].path", Message: "dependency path 'lib' contains 'lib/b' (see dependencies[0])"},
+			},
+			{
+				ProjectConfigName,
+				"dependencies:\n- path: lib/a\n",
+				Diagnostic{Line: 2, Column: 3, Path: "dependencies[0]", Message: "missing required key 'url'"},
+			},
+			{
+				ProjectConfigName,
+				"dependencies:\n- path: ../outside\n  url: https://example.com/a\n",
+				Diagnostic{Line: 2, Column: 9, Path: "dependencies[0].path", Message: "invalid module path '../outside' (path must be within the project directory)"},
+			},
+			{
+				ProjectConfigName,
+				"dependencies:\n- path: lib/a\n  url: https://example.com/a\n  depth: deep\n",
+				Diagnostic{Line: 4, Column: 10, Path: "dependencies[0].depth", Message: "expected integer, found string"},
+			},
+			{
+				ProjectConfigName,
+				"name: [project\n",
+				Diagnostic{Line: 1, Message: "did not find expected ',' or ']'"},
+			},
+			{
+				ProjectConfigTOMLName,
+				"name = \"project\"\n\n[[dependencies]]\npath = \"lib/a\"\nurl = \"https://example.com/a\"\nversoin = \"1.0.0\"\n",
+				Diagnostic{Line: 6, Column: 1, Path: "dependencies[0].versoin", Message: "unknown key 'versoin' (did you mean 'version'?)"},
+			},
+			{
+				ProjectConfigTOMLName,
+				"[[dependencies]]\npath = \"lib/a\"\nurl = \"https://example.com/a\"\n\n[[dependencies]]\nurl = \"https://example.com/b\"\n",
+				Diagnostic{Line: 5, Column: 1, Path: "dependencies[1]", Message: "missing required key 'path'"},
+			},
+			{
+				ProjectConfigTOMLName,
+				"name = \"project\"\nlicense = \n",
+				Diagnostic{Line: 2, Column: 11, Message: "expected value but found '\\n' instead"},
+			},
+			{
+				ProjectConfigJSONName,
+				"{\n  \"dependencies\": [\n    {\"path\": \"lib/a\", \"url\": \"https://example.com/a\", \"version\": \"one\"}\n  ]\n}\n",
+				Diagnostic{Line: 3, Column: 66, Path: "dependencies[0].version", Message: "invalid semver range 'one' (improper constraint: one)"},
+			},
+			{
+				ProjectConfigJSONName,
+				"{\n  \"name\": \"project\",\n}\n",
+				Diagnostic{Line: 3, Column: 1, Message: "invalid character '}' looking for beginning of object key string"},
+			},
+		}
+
+		for _, test := range tests {
+			_, err := decodeProject(test.name, []byte(test.data))
+			diags, ok := err.(Diagnostics)
+			if !assert.True(t, ok, "%s: %v", test.name, err) || !assert.Len(t, diags, 1, "%s: %v", test.name, err) {
+				continue
+			}
+			test.diag.File = test.name
+			assert.EqualValues(t, test.diag, diags[0], test.data)
+		}
+	})
+
+	t.Run("Reports all problems", func(t *testing.T) {
+		_, err := decodeProject(ProjectConfigName, []byte("nmae: project\ndependencies:\n- url: https://example.com/a\n"))
+		diags, ok := err.(Diagnostics)
+		assert.True(t, ok)
+		assert.Len(t, diags, 2)
+		assert.EqualValues(t, "2 problems:\n"+
+			"  .gpm.yml:1:1: nmae: unknown key 'nmae' (did you mean 'name'?)\n"+
+			"  .gpm.yml:3:3: dependencies[0]: missing required key 'path'", err.Error())
+	})
+}
@@ -0,0 +1,221 @@
+package gpm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Masterminds/semver"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// ModuleStatus describes the locked version of a module and the versions available on the module remote
+type ModuleStatus struct {
+	Module
+	Latest string   `json:"latest,omitempty"` // Latest eligible tag
+	Tags   []string `json:"tags"`             // Eligible tags, in descending version order
+}
+
+// Outdated checks whether a tag newer than the locked tag is available
+func (s ModuleStatus) Outdated() bool {
+	if s.Latest == "" {
+		return false
+	}
+	if s.Tag == "" {
+		return true
+	}
+	latest, err := semver.NewVersion(s.Latest)
+	if err != nil {
+		return false
+	}
+	locked, err := semver.NewVersion(s.Tag)
+	if err != nil {
+		return true
+	}
+	return latest.GreaterThan(locked)
+}
+
+// String formats a module status as a human readable summary line
+func (s ModuleStatus) String() string {
+	locked := s.Tag
+	if locked == "" {
+		locked = shortHash(s.Hash)
+	}
+	line := fmt.Sprintf("%s: %s", s.Path, locked)
+	if s.Outdated() {
+		line += fmt.Sprintf(" (latest: %s)", s.Latest)
+	}
+	return line
+}
+
+// Status fetches each dependency, reporting the locked tag and the tags available to upgrade to
+func (gpm *GPM) Status(ctx context.Context) (status []ModuleStatus, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+	locks, err := gpm.loadLockfile()
+	if err != nil {
+		return nil, err
+	}
+
+	status = make([]ModuleStatus, 0, len(pc.Dependencies))
+
+	for _, v := range pc.Dependencies {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+
+		// Resolve full module path
+		modulePath, err := gpm.modulePath(v.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		// Clone or open and update repo depending on current state
+		repo, err := gpm.fetchRepo(ctx, &v, modulePath)
+		if err != nil {
+			return nil, err
+		}
+
+		tags, err := gpm.moduleTags(&v, repo, false)
+		if err != nil {
+			return nil, err
+		}
+		ordered, err := tags.Sort()
+		if err != nil {
+			return nil, wrapError(ErrInvalidVersion, err, "Error sorting tags for module '%s'", v.Path)
+		}
+
+		s := ModuleStatus{Module: Module{Dependency: v, Hash: locks[v.Path].Hash}, Tags: make([]string, 0, len(ordered))}
+		s.Tag, _ = tags.Find(s.Hash)
+		for i := len(ordered) - 1; i >= 0; i-- {
+			s.Tags = append(s.Tags, ordered[i])
+		}
+		if len(s.Tags) > 0 {
+			s.Latest = s.Tags[0]
+		}
+
+		status = append(status, s)
+	}
+
+	return status, nil
+}
+
+// Preview describes the project changes resulting from moving a module to a new tag
+type Preview struct {
+	Upgrade
+	OldTag    string   `json:"oldTag,omitempty"`
+	NewTag    string   `json:"newTag"`
+	Downgrade bool     `json:"downgrade,omitempty"`
+	Changes   []Change `json:"changes"` // Commits added by the upgrade, or removed by a downgrade
+}
+
+// String formats a preview as a diff of the project config, lockfile and module history
+func (p Preview) String() string {
+	s := fmt.Sprintf("%s\n", ProjectConfigName)
+	s += fmt.Sprintf("- %s: %s\n", p.Path, p.OldVersion)
+	s += fmt.Sprintf("+ %s: %s\n", p.Path, p.NewVersion)
+	s += fmt.Sprintf("%s\n", LockfileName)
+	s += fmt.Sprintf("- %s: %s %s\n", p.Path, shortHash(p.OldHash), p.OldTag)
+	s += fmt.Sprintf("+ %s: %s %s\n", p.Path, shortHash(p.NewHash), p.NewTag)
+
+	if len(p.Changes) > 0 {
+		prefix := "+"
+		if p.Downgrade {
+			prefix = "-"
+		}
+		s += "commits\n"
+		for _, c := range p.Changes {
+			s += fmt.Sprintf("%s %s %s\n", prefix, shortHash(c.Hash), c.Summary)
+		}
+	}
+
+	return s
+}
+
+// Preview computes the version range, lock and commits that would change if the module at
+// the provided path was upgraded (or downgraded) to the provided tag, without applying them.
+// The module must have been fetched (ie. using Status) so the tag is available locally.
+func (gpm *GPM) Preview(ctx context.Context, path, tag string) (*Preview, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+	locks, err := gpm.loadLockfile()
+	if err != nil {
+		return nil, err
+	}
+
+	d, ok := pc.Dependencies.Find(path)
+	if !ok {
+		return nil, errorf(ErrNoDependency, "No dependency bound to location '%s'", path)
+	}
+	modulePath, err := gpm.modulePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := gpm.newRepo(d, modulePath)
+	if err := repo.Open(); err != nil {
+		return nil, wrapError(ErrRepository, err, "Error opening module '%s', try 'gpm sync'", path)
+	}
+	tags, err := gpm.moduleTags(d, repo, false)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := tags[tag]
+	if !ok {
+		return nil, errorf(ErrNoMatchingTag, "No tag '%s' for module '%s'", tag, path)
+	}
+
+	p := Preview{
+		Upgrade: Upgrade{
+			Path:       path,
+			OldVersion: d.Version,
+			NewVersion: previewRange(d.Version, tag, tags),
+			OldHash:    locks[path].Hash,
+			NewHash:    hash,
+		},
+		NewTag: tag,
+	}
+	p.OldTag, _ = tags.Find(p.OldHash)
+
+	// List commits between the locked and new versions where the history is available
+	if p.OldTag != "" {
+		if ov, err := semver.NewVersion(p.OldTag); err == nil {
+			nv, _ := semver.NewVersion(tag)
+			p.Downgrade = nv.LessThan(ov)
+		}
+	}
+	from, to := p.OldHash, p.NewHash
+	if p.Downgrade {
+		from, to = to, from
+	}
+	if changes, err := changeLog(repo.repository, plumbing.NewHash(to), from); err == nil {
+		p.Changes = changes
+	} else if gpm.options.Verbose {
+		gpm.logf("Preview (%s) module history unavailable (%s)", path, err)
+	}
+
+	return &p, nil
+}
+
+// previewRange builds the version range selecting the provided tag, preserving the operator
+// of the existing range where the tag is the latest matching the updated range, and
+// pinning the exact tag otherwise
+func previewRange(current, tag string, tags Tags) string {
+	r := upgradeRange(current, tag)
+	if latest, _, err := tags.GetLatest(r); err == nil && latest == tag {
+		return r
+	}
+	return tag
+}
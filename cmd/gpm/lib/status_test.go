@@ -0,0 +1,137 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+
+	t.Run("Builds preview version ranges", func(t *testing.T) {
+		tags := Tags{"v0.1.0": "a", "v0.2.0": "b", "v0.2.1": "c", "v1.0.0": "d"}
+
+		tests := []struct {
+			current, tag string
+			expected     string
+		}{
+			{"^v0.1.0", "v1.0.0", "^v1.0.0"},
+			{"~v0.1.0", "v0.2.1", "~v0.2.1"},
+			{"^v0.1.0", "v0.2.0", "v0.2.0"},
+			{"v0.1.0", "v0.2.0", "v0.2.0"},
+			{"", "v1.0.0", "^v1.0.0"},
+		}
+
+		for _, test := range tests {
+			assert.EqualValues(t, test.expected, previewRange(test.current, test.tag, tags), "%+v", test)
+		}
+	})
+
+	t.Run("Detects outdated modules", func(t *testing.T) {
+		assert.True(t, ModuleStatus{Module: Module{Tag: "v0.1.0"}, Latest: "v0.2.0"}.Outdated())
+		assert.False(t, ModuleStatus{Module: Module{Tag: "v0.2.0"}, Latest: "v0.2.0"}.Outdated())
+		assert.True(t, ModuleStatus{Module: Module{}, Latest: "v0.2.0"}.Outdated())
+		assert.False(t, ModuleStatus{Module: Module{Tag: "v0.1.0"}}.Outdated())
+	})
+
+	gpmtest.RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "change-1", "v0.2.0", "change-2", "v1.0.0")
+	bare, hashes := module.URL(), module.Hashes
+
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+	o := CommonOptions{BasePath: projectDir}
+	gpm := NewGPM(&o)
+	ctx := context.Background()
+
+	pc := ProjectConfig{Name: "project"}
+	assert.Nil(t, gpm.writeProjectConfig(&pc))
+	assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+	_, err = gpm.Add(ctx, &AddOptions{Path: "lib", URL: bare, Version: "^v0.1.0", FullHistory: true})
+	assert.Nil(t, err)
+
+	t.Run("Reports locked and available tags", func(t *testing.T) {
+		status, err := gpm.Status(ctx)
+		assert.Nil(t, err)
+
+		if assert.EqualValues(t, 1, len(status)) {
+			s := status[0]
+			assert.EqualValues(t, "v0.2.0", s.Tag)
+			assert.EqualValues(t, hashes["v0.2.0"], s.Hash)
+			assert.EqualValues(t, "v1.0.0", s.Latest)
+			assert.EqualValues(t, []string{"v1.0.0", "v0.2.0", "v0.1.0"}, s.Tags)
+			assert.True(t, s.Outdated())
+			assert.EqualValues(t, "lib: v0.2.0 (latest: v1.0.0)", s.String())
+		}
+	})
+
+	t.Run("Previews upgrades", func(t *testing.T) {
+		p, err := gpm.Preview(ctx, "lib", "v1.0.0")
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, "^v0.1.0", p.OldVersion)
+		assert.EqualValues(t, "^v1.0.0", p.NewVersion)
+		assert.EqualValues(t, "v0.2.0", p.OldTag)
+		assert.EqualValues(t, hashes["v1.0.0"], p.NewHash)
+		assert.False(t, p.Downgrade)
+		if assert.EqualValues(t, 2, len(p.Changes)) {
+			assert.EqualValues(t, "v1.0.0", p.Changes[0].Summary)
+			assert.EqualValues(t, "change-2", p.Changes[1].Summary)
+		}
+		assert.Contains(t, p.String(), "+ lib: ^v1.0.0")
+
+		// Previews do not modify the project
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, hashes["v0.2.0"], locks["lib"].Hash)
+	})
+
+	t.Run("Previews downgrades", func(t *testing.T) {
+		p, err := gpm.Preview(ctx, "lib", "v0.1.0")
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, "v0.1.0", p.NewVersion)
+		assert.True(t, p.Downgrade)
+		if assert.EqualValues(t, 2, len(p.Changes)) {
+			assert.EqualValues(t, "v0.2.0", p.Changes[0].Summary)
+			assert.EqualValues(t, "change-1", p.Changes[1].Summary)
+		}
+	})
+
+	t.Run("Applies previews using upgrade", func(t *testing.T) {
+		p, err := gpm.Preview(ctx, "lib", "v0.1.0")
+		assert.Nil(t, err)
+
+		uo := UpgradeOptions{To: p.NewVersion}
+		uo.Args.Path = "lib"
+		_, err = gpm.Upgrade(ctx, &uo)
+		assert.Nil(t, err)
+
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, p.NewHash, locks["lib"].Hash)
+	})
+
+	t.Run("Rejects unknown tags", func(t *testing.T) {
+		_, err := gpm.Preview(ctx, "lib", "v9.9.9")
+		assert.EqualValues(t, ErrNoMatchingTag, Code(err))
+
+		_, err = gpm.Preview(ctx, "missing", "v0.1.0")
+		assert.EqualValues(t, ErrNoDependency, Code(err))
+	})
+}
@@ -0,0 +1,234 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Masterminds/semver"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+)
+
+const (
+	// GitModulesName is the git submodule configuration file
+	GitModulesName = ".gitmodules"
+)
+
+// Import imports git submodules from the project repository as dependencies
+func (gpm *GPM) Import(ctx context.Context, im *ImportOptions) (modules []Module, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+	locks, err := gpm.loadLockfile()
+	if err != nil {
+		return nil, err
+	}
+
+	modules, err = gpm.importSubmodules(ctx, &pc, locks)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gpm.writeProjectConfig(&pc); err != nil {
+		return nil, err
+	}
+	if err := gpm.writeLockfile(&locks); err != nil {
+		return nil, err
+	}
+
+	// Deinitialise last, as repository changes cannot be rolled back
+	if im.Deinit {
+		if err := gpm.deinitSubmodules(modules); err != nil {
+			return nil, err
+		}
+	}
+
+	return modules, nil
+}
+
+// detectProject fills missing project information from the project directory and repository
+func (gpm *GPM) detectProject(po *InitOptions) {
+	if po.Name == "" {
+		if abs, err := filepath.Abs(gpm.options.BasePath); err == nil {
+			po.Name = filepath.Base(abs)
+		}
+	}
+
+	if po.Repository == "" {
+		r, err := git.PlainOpen(gpm.options.BasePath)
+		if err != nil {
+			return
+		}
+		if remote, err := r.Remote("origin"); err == nil && len(remote.Config().URLs) > 0 {
+			po.Repository = remote.Config().URLs[0]
+		}
+	}
+
+	if gpm.options.Verbose {
+		gpm.logf("Detected project name: '%s' repository: '%s'", po.Name, po.Repository)
+	}
+}
+
+// importSubmodules adds the git submodules in the project repository to the provided
+// project config and lockfile, syncing each module to the commit recorded by the project
+func (gpm *GPM) importSubmodules(ctx context.Context, pc *ProjectConfig, locks Locks) ([]Module, error) {
+	r, err := git.PlainOpen(gpm.options.BasePath)
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error opening project repository '%s'", gpm.options.BasePath)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error opening project worktree '%s'", gpm.options.BasePath)
+	}
+	submodules, err := w.Submodules()
+	if err != nil {
+		return nil, wrapError(ErrRepository, err, "Error loading submodules")
+	}
+
+	// Import in path order for consistent project files
+	sort.Slice(submodules, func(i, j int) bool {
+		return submodules[i].Config().Path < submodules[j].Config().Path
+	})
+
+	modules := make([]Module, 0, len(submodules))
+
+	for _, s := range submodules {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+		c := s.Config()
+
+		if _, ok := pc.Dependencies.Find(c.Path); ok {
+			return nil, errorf(ErrInvalidOptions, "Dependency already bound to location '%s'", c.Path)
+		}
+
+		// Fetch the commit recorded for the submodule
+		status, err := s.Status()
+		if err != nil {
+			return nil, wrapError(ErrRepository, err, "Error reading status for submodule '%s'", c.Name)
+		}
+		if status.Expected.IsZero() {
+			return nil, errorf(ErrRepository, "No commit recorded for submodule '%s'", c.Name)
+		}
+		hash := status.Expected.String()
+
+		if gpm.options.Verbose {
+			gpm.logf("Import (%s) url: '%s' hash: '%s'", c.Path, c.URL, hash)
+		}
+
+		// Resolve full module path
+		modulePath, err := gpm.modulePath(c.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		// Clone or open the submodule and sync the recorded commit
+		d, _ := NewDependency(c.Path, c.URL, "")
+		repo, err := gpm.fetchRepo(ctx, d, modulePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := gpm.syncHash(ctx, repo, hash); err != nil {
+			return nil, err
+		}
+
+		// Check module license against project policy
+		if err := gpm.checkLicense(pc, c.Path); err != nil {
+			return nil, err
+		}
+
+		// Pin the version to the matching tag where available
+		tags, err := gpm.moduleTags(d, repo, true)
+		if err != nil {
+			return nil, err
+		}
+		tag, _ := tags.Find(hash)
+		if v, err := semver.NewVersion(tag); err == nil && v.Prerelease() != "" {
+			d.Prerelease = true
+		}
+		d.Version = tag
+
+		pc.Dependencies = append(pc.Dependencies, *d)
+		locks[c.Path], err = gpm.lockModule(d, repo, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		modules = append(modules, Module{Dependency: *d, Tag: tag, Hash: hash})
+	}
+
+	return modules, nil
+}
+
+// deinitSubmodules removes the provided modules from the git submodule configuration,
+// repository config and index of the project repository
+func (gpm *GPM) deinitSubmodules(modules []Module) error {
+	r, err := git.PlainOpen(gpm.options.BasePath)
+	if err != nil {
+		return wrapError(ErrRepository, err, "Error opening project repository '%s'", gpm.options.BasePath)
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return wrapError(ErrRepository, err, "Error loading project repository config")
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return wrapError(ErrRepository, err, "Error loading project repository index")
+	}
+
+	modulesPath, err := gpm.options.fullPath(GitModulesName)
+	if err != nil {
+		return err
+	}
+	d, err := ioutil.ReadFile(modulesPath)
+	if err != nil {
+		return wrapError(ErrFilesystem, err, "Error reading file '%s'", GitModulesName)
+	}
+	gitModules := config.NewModules()
+	if err := gitModules.Unmarshal(d); err != nil {
+		return wrapError(ErrRepository, err, "Error decoding file '%s'", GitModulesName)
+	}
+
+	for _, m := range modules {
+		if gpm.options.Verbose {
+			gpm.logf("Import (%s) deinitialising submodule", m.Path)
+		}
+
+		for name, s := range gitModules.Submodules {
+			if s.Path == m.Path {
+				delete(gitModules.Submodules, name)
+				delete(cfg.Submodules, name)
+			}
+		}
+
+		idx.Remove(m.Path)
+	}
+
+	if err := r.Storer.SetIndex(idx); err != nil {
+		return wrapError(ErrRepository, err, "Error writing project repository index")
+	}
+	if err := r.Storer.SetConfig(cfg); err != nil {
+		return wrapError(ErrRepository, err, "Error writing project repository config")
+	}
+
+	// Remove the submodule file when no submodules remain
+	if len(gitModules.Submodules) == 0 {
+		return wrapError(ErrFilesystem, os.Remove(modulesPath), "Error removing file '%s'", GitModulesName)
+	}
+
+	d, err = gitModules.Marshal()
+	if err != nil {
+		return wrapError(ErrRepository, err, "Error encoding file '%s'", GitModulesName)
+	}
+
+	return wrapError(ErrFilesystem, writeFile(modulesPath, d), "Error writing file '%s'", GitModulesName)
+}
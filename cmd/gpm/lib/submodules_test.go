@@ -0,0 +1,93 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubmodules(t *testing.T) {
+	gpmtest.RequireGit(t)
+
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	// Create a module repository with tagged and untagged commits
+	module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0", "untagged")
+
+	// Create a project using the module as submodules
+	projectDir := filepath.Join(testDir, "project")
+	assert.Nil(t, os.Mkdir(projectDir, 0755))
+	gpmtest.Git(t, projectDir, "init", "-q")
+	gpmtest.Git(t, projectDir, "remote", "add", "origin", "https://github.com/ryankurte/test-project")
+	gpmtest.Git(t, projectDir, "submodule", "-q", "add", module.URL(), "lib/tagged")
+	gpmtest.Git(t, filepath.Join(projectDir, "lib/tagged"), "checkout", "-q", "v0.1.0")
+	gpmtest.Git(t, projectDir, "submodule", "-q", "add", module.URL(), "lib/untagged")
+	gpmtest.Git(t, projectDir, "add", "-A")
+	gpmtest.Git(t, projectDir, "commit", "-q", "-m", "Add submodules")
+
+	taggedHash := module.Hashes["v0.1.0"]
+	untaggedHash := module.Hashes["untagged"]
+
+	o := CommonOptions{BasePath: projectDir, Verbose: true}
+	gpm := GPM{options: &o}
+
+	t.Run("Initialises projects from submodules", func(t *testing.T) {
+		pc, err := gpm.Init(context.Background(), &InitOptions{FromSubmodules: true, Deinit: true})
+		assert.Nil(t, err)
+
+		// Check project information was detected
+		assert.EqualValues(t, "project", pc.Name)
+		assert.EqualValues(t, "https://github.com/ryankurte/test-project", pc.Repository)
+
+		// Check dependencies and locks match the submodules
+		if assert.EqualValues(t, 2, len(pc.Dependencies)) {
+			assert.EqualValues(t, Dependency{Path: "lib/tagged", URL: module.URL(), Version: "v0.1.0"}, pc.Dependencies[0])
+			assert.EqualValues(t, Dependency{Path: "lib/untagged", URL: module.URL()}, pc.Dependencies[1])
+		}
+
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, taggedHash, locks["lib/tagged"].Hash)
+		assert.EqualValues(t, untaggedHash, locks["lib/untagged"].Hash)
+	})
+
+	t.Run("Deinitialises imported submodules", func(t *testing.T) {
+		_, err := os.Stat(filepath.Join(projectDir, GitModulesName))
+		assert.True(t, os.IsNotExist(err))
+
+		assert.Empty(t, gpmtest.Git(t, projectDir, "ls-files", "--stage", "lib"))
+	})
+
+	t.Run("Syncs imported modules", func(t *testing.T) {
+		_, err := gpm.Sync(context.Background(), &SyncOptions{})
+		assert.Nil(t, err)
+	})
+
+	t.Run("Moves imported modules", func(t *testing.T) {
+		mo := MoveOptions{}
+		mo.Args.From, mo.Args.To = "lib/tagged", "vendor/lib/tagged"
+		_, err := gpm.Move(context.Background(), &mo)
+		assert.Nil(t, err)
+
+		repo := NewRepo(filepath.Join(projectDir, "vendor/lib/tagged"), module.URL())
+		if assert.Nil(t, repo.Open()) {
+			head, err := repo.Head()
+			assert.Nil(t, err)
+			assert.EqualValues(t, taggedHash, head)
+		}
+		assert.Empty(t, gpmtest.Git(t, filepath.Join(projectDir, "vendor/lib/tagged"), "status", "--porcelain"))
+
+		_, err = gpm.Sync(context.Background(), &SyncOptions{})
+		assert.Nil(t, err)
+	})
+}
@@ -2,32 +2,95 @@ package gpm
 
 import (
 	"fmt"
+	"regexp"
 	"sort"
+	"strings"
 
 	"github.com/Masterminds/semver"
 	"gopkg.in/src-d/go-git.v4"
-	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
-// Tags type is a map of semver compatible git annotated tags and hashes
+// rangeSeparators splits a semver range into its comparisons
+var rangeSeparators = regexp.MustCompile(`[\s,|]+`)
+
+// Tags type is a map of semver compatible git tags and commit hashes
 type Tags map[string]string
 
-// NewTagsFromRepo creates a new Tags object from the provided git repository
+// NewTagsFromRepo creates a new Tags object from the annotated and lightweight tags in the provided git repository
 func NewTagsFromRepo(repo *git.Repository) (Tags, error) {
 	tags := make(Tags)
-	tagIter, _ := repo.TagObjects()
-	err := tagIter.ForEach(func(t *object.Tag) error {
-		commit, err := t.Commit()
-		if err != nil {
+	refIter, err := repo.Tags()
+	if err != nil {
+		return nil, err
+	}
+	err = refIter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+
+		// Annotated tags are resolved to the tagged commit
+		t, err := repo.TagObject(ref.Hash())
+		if err == nil {
+			if t.TargetType == plumbing.CommitObject {
+				tags[name] = t.Target.String()
+			} else if commit, err := t.Commit(); err == nil {
+				tags[name] = commit.Hash.String()
+			}
 			return nil
 		}
-		tags[t.Name] = commit.Hash.String()
+
+		// Lightweight tags reference commits directly, which may not be available in shallow clones
+		if obj, err := repo.Object(plumbing.AnyObject, ref.Hash()); err == nil && obj.Type() != plumbing.CommitObject {
+			return nil
+		}
+		tags[name] = ref.Hash().String()
 		return nil
 	})
 	return tags, err
 }
 
-// Filter filters tags by a given semver filter
+// Select selects the tags starting with the provided prefix, stripping the prefix from the
+// returned tag names and excluding prereleases unless enabled. Skipped tags are returned
+// with the reason they were skipped.
+func (tags *Tags) Select(prefix string, prerelease bool) (Tags, map[string]string) {
+	selected := make(Tags)
+	skipped := make(map[string]string)
+
+	for t, h := range *tags {
+		if !strings.HasPrefix(t, prefix) {
+			skipped[t] = fmt.Sprintf("missing prefix '%s'", prefix)
+			continue
+		}
+		name := strings.TrimPrefix(t, prefix)
+
+		v, err := semver.NewVersion(name)
+		if err != nil {
+			skipped[t] = err.Error()
+			continue
+		}
+		if !prerelease && v.Prerelease() != "" {
+			skipped[t] = "prerelease"
+			continue
+		}
+
+		selected[name] = h
+	}
+
+	return selected, skipped
+}
+
+// namesPrerelease checks whether any version in a semver range is a prerelease
+// (ie. ^1.2.0-rc.1), in which case prerelease tags must be selected for the range to match
+func namesPrerelease(version string) bool {
+	for _, f := range rangeSeparators.Split(version, -1) {
+		v, err := semver.NewVersion(strings.TrimLeft(f, "=<>~^!"))
+		if err == nil && v.Prerelease() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter filters tags by a given semver filter, skipping non-semver tags
 func (tags *Tags) Filter(filter string) (Tags, error) {
 	filtered := make(Tags)
 	var err error
@@ -46,7 +109,6 @@ func (tags *Tags) Filter(filter string) (Tags, error) {
 		// Attempt to parse value
 		v, err := semver.NewVersion(t)
 		if err != nil {
-			fmt.Printf("Tag: %s parsing failed (%s)\n", t, err)
 			continue
 		}
 		// Apply filter if available
@@ -97,3 +159,20 @@ func (tags *Tags) GetLatest(filter string) (string, string, error) {
 	latest := ordered[len(ordered)-1]
 	return latest, filtered[latest], nil
 }
+
+// Find finds the latest semver compliant tag pointing at the provided commit hash
+func (tags *Tags) Find(hash string) (string, bool) {
+	matching := make(Tags)
+	for t, h := range *tags {
+		if h == hash {
+			matching[t] = h
+		}
+	}
+
+	latest, _, err := matching.GetLatest("")
+	if err != nil || latest == "" {
+		return "", false
+	}
+
+	return latest, true
+}
@@ -1,9 +1,13 @@
 package gpm
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/src-d/go-git.v4"
 )
 
 func TestTags(t *testing.T) {
@@ -47,4 +51,93 @@ func TestTags(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("Tag prefix and prerelease selection", func(t *testing.T) {
+		tests := []struct {
+			Name       string
+			Prefix     string
+			Prerelease bool
+			In         []string
+			Out        []string
+			Skipped    []string
+		}{
+			{
+				"Skips non-semver tags",
+				"", false,
+				[]string{"assbd", "v1.2.3"},
+				[]string{"v1.2.3"},
+				[]string{"assbd"},
+			}, {
+				"Skips prereleases",
+				"", false,
+				[]string{"v1.2.3", "v1.3.0-rc.1"},
+				[]string{"v1.2.3"},
+				[]string{"v1.3.0-rc.1"},
+			}, {
+				"Includes prereleases when enabled",
+				"", true,
+				[]string{"v1.2.3", "v1.3.0-rc.1"},
+				[]string{"v1.2.3", "v1.3.0-rc.1"},
+				[]string{},
+			}, {
+				"Strips tag prefixes",
+				"mylib-", false,
+				[]string{"mylib-v1.2.3", "otherlib-v2.0.0", "v3.0.0"},
+				[]string{"v1.2.3"},
+				[]string{"otherlib-v2.0.0", "v3.0.0"},
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.Name, func(t *testing.T) {
+				tags := make(Tags)
+				for _, v := range test.In {
+					tags[v] = v
+				}
+				selected, skipped := tags.Select(test.Prefix, test.Prerelease)
+				sorted, err := selected.Sort()
+				assert.Nil(t, err)
+				assert.Equal(t, test.Out, sorted)
+
+				names := make([]string, 0, len(skipped))
+				for name := range skipped {
+					names = append(names, name)
+				}
+				assert.ElementsMatch(t, test.Skipped, names)
+			})
+		}
+	})
+
+	t.Run("Detects prerelease ranges", func(t *testing.T) {
+		for _, r := range []string{"^v1.2.0-rc.1", ">= 1.0.0, < 2.0.0-beta", "1.0.0 || =2.0.0-alpha"} {
+			assert.True(t, namesPrerelease(r), r)
+		}
+		for _, r := range []string{"", "^v1.2.0", "1.0.0 - 2.0.0", ">= 1.0.0, < 2.0.0"} {
+			assert.False(t, namesPrerelease(r), r)
+		}
+	})
+
+	t.Run("Loads annotated, lightweight and signed tags", func(t *testing.T) {
+		gpmtest.RequireGit(t)
+
+		dir, err := ioutil.TempDir("", "gpm-test")
+		if err != nil {
+			t.FailNow()
+		}
+		defer os.RemoveAll(dir)
+
+		fixture := gpmtest.NewRepo(t, dir)
+		first := fixture.Commit("first", nil)
+		fixture.Tag("v0.1.0", gpmtest.Annotated)
+		second := fixture.Commit("second", nil)
+		fixture.Tag("v0.2.0-rc.1", gpmtest.Signed)
+		fixture.Tag("v0.2.0", gpmtest.Lightweight)
+
+		r, err := git.PlainOpen(fixture.Path)
+		assert.Nil(t, err)
+		tags, err := NewTagsFromRepo(r)
+		assert.Nil(t, err)
+
+		assert.EqualValues(t, Tags{"v0.1.0": first, "v0.2.0-rc.1": second, "v0.2.0": second}, tags)
+	})
 }
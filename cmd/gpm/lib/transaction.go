@@ -0,0 +1,413 @@
+package gpm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// ProjectLockName is the advisory lock file used to serialise gpm invocations on a project
+	ProjectLockName = ".gpm.lock"
+
+	// JournalName is the transaction journal used to roll back commands interrupted by a crash
+	JournalName = ".gpm-journal.json"
+
+	// lockPollInterval is the interval between attempts to acquire a held project lock
+	lockPollInterval = 100 * time.Millisecond
+)
+
+// transaction tracks changes to project files and module worktrees so that
+// a failed command can be rolled back to the original project state
+type transaction struct {
+	lockPath    string
+	journalPath string
+	files       map[string][]byte // Original file contents, nil where the file did not exist
+	cloned      []string          // Module paths created by the transaction
+	checkouts   []checkout        // Module checkouts performed by the transaction
+	moves       []move            // Module worktrees moved by the transaction
+}
+
+// checkout records the commit a module was at prior to being checked out
+type checkout struct {
+	repo *Repo
+	hash string
+}
+
+// move records the original and new paths of a moved module worktree
+type move struct {
+	repo *Repo
+	from string
+	to   string
+}
+
+// journal is the on-disk record of a transaction, written before each change so that
+// changes from an interrupted command can be rolled back by the next command
+type journal struct {
+	Files     map[string][]byte `json:"files"`
+	Cloned    []string          `json:"cloned"`
+	Checkouts []journalCheckout `json:"checkouts"`
+	Moves     []journalMove     `json:"moves"`
+}
+
+// journalCheckout records the commit a module was at prior to being checked out
+type journalCheckout struct {
+	Path string `json:"path"`
+	URL  string `json:"url"`
+	Hash string `json:"hash"`
+}
+
+// journalMove records the original and new paths of a moved module worktree
+type journalMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// begin acquires the project lock and starts a new transaction, first rolling back
+// any transaction interrupted by a crash.
+// Callers must call end with the command result to commit or roll back changes.
+func (gpm *GPM) begin(ctx context.Context) (*transaction, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	lockPath, err := gpm.options.fullPath(ProjectLockName)
+	if err != nil {
+		return nil, err
+	}
+	if err := acquireLock(ctx, lockPath, gpm.options.LockTimeout); err != nil {
+		return nil, err
+	}
+
+	journalPath, err := gpm.options.fullPath(JournalName)
+	if err != nil {
+		os.Remove(lockPath)
+		return nil, err
+	}
+	if err := gpm.recover(ctx, journalPath); err != nil {
+		os.Remove(lockPath)
+		return nil, err
+	}
+
+	tx := transaction{lockPath: lockPath, journalPath: journalPath, files: make(map[string][]byte)}
+
+	// Snapshot project files for restoring on failure
+	for _, f := range append([]string{LockfileName, GitIgnoreName, GitAttributesName}, ProjectConfigNames...) {
+		p, err := gpm.options.fullPath(f)
+		if err != nil {
+			os.Remove(lockPath)
+			return nil, err
+		}
+		d, err := ioutil.ReadFile(p)
+		if err != nil && !os.IsNotExist(err) {
+			os.Remove(lockPath)
+			return nil, wrapError(ErrFilesystem, err, "Error reading file '%s'", f)
+		}
+		tx.files[p] = d
+	}
+
+	// Snapshot the sync state, so failed commands restore the state of kept modules
+	statePath, err := gpm.syncStatePath()
+	if err != nil {
+		os.Remove(lockPath)
+		return nil, err
+	}
+	if tx.files[statePath], err = ioutil.ReadFile(statePath); err != nil && !os.IsNotExist(err) {
+		os.Remove(lockPath)
+		return nil, wrapError(ErrFilesystem, err, "Error reading file '%s'", SyncStateName)
+	}
+
+	if err := tx.save(); err != nil {
+		os.Remove(lockPath)
+		return nil, err
+	}
+
+	gpm.tx = &tx
+
+	return &tx, nil
+}
+
+// end completes the current transaction, rolling back changes if the command
+// failed, and releases the project lock
+func (gpm *GPM) end(err *error) {
+	tx := gpm.tx
+	gpm.tx = nil
+
+	if *err != nil {
+		if gpm.options.Verbose {
+			gpm.logf("Rolling back changes (%s)", *err)
+		}
+		if rerr := tx.rollback(context.Background()); rerr != nil {
+			gpm.logf("Error rolling back changes (%s)", rerr)
+			// Keep the journal so the next command retries the rollback
+			os.Remove(tx.lockPath)
+			return
+		}
+	}
+
+	os.Remove(tx.journalPath)
+	os.Remove(tx.lockPath)
+}
+
+// recover rolls back the changes recorded in the journal of an interrupted transaction
+func (gpm *GPM) recover(ctx context.Context, path string) error {
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return wrapError(ErrFilesystem, err, "Error reading journal '%s'", path)
+	}
+
+	j := journal{}
+	if err := json.Unmarshal(d, &j); err != nil {
+		return wrapError(ErrFilesystem, err, "Invalid journal '%s' (remove to skip recovery)", path)
+	}
+
+	gpm.logf("Warning: rolling back changes from an interrupted gpm command\n")
+
+	tx := transaction{files: j.Files, cloned: j.Cloned}
+	for _, c := range j.Checkouts {
+		repo := NewRepo(c.Path, c.URL)
+		if err := repo.Open(); err != nil {
+			continue
+		}
+		tx.checkouts = append(tx.checkouts, checkout{repo: repo, hash: c.Hash})
+	}
+	for _, m := range j.Moves {
+		// Moves are recorded prior to moving, so may not have taken place
+		if _, err := os.Stat(m.To); err != nil {
+			continue
+		}
+		tx.moves = append(tx.moves, move{repo: NewRepo(m.To, ""), from: m.From, to: m.To})
+	}
+
+	if err := tx.rollback(ctx); err != nil {
+		return wrapError(ErrFilesystem, err, "Error rolling back interrupted command (remove '%s' to skip recovery)", path)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return wrapError(ErrFilesystem, err, "Error removing journal '%s'", path)
+	}
+
+	return nil
+}
+
+// track snapshots an additional file written by the transaction, so the file is restored
+// (or removed where it did not exist) on failure
+func (tx *transaction) track(path string) error {
+	if _, ok := tx.files[path]; ok {
+		return nil
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return wrapError(ErrFilesystem, err, "Error reading file '%s'", path)
+	}
+	tx.files[path] = d
+	return tx.save()
+}
+
+// save writes the transaction journal, so changes recorded so far are rolled back
+// if the command is interrupted
+func (tx *transaction) save() error {
+	j := journal{Files: tx.files, Cloned: tx.cloned, Checkouts: make([]journalCheckout, 0), Moves: make([]journalMove, 0)}
+	for _, c := range tx.checkouts {
+		j.Checkouts = append(j.Checkouts, journalCheckout{Path: c.repo.path, URL: c.repo.url, Hash: c.hash})
+	}
+	for _, m := range tx.moves {
+		j.Moves = append(j.Moves, journalMove{From: m.from, To: m.to})
+	}
+
+	d, err := json.Marshal(&j)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(tx.journalPath, d); err != nil {
+		return wrapError(ErrFilesystem, err, "Error writing journal '%s'", tx.journalPath)
+	}
+
+	return nil
+}
+
+// cloneRepo clones a module repository, recording the new module path in the current transaction
+func (gpm *GPM) cloneRepo(ctx context.Context, repo *Repo) error {
+	// Record clones prior to cloning so partial checkouts are cleaned up
+	if _, err := os.Stat(repo.path); os.IsNotExist(err) && gpm.tx != nil {
+		gpm.tx.cloned = append(gpm.tx.cloned, repo.path)
+		if err := gpm.tx.save(); err != nil {
+			return err
+		}
+	}
+
+	gpm.emitProgress(ProgressEvent{Type: ProgressCloneStarted, Path: gpm.repoPath(repo), URL: repo.url})
+	return wrapError(ErrRemote, contextError(ctx, repo.Clone(ctx)), "Error cloning '%s'", repo.url)
+}
+
+// syncHash checks out a module commit, recording the previous commit in the current transaction.
+// LFS modules, which are checked out by force, are refused with ErrDirtyModule if modified.
+func (gpm *GPM) syncHash(ctx context.Context, repo *Repo, hash string) error {
+	// Refuse forced LFS checkouts over local changes, prior to recording the checkout for rollback
+	if repo.lfs != "" {
+		if err := repo.requireUnmodified(); err != nil {
+			return wrapError(ErrRepository, err, "Error checking status of '%s'", repo.path)
+		}
+	}
+
+	if gpm.tx != nil {
+		if head, err := repo.Head(); err == nil {
+			gpm.tx.checkouts = append(gpm.tx.checkouts, checkout{repo: repo, hash: head})
+			if err := gpm.tx.save(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Fetch additional history where the commit is not available in a shallow clone
+	if hash != "" && !repo.HasCommit(hash) {
+		if gpm.options.Verbose {
+			gpm.logf("Commit '%s' not found in '%s', deepening", hash, repo.path)
+		}
+		if err := repo.Deepen(ctx, hash); err != nil {
+			return wrapError(ErrRemote, contextError(ctx, err), "Error fetching commit '%s' for '%s'", hash, repo.path)
+		}
+	}
+
+	if err := repo.SyncHash(ctx, hash); err != nil {
+		return wrapError(ErrRepository, contextError(ctx, err), "Error checking out '%s' in '%s'", hash, repo.path)
+	}
+
+	// Mark the module as gpm managed so orphaned modules can be cleaned up
+	if err := gpm.markModule(repo); err != nil {
+		return wrapError(ErrFilesystem, err, "Error writing module marker in '%s'", repo.path)
+	}
+
+	gpm.emitProgress(ProgressEvent{Type: ProgressCheckoutDone, Path: gpm.repoPath(repo), Hash: hash})
+
+	return nil
+}
+
+// rollback restores module worktrees and project files to their original states
+func (tx *transaction) rollback(ctx context.Context) error {
+	var errs []error
+
+	// Revert checkouts in reverse order
+	for i := len(tx.checkouts) - 1; i >= 0; i-- {
+		c := tx.checkouts[i]
+		if err := c.repo.SyncHash(ctx, c.hash); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Return moved modules to their original paths
+	for i := len(tx.moves) - 1; i >= 0; i-- {
+		m := tx.moves[i]
+		if err := m.repo.Move(m.from); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Remove newly cloned modules
+	for _, p := range tx.cloned {
+		if err := os.RemoveAll(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Restore project files
+	for p, d := range tx.files {
+		var err error
+		if d == nil {
+			err = os.Remove(p)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		} else {
+			err = writeFile(p, d)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d errors during rollback (first: %s)", len(errs), errs[0])
+	}
+
+	return nil
+}
+
+// acquireLock creates an advisory lock file recording the process ID and host, waiting up
+// to the provided timeout for any existing lock to be released or until the context is
+// canceled. Locks left by processes on this host that are no longer running are removed.
+func acquireLock(ctx context.Context, path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	host, _ := os.Hostname()
+
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d %s\n", os.Getpid(), host)
+			return f.Close()
+		}
+		if !os.IsExist(err) {
+			return wrapError(ErrFilesystem, err, "Error creating lock file '%s'", path)
+		}
+		if removeStaleLock(path, host) {
+			continue
+		}
+		if time.Now().After(deadline) {
+			return errorf(ErrProjectLocked, "Project is locked by another gpm process (remove '%s' if stale)", path)
+		}
+
+		select {
+		case <-ctx.Done():
+			return canceled(ctx)
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// removeStaleLock removes a lock file held by a process on this host that is no longer
+// running, returning whether the lock was removed
+func removeStaleLock(path, host string) bool {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	f := strings.Fields(string(d))
+	if len(f) != 2 || f[1] != host {
+		return false
+	}
+	pid, err := strconv.Atoi(f[0])
+	if err != nil || processAlive(pid) {
+		return false
+	}
+
+	// Move the lock aside before removing it, restoring it if another process replaced it
+	stale := fmt.Sprintf("%s.stale.%d", path, os.Getpid())
+	if err := os.Rename(path, stale); err != nil {
+		return false
+	}
+	defer os.Remove(stale)
+	if moved, err := ioutil.ReadFile(stale); err != nil || string(moved) != string(d) {
+		os.Link(stale, path)
+		return false
+	}
+
+	return true
+}
+
+// processAlive checks whether a process with the provided ID is running
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
+}
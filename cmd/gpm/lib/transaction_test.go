@@ -0,0 +1,131 @@
+package gpm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransaction(t *testing.T) {
+	// Setup temporary test directory
+	testDir, err := ioutil.TempDir("", "gpm-test")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(testDir)
+
+	o := CommonOptions{
+		BasePath: testDir,
+		Verbose:  true,
+	}
+
+	gpm := GPM{options: &o}
+
+	_, err = gpm.Init(context.Background(), &InitOptions{Name: "Test Project", Repository: "https://github.com/ryankurte/test-repo"})
+	assert.Nil(t, err)
+
+	t.Run("Writes files atomically", func(t *testing.T) {
+		p := filepath.Join(testDir, "atomic.yml")
+
+		assert.Nil(t, writeFile(p, []byte("a: b\n")))
+		assert.Nil(t, writeFile(p, []byte("c: d\n")))
+
+		d, err := ioutil.ReadFile(p)
+		assert.Nil(t, err)
+		assert.Equal(t, "c: d\n", string(d))
+
+		// Check no temporary files are left behind
+		files, err := filepath.Glob(filepath.Join(testDir, ".atomic.yml.tmp*"))
+		assert.Nil(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("Fails while the project is locked", func(t *testing.T) {
+		lockPath := filepath.Join(testDir, ProjectLockName)
+		assert.Nil(t, acquireLock(context.Background(), lockPath, 0))
+		defer os.Remove(lockPath)
+
+		o.LockTimeout = 200 * time.Millisecond
+		defer func() { o.LockTimeout = 0 }()
+
+		_, err := gpm.Sync(context.Background(), &SyncOptions{})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Removes stale locks", func(t *testing.T) {
+		lockPath := filepath.Join(testDir, ProjectLockName)
+		host, err := os.Hostname()
+		assert.Nil(t, err)
+
+		// Lock held by an exited process on this host
+		cmd := exec.Command("go", "version")
+		assert.Nil(t, cmd.Run())
+		assert.Nil(t, ioutil.WriteFile(lockPath, []byte(fmt.Sprintf("%d %s\n", cmd.Process.Pid, host)), 0644))
+		assert.Nil(t, acquireLock(context.Background(), lockPath, 0))
+		assert.Nil(t, os.Remove(lockPath))
+
+		// Locks held by processes on other hosts are kept
+		assert.Nil(t, ioutil.WriteFile(lockPath, []byte(fmt.Sprintf("%d other-%s\n", cmd.Process.Pid, host)), 0644))
+		assert.EqualValues(t, ErrProjectLocked, Code(acquireLock(context.Background(), lockPath, 0)))
+		assert.Nil(t, os.Remove(lockPath))
+	})
+
+	t.Run("Releases the project lock", func(t *testing.T) {
+		_, err := gpm.Sync(context.Background(), &SyncOptions{})
+		assert.Nil(t, err)
+
+		_, err = os.Stat(filepath.Join(testDir, ProjectLockName))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Rolls back failed commands", func(t *testing.T) {
+		config, err := ioutil.ReadFile(filepath.Join(testDir, ProjectConfigName))
+		assert.Nil(t, err)
+
+		_, err = gpm.Add(context.Background(), &AddOptions{Path: "missing", URL: filepath.Join(testDir, "missing-repo")})
+		assert.NotNil(t, err)
+
+		// Check project file is unchanged and partial clones are removed
+		d, err := ioutil.ReadFile(filepath.Join(testDir, ProjectConfigName))
+		assert.Nil(t, err)
+		assert.Equal(t, string(config), string(d))
+
+		_, err = os.Stat(filepath.Join(testDir, "missing"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Rolls back commands interrupted by a crash", func(t *testing.T) {
+		configPath := filepath.Join(testDir, ProjectConfigName)
+		config, err := ioutil.ReadFile(configPath)
+		assert.Nil(t, err)
+
+		// Interrupt a command between writing the project file and cloning a module
+		tx, err := gpm.begin(context.Background())
+		assert.Nil(t, err)
+		assert.Nil(t, writeFile(configPath, []byte("name: partial\n")))
+		clonePath := filepath.Join(testDir, "partial")
+		tx.cloned = append(tx.cloned, clonePath)
+		assert.Nil(t, tx.save())
+		assert.Nil(t, os.MkdirAll(clonePath, 0755))
+		gpm.tx = nil
+		assert.Nil(t, os.Remove(tx.lockPath))
+
+		_, err = gpm.Sync(context.Background(), &SyncOptions{})
+		assert.Nil(t, err)
+
+		d, err := ioutil.ReadFile(configPath)
+		assert.Nil(t, err)
+		assert.Equal(t, string(config), string(d))
+		for _, p := range []string{clonePath, filepath.Join(testDir, JournalName)} {
+			_, err = os.Stat(p)
+			assert.True(t, os.IsNotExist(err), p)
+		}
+	})
+}
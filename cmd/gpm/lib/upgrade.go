@@ -0,0 +1,237 @@
+package gpm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// Upgrade describes the changes applied to a module by an upgrade
+type Upgrade struct {
+	Path       string `json:"path"`
+	OldVersion string `json:"oldVersion"`
+	NewVersion string `json:"newVersion"`
+	OldHash    string `json:"oldHash"`
+	NewHash    string `json:"newHash"`
+}
+
+// String formats an upgrade as a human readable summary line
+func (u Upgrade) String() string {
+	return fmt.Sprintf("%s: '%s' -> '%s' (%s -> %s)", u.Path, u.OldVersion, u.NewVersion, shortHash(u.OldHash), shortHash(u.NewHash))
+}
+
+// Upgrade rewrites module version ranges based on the tags available in each module repository,
+// then updates the lockfile and worktrees to match
+func (gpm *GPM) Upgrade(ctx context.Context, uo *UpgradeOptions) (upgrades []Upgrade, err error) {
+	if _, err := gpm.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer gpm.end(&err)
+
+	pc, err := gpm.loadProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+	locks, err := gpm.loadLockfile()
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate upgrade options
+	if err := uo.validate(); err != nil {
+		return nil, err
+	}
+	if uo.Args.Path != "" {
+		if _, ok := pc.Dependencies.Find(uo.Args.Path); !ok {
+			return nil, errorf(ErrNoDependency, "No dependency bound to location '%s'", uo.Args.Path)
+		}
+	}
+
+	upgrades = make([]Upgrade, 0)
+
+	for _, v := range pc.Dependencies {
+		if uo.Args.Path != "" && uo.Args.Path != v.Path {
+			continue
+		}
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+
+		// Resolve full module path
+		modulePath, err := gpm.modulePath(v.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		// Clone or open and update repo depending on current state
+		repo, err := gpm.fetchRepo(ctx, &v, modulePath)
+		if err != nil {
+			return nil, err
+		}
+
+		// Create a tag map, including prereleases when upgrading to the latest tag or a prerelease range
+		tags, err := gpm.moduleTags(&v, repo, uo.Latest || namesPrerelease(uo.To))
+		if err != nil {
+			return nil, err
+		}
+
+		// Determine the currently locked version
+		current, ok := tags.Find(locks[v.Path].Hash)
+		if !ok {
+			current, _, err = tags.GetLatest(v.Version)
+			if err != nil {
+				return nil, wrapError(ErrInvalidVersion, err, "Invalid version range '%s' for module '%s'", v.Version, v.Path)
+			}
+		}
+
+		// Select the tag to upgrade to
+		filter := uo.filter(v.Version, current)
+		latestTag, latestHash, err := tags.GetLatest(filter)
+		if err != nil {
+			return nil, wrapError(ErrInvalidVersion, err, "Invalid version range '%s' for module '%s'", filter, v.Path)
+		}
+		if latestTag == "" {
+			if uo.To != "" {
+				return nil, errorf(ErrNoMatchingTag, "No tags matching '%s' for module '%s'", uo.To, v.Path)
+			}
+			if gpm.options.Verbose {
+				gpm.logf("Upgrade (%s) no tags matching '%s', skipping", v.Path, filter)
+			}
+			continue
+		}
+		if gpm.options.Verbose {
+			gpm.logf("Upgrade (%s) Latest matching tag: %s hash: %s", v.Path, latestTag, latestHash)
+		}
+
+		// Compute the new version range
+		version := uo.To
+		if version == "" {
+			version = upgradeRange(v.Version, latestTag)
+		}
+		if version == v.Version && latestHash == locks[v.Path].Hash {
+			if gpm.options.Verbose {
+				gpm.logf("Upgrade (%s) already at '%s', skipping", v.Path, latestTag)
+			}
+			continue
+		}
+
+		// Sync latest matching hash into worktree
+		if err := gpm.syncHash(ctx, repo, latestHash); err != nil {
+			return nil, err
+		}
+
+		// Check module license against project policy
+		if err := gpm.checkLicense(&pc, v.Path); err != nil {
+			return nil, err
+		}
+
+		upgrades = append(upgrades, Upgrade{
+			Path:       v.Path,
+			OldVersion: v.Version,
+			NewVersion: version,
+			OldHash:    locks[v.Path].Hash,
+			NewHash:    latestHash,
+		})
+
+		// Keep prerelease tags selectable for later updates where a prerelease is selected
+		if tv, err := semver.NewVersion(latestTag); err == nil && tv.Prerelease() != "" {
+			v.Prerelease = true
+		}
+
+		v.Version = version
+		pc.Dependencies.Set(v.Path, v)
+		locks[v.Path], err = gpm.lockModule(&v, repo, latestHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := gpm.writeProjectConfig(&pc); err != nil {
+		return nil, err
+	}
+	if err := gpm.writeLockfile(&locks); err != nil {
+		return nil, err
+	}
+
+	return upgrades, nil
+}
+
+// validate checks that at most one upgrade strategy is selected and that any explicit range is valid
+func (uo *UpgradeOptions) validate() error {
+	count := 0
+	for _, v := range []bool{uo.To != "", uo.Latest, uo.Major, uo.Minor} {
+		if v {
+			count++
+		}
+	}
+	if count > 1 {
+		return errorf(ErrInvalidOptions, "Only one of --to, --latest, --major or --minor may be specified")
+	}
+
+	if uo.To != "" {
+		if _, err := semver.NewConstraint(uo.To); err != nil {
+			return wrapError(ErrInvalidVersion, err, "Invalid version range '%s'", uo.To)
+		}
+	}
+
+	return nil
+}
+
+// filter builds the semver filter used to select the upgrade tag for a module
+func (uo *UpgradeOptions) filter(version, current string) string {
+	switch {
+	case uo.To != "":
+		return uo.To
+	case uo.Latest:
+		// No filter matches all tags, including prereleases
+		return ""
+	}
+
+	// Never downgrade from the currently locked version
+	lower := ">= 0.0.0"
+	cv, err := semver.NewVersion(current)
+	if err == nil {
+		lower = fmt.Sprintf(">= %s", cv.String())
+	}
+
+	if uo.Major {
+		return lower
+	}
+
+	// Default to minor upgrades, staying within the current major version
+	if err != nil {
+		return version
+	}
+	return fmt.Sprintf("%s, < %d.0.0", lower, cv.Major()+1)
+}
+
+// upgradeRange builds a version range for the provided tag, preserving the
+// operator used in the existing range where possible
+func upgradeRange(current, tag string) string {
+	current = strings.TrimSpace(current)
+
+	switch {
+	case strings.HasPrefix(current, "^"), strings.HasPrefix(current, "~"):
+		return current[:1] + tag
+	case current != "":
+		// Exact versions remain pinned
+		if _, err := semver.NewVersion(current); err == nil {
+			return tag
+		}
+	}
+
+	return "^" + tag
+}
+
+// shortHash truncates a commit hash for display
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	if hash == "" {
+		return "none"
+	}
+	return hash
+}
@@ -0,0 +1,155 @@
+package gpm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryankurte/utils/cmd/gpm/lib/gpmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpgrade(t *testing.T) {
+
+	t.Run("Computes upgrade ranges", func(t *testing.T) {
+		tests := []struct {
+			Name    string
+			Current string
+			Tag     string
+			Out     string
+		}{
+			{"Defaults to caret ranges", "", "v0.2.0", "^v0.2.0"},
+			{"Preserves caret ranges", "^0.1.0", "v0.2.0", "^v0.2.0"},
+			{"Preserves tilde ranges", "~0.1.0", "v0.1.4", "~v0.1.4"},
+			{"Preserves pinned versions", "v0.1.0", "v0.2.0", "v0.2.0"},
+			{"Replaces complex ranges", ">= 0.1.0, < 0.2.0", "v0.3.0", "^v0.3.0"},
+		}
+
+		for _, test := range tests {
+			t.Run(test.Name, func(t *testing.T) {
+				assert.Equal(t, test.Out, upgradeRange(test.Current, test.Tag))
+			})
+		}
+	})
+
+	t.Run("Selects upgrade tags", func(t *testing.T) {
+		tags := make(Tags)
+		for _, v := range []string{"v0.1.0", "v0.2.0", "v1.0.0", "v1.1.0", "v2.0.0", "v2.1.0-rc1"} {
+			tags[v] = v
+		}
+
+		tests := []struct {
+			Name    string
+			Options UpgradeOptions
+			Out     string
+		}{
+			{"Minor upgrades stay within major version", UpgradeOptions{}, "v1.1.0"},
+			{"Major upgrades cross major versions", UpgradeOptions{Major: true}, "v2.0.0"},
+			{"Latest upgrades include prereleases", UpgradeOptions{Latest: true}, "v2.1.0-rc1"},
+			{"Explicit ranges are respected", UpgradeOptions{To: "^0.1.0"}, "v0.2.0"},
+		}
+
+		for _, test := range tests {
+			t.Run(test.Name, func(t *testing.T) {
+				latest, _, err := tags.GetLatest(test.Options.filter("^1.0.0", "v1.0.0"))
+				assert.Nil(t, err)
+				assert.Equal(t, test.Out, latest)
+			})
+		}
+	})
+
+	t.Run("Rejects conflicting options", func(t *testing.T) {
+		uo := UpgradeOptions{Major: true, Minor: true}
+		assert.NotNil(t, uo.validate())
+	})
+
+	t.Run("Selects prerelease tags", func(t *testing.T) {
+		gpmtest.RequireGit(t)
+
+		testDir, err := ioutil.TempDir("", "gpm-test")
+		if err != nil {
+			t.FailNow()
+		}
+		defer os.RemoveAll(testDir)
+
+		module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0", "v0.2.0-rc.1")
+		projectDir := filepath.Join(testDir, "project")
+		assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+		gpm := NewGPM(&CommonOptions{BasePath: projectDir, Verbose: true})
+		ctx := context.Background()
+		assert.Nil(t, gpm.writeProjectConfig(&ProjectConfig{Name: "project"}))
+		assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+		// Ranges naming a prerelease match prerelease tags
+		m, err := gpm.Add(ctx, &AddOptions{Path: "lib/rc", URL: module.URL(), Version: "^v0.2.0-rc.1"})
+		assert.Nil(t, err)
+		if assert.NotNil(t, m) {
+			assert.EqualValues(t, module.Hashes["v0.2.0-rc.1"], m.Hash)
+		}
+
+		// Upgrading to a latest prerelease enables prereleases for later updates
+		_, err = gpm.Add(ctx, &AddOptions{Path: "lib/a", URL: module.URL(), Version: "v0.1.0"})
+		assert.Nil(t, err)
+		uo := UpgradeOptions{Latest: true}
+		uo.Args.Path = "lib/a"
+		_, err = gpm.Upgrade(ctx, &uo)
+		assert.Nil(t, err)
+
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		d, _ := pc.Dependencies.Find("lib/a")
+		assert.True(t, d.Prerelease)
+		assert.EqualValues(t, "v0.2.0-rc.1", d.Version)
+
+		_, err = gpm.Update(ctx, &UpdateOptions{})
+		assert.Nil(t, err)
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, module.Hashes["v0.2.0-rc.1"], locks["lib/a"].Hash)
+		assert.EqualValues(t, module.Hashes["v0.2.0-rc.1"], locks["lib/rc"].Hash)
+
+		// Modules already at the selected version are not reported as upgraded
+		upgrades, err := gpm.Upgrade(ctx, &uo)
+		assert.Nil(t, err)
+		assert.Empty(t, upgrades)
+	})
+
+	t.Run("Rejects versions without matching tags", func(t *testing.T) {
+		gpmtest.RequireGit(t)
+
+		testDir, err := ioutil.TempDir("", "gpm-test")
+		if err != nil {
+			t.FailNow()
+		}
+		defer os.RemoveAll(testDir)
+
+		module := gpmtest.Versions(t, filepath.Join(testDir, "module"), "v0.1.0")
+		projectDir := filepath.Join(testDir, "project")
+		assert.Nil(t, os.Mkdir(projectDir, 0755))
+
+		gpm := NewGPM(&CommonOptions{BasePath: projectDir, Verbose: true})
+		ctx := context.Background()
+		assert.Nil(t, gpm.writeProjectConfig(&ProjectConfig{Name: "project"}))
+		assert.Nil(t, gpm.writeLockfile(&Locks{}))
+
+		_, err = gpm.Add(ctx, &AddOptions{Path: "lib/a", URL: module.URL(), Version: "^v1.0.0"})
+		assert.EqualValues(t, ErrNoMatchingTag, Code(err))
+		assert.NoDirExists(t, filepath.Join(projectDir, "lib/a"))
+
+		_, err = gpm.Add(ctx, &AddOptions{Path: "lib/a", URL: module.URL(), Version: "v0.1.0"})
+		assert.Nil(t, err)
+		pc, err := gpm.loadProjectConfig()
+		assert.Nil(t, err)
+		pc.Dependencies[0].Version = "^v1.0.0"
+		assert.Nil(t, gpm.writeProjectConfig(&pc))
+
+		_, err = gpm.Update(ctx, &UpdateOptions{})
+		assert.EqualValues(t, ErrNoMatchingTag, Code(err))
+		locks, err := gpm.loadLockfile()
+		assert.Nil(t, err)
+		assert.EqualValues(t, module.Hashes["v0.1.0"], locks["lib/a"].Hash)
+	})
+}
@@ -0,0 +1,241 @@
+package gpm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// yamlIndent is the indentation used when writing yaml files
+	yamlIndent = 2
+	// yamlIdentityKey is the key used to match entries in yaml sequences
+	yamlIdentityKey = "path"
+)
+
+// encodeYaml encodes a yaml node tree or object using the standard indentation
+func encodeYaml(obj interface{}) ([]byte, error) {
+	buff := bytes.NewBuffer(nil)
+
+	enc := yaml.NewEncoder(buff)
+	enc.SetIndent(yamlIndent)
+	if err := enc.Encode(obj); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// mergeYaml updates an existing yaml document to match the provided object.
+// Only changed nodes are modified, so comments, ordering and formatting of
+// unchanged entries are preserved.
+func mergeYaml(data []byte, obj interface{}) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("Error decoding file (%s)", err)
+	}
+
+	var updated yaml.Node
+	if err := updated.Encode(obj); err != nil {
+		return nil, err
+	}
+
+	// Empty documents are replaced outright
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return encodeYaml(&updated)
+	}
+
+	mergeNode(doc.Content[0], &updated)
+
+	return encodeYaml(&doc)
+}
+
+// mergeNode updates the existing node to match the updated node in place
+func mergeNode(existing, updated *yaml.Node) {
+	if existing.Kind != updated.Kind {
+		replaceNode(existing, updated)
+		return
+	}
+
+	switch existing.Kind {
+	case yaml.MappingNode:
+		mergeMapping(existing, updated)
+	case yaml.SequenceNode:
+		mergeSequence(existing, updated)
+	case yaml.ScalarNode:
+		if existing.Value != updated.Value || existing.ShortTag() != updated.ShortTag() {
+			replaceNode(existing, updated)
+		}
+	default:
+		replaceNode(existing, updated)
+	}
+}
+
+// mergeMapping merges mapping nodes, updating matching keys in place, appending new keys
+// and removing keys no longer present
+func mergeMapping(existing, updated *yaml.Node) {
+	content := make([]*yaml.Node, 0, len(updated.Content))
+
+	// Retain existing keys in their existing order
+	for i := 0; i+1 < len(existing.Content); i += 2 {
+		k, v := existing.Content[i], existing.Content[i+1]
+		if uv := mappingValue(updated, k.Value); uv != nil {
+			mergeNode(v, uv)
+			content = append(content, k, v)
+		}
+	}
+
+	// Append new keys
+	for i := 0; i+1 < len(updated.Content); i += 2 {
+		k, v := updated.Content[i], updated.Content[i+1]
+		if mappingValue(existing, k.Value) == nil {
+			content = append(content, k, v)
+		}
+	}
+
+	existing.Content = content
+}
+
+// mergeSequence merges sequence nodes, matching mapping entries by identity key
+// (or position where no identity is available)
+func mergeSequence(existing, updated *yaml.Node) {
+	content := make([]*yaml.Node, 0, len(updated.Content))
+	used := make(map[*yaml.Node]bool)
+
+	for i, u := range updated.Content {
+		var match *yaml.Node
+
+		if id := mappingValue(u, yamlIdentityKey); id != nil {
+			for _, e := range existing.Content {
+				if eid := mappingValue(e, yamlIdentityKey); !used[e] && eid != nil && eid.Value == id.Value {
+					match = e
+					break
+				}
+			}
+		} else if i < len(existing.Content) && mappingValue(existing.Content[i], yamlIdentityKey) == nil {
+			match = existing.Content[i]
+		}
+
+		if match == nil {
+			content = append(content, u)
+			continue
+		}
+
+		used[match] = true
+		mergeNode(match, u)
+		content = append(content, match)
+	}
+
+	existing.Content = content
+}
+
+// replaceNode replaces the contents of a node while preserving attached comments
+func replaceNode(existing, updated *yaml.Node) {
+	head, line, foot := existing.HeadComment, existing.LineComment, existing.FootComment
+	*existing = *updated
+	existing.HeadComment, existing.LineComment, existing.FootComment = head, line, foot
+}
+
+// mappingValue fetches the value node for a key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// formatYaml re-encodes a yaml document in the canonical order and style of the provided
+// object, carrying comments over from matching entries in the existing document
+func formatYaml(data []byte, obj interface{}) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("Error decoding file (%s)", err)
+	}
+
+	var formatted yaml.Node
+	if err := formatted.Encode(obj); err != nil {
+		return nil, err
+	}
+
+	// Empty documents have no comments to carry over
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return encodeYaml(&formatted)
+	}
+
+	copyComments(doc.Content[0], &formatted)
+	keepHeader(doc.Content[0], &formatted)
+	doc.Content = []*yaml.Node{&formatted}
+
+	return encodeYaml(&doc)
+}
+
+// copyComments copies comments from an existing node tree to the matching nodes of
+// a re-encoded tree, matching sequence entries as mergeSequence does
+func copyComments(existing, formatted *yaml.Node) {
+	formatted.HeadComment = existing.HeadComment
+	formatted.LineComment = existing.LineComment
+	formatted.FootComment = existing.FootComment
+
+	switch {
+	case existing.Kind == yaml.MappingNode && formatted.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(formatted.Content); i += 2 {
+			k, v := formatted.Content[i], formatted.Content[i+1]
+			for j := 0; j+1 < len(existing.Content); j += 2 {
+				if existing.Content[j].Value == k.Value {
+					copyComments(existing.Content[j], k)
+					copyComments(existing.Content[j+1], v)
+					break
+				}
+			}
+		}
+	case existing.Kind == yaml.SequenceNode && formatted.Kind == yaml.SequenceNode:
+		used := make(map[*yaml.Node]bool)
+		for i, f := range formatted.Content {
+			var match *yaml.Node
+			if id := mappingValue(f, yamlIdentityKey); id != nil {
+				for _, e := range existing.Content {
+					if eid := mappingValue(e, yamlIdentityKey); !used[e] && eid != nil && eid.Value == id.Value {
+						match = e
+						break
+					}
+				}
+			} else if i < len(existing.Content) {
+				match = existing.Content[i]
+			}
+			if match != nil {
+				used[match] = true
+				copyComments(match, f)
+			}
+		}
+	}
+}
+
+// keepHeader keeps the comment heading the first entry of a mapping (typically a file
+// header) at the start of the re-encoded mapping where the first entry has moved
+func keepHeader(existing, formatted *yaml.Node) {
+	if existing.Kind != yaml.MappingNode || formatted.Kind != yaml.MappingNode || len(existing.Content) == 0 || len(formatted.Content) == 0 {
+		return
+	}
+	first := existing.Content[0]
+	if first.HeadComment == "" || first.Value == formatted.Content[0].Value {
+		return
+	}
+
+	for i := 0; i < len(formatted.Content); i += 2 {
+		if formatted.Content[i].Value == first.Value {
+			formatted.Content[i].HeadComment = ""
+		}
+	}
+	head := formatted.Content[0]
+	head.HeadComment = strings.TrimSpace(first.HeadComment + "\n" + head.HeadComment)
+}
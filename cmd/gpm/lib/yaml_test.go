@@ -0,0 +1,59 @@
+package gpm
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestYaml(t *testing.T) {
+
+	original, err := ioutil.ReadFile("testdata/annotated.yml")
+	assert.Nil(t, err)
+
+	updated, err := ioutil.ReadFile("testdata/annotated.updated.yml")
+	assert.Nil(t, err)
+
+	load := func(t *testing.T, data []byte) ProjectConfig {
+		pc := ProjectConfig{}
+		assert.Nil(t, yaml.Unmarshal(data, &pc))
+		return pc
+	}
+
+	t.Run("Round-trips unmodified project files", func(t *testing.T) {
+		pc := load(t, original)
+
+		out, err := mergeYaml(original, &pc)
+		assert.Nil(t, err)
+		assert.Equal(t, string(original), string(out))
+	})
+
+	t.Run("Preserves comments when modifying dependencies", func(t *testing.T) {
+		pc := load(t, original)
+
+		d, _ := NewDependency("lib/core", "https://github.com/ryankurte/test-repo", "^0.2.0")
+		pc.Dependencies.Set(d.Path, *d)
+		pc.Dependencies.Delete("lib/fixtures")
+		d, _ = NewDependency("lib/extra", "https://github.com/ryankurte/test-repo", "v0.1.0")
+		pc.Dependencies = append(pc.Dependencies, *d)
+
+		out, err := mergeYaml(original, &pc)
+		assert.Nil(t, err)
+		assert.Equal(t, string(updated), string(out))
+	})
+
+	t.Run("Retains key ordering", func(t *testing.T) {
+		in := []byte("repository: a\nname: b\n")
+		out, err := mergeYaml(in, &ProjectConfig{Name: "c", Repository: "a"})
+		assert.Nil(t, err)
+		assert.Equal(t, "repository: a\nname: c\n", string(out))
+	})
+
+	t.Run("Writes new files when empty", func(t *testing.T) {
+		out, err := mergeYaml([]byte{}, &ProjectConfig{Name: "c"})
+		assert.Nil(t, err)
+		assert.Equal(t, "name: c\n", string(out))
+	})
+}
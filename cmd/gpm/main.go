@@ -1,44 +1,234 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/ryankurte/utils/cmd/gpm/lib"
 )
 
+// Output is the machine readable output for a command
+type Output struct {
+	Command string       `json:"command"`
+	OK      bool         `json:"ok"`
+	Result  interface{}  `json:"result,omitempty"`
+	Error   *OutputError `json:"error,omitempty"`
+}
+
+// OutputError is the machine readable output for a command error
+type OutputError struct {
+	Code        int              `json:"code"`
+	Name        string           `json:"name"`
+	Message     string           `json:"message"`
+	Diagnostics []gpm.Diagnostic `json:"diagnostics,omitempty"` // Problems located in the project file, where available
+}
+
 func main() {
 	o := gpm.Options{}
 	p := flags.NewParser(&o, flags.Default)
-	p.Parse()
+	if _, err := p.Parse(); err != nil {
+		var e *flags.Error
+		if errors.As(err, &e) && e.Type == flags.ErrHelp {
+			return
+		}
+		// Options may not be parsed where parsing fails, so check the arguments for JSON output
+		if o.Output == "json" || jsonOutput(os.Args[1:]) {
+			exit(activeCommand(p), nil, &gpm.Error{Code: gpm.ErrInvalidOptions, Message: "Invalid options", Err: err}, true)
+		}
+		os.Exit(int(gpm.ErrInvalidOptions))
+	}
 
-	log.Printf("Options: %+v", o)
+	if o.Verbose {
+		log.Printf("Options: %+v", o)
+	}
 
 	if p.Active == nil {
-		log.Printf("No command selected")
-		return
+		exit("", nil, &gpm.Error{Code: gpm.ErrInvalidOptions, Message: "No command selected"}, o.Output == "json")
 	}
 
-	gpm := gpm.NewGPM(&o.CommonOptions)
+	// The interactive UI cannot produce machine readable output
+	if p.Active.Name == "ui" && o.Output == "json" {
+		exit(p.Active.Name, nil, &gpm.Error{Code: gpm.ErrInvalidOptions, Message: "The ui command does not support --output json"}, true)
+	}
 
+	g := gpm.NewGPM(&o.CommonOptions)
+
+	// Cancel the running command on interrupt, rolling back any changes
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	var res interface{}
 	var err error
 
 	switch p.Active.Name {
 	case "init":
-		err = gpm.Init(&o.Init)
+		res, err = g.Init(ctx, &o.Init)
 	case "add":
-		err = gpm.Add(&o.Add)
+		res, err = g.Add(ctx, &o.Add)
 	case "sync":
-		err = gpm.Sync(&o.Sync)
+		res, err = g.Sync(ctx, &o.Sync)
 	case "update":
-		err = gpm.Update(&o.Update)
+		res, err = g.Update(ctx, &o.Update)
+	case "upgrade":
+		var upgrades []gpm.Upgrade
+		upgrades, err = g.Upgrade(ctx, &o.Upgrade)
+		if o.Output != "json" {
+			for _, u := range upgrades {
+				fmt.Println(u)
+			}
+		}
+		res = upgrades
 	case "remove":
-		err = gpm.Remove(&o.Remove)
+		res, err = g.Remove(ctx, &o.Remove)
+	case "mv":
+		res, err = g.Move(ctx, &o.Move)
+	case "import":
+		res, err = g.Import(ctx, &o.Import)
+	case "check":
+		var checks []gpm.LicenseCheck
+		checks, err = g.Check(ctx, &o.Check)
+		if o.Output != "json" {
+			for _, c := range checks {
+				fmt.Println(c)
+			}
+		}
+		res = checks
+	case "release":
+		var release *gpm.Release
+		release, err = g.Release(ctx, &o.Release)
+		if o.Output != "json" && release != nil {
+			fmt.Print(release)
+		}
+		res = release
+	case "clean":
+		var orphans []gpm.Orphan
+		orphans, err = g.Clean(ctx, &o.Clean)
+		if o.Output != "json" {
+			for _, orphan := range orphans {
+				fmt.Println(orphan)
+			}
+		}
+		res = orphans
+	case "gc":
+		var collected []gpm.Collected
+		collected, err = g.GC(ctx, &o.GC)
+		if o.Output != "json" {
+			for _, c := range collected {
+				fmt.Println(c)
+			}
+		}
+		res = collected
+	case "fmt":
+		var formatted *gpm.Formatted
+		formatted, err = g.Fmt(ctx, &o.Fmt)
+		if o.Output != "json" && formatted != nil {
+			fmt.Println(formatted)
+		}
+		res = formatted
+	case "merge-lock":
+		var merges []gpm.LockMerge
+		merges, err = g.MergeLock(ctx, &o.MergeLock)
+		if o.Output != "json" {
+			for _, m := range merges {
+				fmt.Println(m)
+			}
+		}
+		res = merges
+	case "install-merge-driver":
+		err = g.InstallMergeDriver(ctx, &o.InstallMergeDriver)
+	case "search":
+		var entries []gpm.IndexEntry
+		entries, err = g.Search(ctx, &o.Search)
+		if o.Output != "json" {
+			for _, e := range entries {
+				fmt.Println(e)
+			}
+		}
+		res = entries
+	case "audit":
+		var findings []gpm.AuditFinding
+		findings, err = g.Audit(ctx, &o.Audit)
+		if o.Output != "json" {
+			for _, f := range findings {
+				fmt.Println(f)
+			}
+		}
+		res = findings
+	case "ui":
+		err = runUI(ctx, g)
+	case "export":
+		var doc string
+		doc, err = g.Export(ctx, &o.Export)
+		if o.Output != "json" && o.Export.File == "" {
+			fmt.Print(doc)
+		}
+		res = doc
 	}
 
-	if err != nil {
+	exit(p.Active.Name, res, err, o.Output == "json")
+}
+
+// exit writes the command result or error, as JSON output where enabled, then exits
+// with the error code
+func exit(command string, res interface{}, err error, asJSON bool) {
+	if asJSON {
+		writeOutput(command, res, err)
+	} else if err != nil {
 		log.Printf("Error: %s", err)
-		os.Exit(-1)
 	}
+
+	os.Exit(int(gpm.Code(err)))
+}
+
+// writeOutput writes the machine readable output for a command result or error to stdout
+func writeOutput(command string, res interface{}, err error) {
+	out := Output{Command: command, OK: err == nil}
+	if err != nil {
+		code := gpm.Code(err)
+		out.Error = &OutputError{Code: int(code), Name: code.String(), Message: err.Error()}
+		var diags gpm.Diagnostics
+		if errors.As(err, &diags) {
+			out.Error.Diagnostics = diags
+		}
+	} else {
+		out.Result = res
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if jerr := enc.Encode(&out); jerr != nil {
+		log.Printf("Error encoding output: %s", jerr)
+	}
+}
+
+// jsonOutput checks whether command line arguments select JSON output
+func jsonOutput(args []string) bool {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		if a == "--output=json" || a == "--output" && i+1 < len(args) && args[i+1] == "json" {
+			return true
+		}
+	}
+	return false
+}
+
+// activeCommand fetches the name of the selected command, if any
+func activeCommand(p *flags.Parser) string {
+	if p.Active == nil {
+		return ""
+	}
+	return p.Active.Name
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	"github.com/ryankurte/utils/cmd/gpm/lib"
+)
+
+const (
+	// uiPageSize is the number of items displayed in each list
+	uiPageSize = 12
+
+	uiAdd     = "Add module"
+	uiQuit    = "Quit"
+	uiVersion = "Change version"
+	uiRemove  = "Remove"
+	uiBack    = "Back"
+)
+
+// ui is an interactive terminal interface for adding, upgrading and removing modules
+type ui struct {
+	g *gpm.GPM
+}
+
+// runUI presents the project dependencies and applies the selected operations until the user quits
+func runUI(ctx context.Context, g *gpm.GPM) error {
+	u := ui{g: g}
+
+	for {
+		status, err := g.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		items := []string{uiAdd}
+		for _, s := range status {
+			items = append(items, s.String())
+		}
+		items = append(items, uiQuit)
+
+		sel := promptui.Select{Label: "Dependencies", Items: items, Size: uiPageSize}
+		i, _, err := sel.Run()
+		if interrupted(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case i == 0:
+			err = u.add(ctx)
+		case i == len(items)-1:
+			return nil
+		default:
+			err = u.module(ctx, status[i-1])
+		}
+
+		// Report failed operations and return to the dependency list
+		if gpm.Code(err) == gpm.ErrCanceled {
+			return err
+		}
+		if err != nil && !interrupted(err) && err != promptui.ErrAbort {
+			fmt.Printf("Error: %s\n", err)
+		}
+	}
+}
+
+// module presents the operations available for a module
+func (u *ui) module(ctx context.Context, s gpm.ModuleStatus) error {
+	sel := promptui.Select{Label: s.String(), Items: []string{uiVersion, uiRemove, uiBack}}
+	_, action, err := sel.Run()
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case uiVersion:
+		return u.version(ctx, s)
+	case uiRemove:
+		return u.remove(ctx, s)
+	}
+
+	return nil
+}
+
+// version selects a new tag for a module, previewing and applying the change
+func (u *ui) version(ctx context.Context, s gpm.ModuleStatus) error {
+	if len(s.Tags) == 0 {
+		fmt.Printf("No tags available for module '%s'\n", s.Path)
+		return nil
+	}
+
+	// Start at the locked tag, filtering tags by search input
+	cursor := 0
+	for i, t := range s.Tags {
+		if t == s.Tag {
+			cursor = i
+		}
+	}
+	scroll := cursor - uiPageSize + 1
+	if scroll < 0 {
+		scroll = 0
+	}
+
+	sel := promptui.Select{
+		Label: fmt.Sprintf("Version for %s (locked: %s, / to search)", s.Path, s.Tag),
+		Items: s.Tags,
+		Size:  uiPageSize,
+		Searcher: func(input string, index int) bool {
+			return strings.Contains(s.Tags[index], strings.TrimSpace(input))
+		},
+	}
+	_, tag, err := sel.RunCursorAt(cursor, scroll)
+	if err != nil {
+		return err
+	}
+	if tag == s.Tag {
+		fmt.Printf("Module '%s' is already locked to '%s'\n", s.Path, tag)
+		return nil
+	}
+
+	preview, err := u.g.Preview(ctx, s.Path, tag)
+	if err != nil {
+		return err
+	}
+	fmt.Print(preview)
+
+	if err := confirm("Apply"); err != nil {
+		return err
+	}
+
+	uo := gpm.UpgradeOptions{To: preview.NewVersion}
+	uo.Args.Path = s.Path
+	upgrades, err := u.g.Upgrade(ctx, &uo)
+	for _, up := range upgrades {
+		fmt.Println(up)
+	}
+
+	return err
+}
+
+// remove removes a module from the project after confirmation
+func (u *ui) remove(ctx context.Context, s gpm.ModuleStatus) error {
+	if err := confirm(fmt.Sprintf("Remove module '%s'", s.Path)); err != nil {
+		return err
+	}
+
+	m, err := u.g.Remove(ctx, &gpm.RemoveOptions{Path: s.Path})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed module '%s'\n", m.Path)
+
+	return nil
+}
+
+// add prompts for a new module, adding it to the project after confirmation
+func (u *ui) add(ctx context.Context) error {
+	required := func(s string) error {
+		if strings.TrimSpace(s) == "" {
+			return fmt.Errorf("Value is required")
+		}
+		return nil
+	}
+
+	ao := gpm.AddOptions{}
+	prompts := []struct {
+		prompt promptui.Prompt
+		value  *string
+	}{
+		{promptui.Prompt{Label: "Module path", Validate: required}, &ao.Path},
+		{promptui.Prompt{Label: "Module URL", Validate: required}, &ao.URL},
+		{promptui.Prompt{Label: "Version range (optional)"}, &ao.Version},
+	}
+	for _, p := range prompts {
+		v, err := p.prompt.Run()
+		if err != nil {
+			return err
+		}
+		*p.value = strings.TrimSpace(v)
+	}
+
+	if err := confirm(fmt.Sprintf("Add module '%s' from '%s'", ao.Path, ao.URL)); err != nil {
+		return err
+	}
+
+	m, err := u.g.Add(ctx, &ao)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added module '%s' at '%s' (%s)\n", m.Path, m.Tag, m.Hash)
+
+	return nil
+}
+
+// confirm prompts for a yes/no confirmation, returning promptui.ErrAbort if declined
+func confirm(label string) error {
+	p := promptui.Prompt{Label: label, IsConfirm: true}
+	_, err := p.Run()
+	return err
+}
+
+// interrupted checks whether a prompt was interrupted or closed
+func interrupted(err error) bool {
+	return err == promptui.ErrInterrupt || err == promptui.ErrEOF
+}